@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BlobStore 保存二進位檔案並提供下載網址。
+type BlobStore interface {
+	// Put 儲存檔案並回傳檔案的網址，privateBlobPrefixes 下的檔案需要改用 SignedURL 才能下載。
+	Put(ctx context.Context, key string, data []byte, contentType string) (string, error)
+	// Delete 刪除檔案，檔案不存在時不回傳錯誤。
+	Delete(ctx context.Context, key string) error
+	// DeletePrefix 刪除所有以 prefix 開頭的檔案，回傳刪除的檔案數。
+	DeletePrefix(ctx context.Context, prefix string) (int, error)
+	// SignedURL 回傳在 expires 之前有效的下載網址，用於不應公開的檔案（例如匯出檔與名片照片）。
+	SignedURL(key string, expires time.Time) (string, error)
+	// Key 從 Put 或 SignedURL 回傳的網址取回檔案的 key，不是這個儲存的網址時回傳 false。
+	Key(rawURL string) (string, bool)
+}
+
+// privateBlobPrefixes 是不公開的檔案目錄，只能以 SignedURL 的網址下載。
+var privateBlobPrefixes = []string{"exports/", "cards/"}
+
+// isPrivateBlob 回傳 key 是否在 privateBlobPrefixes 之下，會先清理 key 中的 .. 等路徑。
+func isPrivateBlob(key string) bool {
+	clean := strings.TrimPrefix(filepath.ToSlash(filepath.Clean("/"+key)), "/")
+	for _, prefix := range privateBlobPrefixes {
+		if strings.HasPrefix(clean, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// keyFromURL 將網址中 base 之後的路徑作為 key，忽略查詢字串。
+func keyFromURL(rawURL string, bases ...string) (string, bool) {
+	rawURL, _, _ = strings.Cut(rawURL, "?")
+	for _, base := range bases {
+		if base == "" {
+			continue
+		}
+		if key, ok := strings.CutPrefix(rawURL, strings.TrimSuffix(base, "/")+"/"); ok && key != "" {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// LocalBlobStore 將檔案存放在本地磁碟，並由 bot 的 HTTP server 提供下載。
+type LocalBlobStore struct {
+	// Dir 是存放檔案的目錄
+	Dir string
+	// BaseURL 是對外公開的網址前綴，例如 https://example.herokuapp.com/images
+	BaseURL string
+	// Secret 是簽署下載網址的金鑰
+	Secret string
+}
+
+// Put 將檔案寫入本地目錄。
+func (l *LocalBlobStore) Put(_ context.Context, key string, data []byte, _ string) (string, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(l.BaseURL, "/") + "/" + key, nil
+}
+
+// Delete 刪除本地檔案。
+func (l *LocalBlobStore) Delete(_ context.Context, key string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// DeletePrefix 刪除 prefix 目錄下的所有檔案。
+func (l *LocalBlobStore) DeletePrefix(_ context.Context, prefix string) (int, error) {
+	dir, err := l.path(prefix)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	err = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, os.RemoveAll(dir)
+}
+
+// SignedURL 回傳帶有到期時間與 HMAC 簽章的下載網址。
+func (l *LocalBlobStore) SignedURL(key string, expires time.Time) (string, error) {
+	if l.Secret == "" {
+		return "", fmt.Errorf("no secret for signed URLs")
+	}
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("sig", l.signature(key, query.Get("expires")))
+	return strings.TrimSuffix(l.BaseURL, "/") + "/" + key + "?" + query.Encode(), nil
+}
+
+// Key 從 BaseURL 下的網址取回檔案的 key。
+func (l *LocalBlobStore) Key(rawURL string) (string, bool) {
+	return keyFromURL(rawURL, l.BaseURL)
+}
+
+// signature 計算 key 在 expires（Unix 秒）之前有效的簽章。
+func (l *LocalBlobStore) signature(key, expires string) string {
+	return hex.EncodeToString(hmacSHA256([]byte(l.Secret), key+"\n"+expires))
+}
+
+// ServeHTTP 提供本地檔案下載，不列出目錄內容。privateBlobPrefixes 下的檔案需要未過期的簽章。
+func (l *LocalBlobStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	path, err := l.path(key)
+	if err != nil || strings.HasSuffix(r.URL.Path, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	if isPrivateBlob(key) {
+		expires := r.URL.Query().Get("expires")
+		unix, err := strconv.ParseInt(expires, 10, 64)
+		if err != nil || l.Secret == "" || time.Now().Unix() > unix ||
+			!hmac.Equal([]byte(r.URL.Query().Get("sig")), []byte(l.signature(key, expires))) {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	http.ServeFile(w, r, path)
+}
+
+// path 將 key 轉為本地路徑，並拒絕跳出 Dir 的路徑。
+func (l *LocalBlobStore) path(key string) (string, error) {
+	clean := filepath.Clean("/" + key)
+	if clean == "/" {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+	return filepath.Join(l.Dir, clean), nil
+}
+
+// S3BlobStore 將檔案存放在 S3 相容的物件儲存（AWS S3、GCS XML API、MinIO、R2 等），使用 SigV4 簽章。
+type S3BlobStore struct {
+	// Endpoint 例如 https://s3.ap-northeast-1.amazonaws.com 或 https://storage.googleapis.com
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PublicURL 是對外公開的網址前綴，未設定時為 Endpoint/Bucket
+	PublicURL string
+
+	HTTPClient *http.Client
+}
+
+// Put 以 PUT Object 上傳檔案。
+func (s *S3BlobStore) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	req, err := s.newRequest(ctx, http.MethodPut, key, data, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if err := s.do(req); err != nil {
+		return "", err
+	}
+
+	base := s.PublicURL
+	if base == "" {
+		base = strings.TrimSuffix(s.Endpoint, "/") + "/" + s.Bucket
+	}
+	return strings.TrimSuffix(base, "/") + "/" + key, nil
+}
+
+// Delete 以 DELETE Object 刪除檔案。
+func (s *S3BlobStore) Delete(ctx context.Context, key string) error {
+	req, err := s.newRequest(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	return s.do(req)
+}
+
+// SignedURL 回傳 SigV4 預先簽章的 GET 網址，最長有效 7 天。
+func (s *S3BlobStore) SignedURL(key string, expires time.Time) (string, error) {
+	endpoint, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid endpoint: %w", err)
+	}
+	endpoint.Path = "/" + s.Bucket + "/" + key
+
+	now := time.Now().UTC()
+	ttl := int64(expires.Sub(now) / time.Second)
+	if ttl < 1 || ttl > 7*24*60*60 {
+		return "", fmt.Errorf("signed URL must expire within 7 days, got %ds", ttl)
+	}
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", s.AccessKey+"/"+scope)
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.FormatInt(ttl, 10))
+	query.Set("X-Amz-SignedHeaders", "host")
+	endpoint.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		endpoint.EscapedPath(),
+		endpoint.RawQuery,
+		"host:" + endpoint.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+	signature := hex.EncodeToString(hmacSHA256(s.signingKey(date), stringToSign))
+	return endpoint.String() + "&X-Amz-Signature=" + signature, nil
+}
+
+// Key 從 PublicURL 或 Endpoint/Bucket 下的網址取回檔案的 key。
+func (s *S3BlobStore) Key(rawURL string) (string, bool) {
+	return keyFromURL(rawURL, s.PublicURL, strings.TrimSuffix(s.Endpoint, "/")+"/"+s.Bucket)
+}
+
+// listBucketResult 是 ListObjectsV2 回應中需要的欄位。
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// DeletePrefix 以 ListObjectsV2 列出 prefix 下的檔案後逐一刪除。
+func (s *S3BlobStore) DeletePrefix(ctx context.Context, prefix string) (int, error) {
+	n := 0
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", prefix)
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		req, err := s.newRequest(ctx, http.MethodGet, "", nil, query)
+		if err != nil {
+			return n, err
+		}
+		client := s.HTTPClient
+		if client == nil {
+			client = http.DefaultClient
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return n, fmt.Errorf("error making request: %w", err)
+		}
+		var result listBucketResult
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return n, fmt.Errorf("object storage list %s: %s", prefix, resp.Status)
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return n, fmt.Errorf("error parsing object list: %w", err)
+		}
+
+		for _, c := range result.Contents {
+			if err := s.Delete(ctx, c.Key); err != nil {
+				return n, err
+			}
+			n++
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return n, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// newRequest 建立以 path-style 網址存取物件、並已簽章的請求。
+func (s *S3BlobStore) newRequest(ctx context.Context, method, key string, body []byte, query url.Values) (*http.Request, error) {
+	endpoint, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint: %w", err)
+	}
+	endpoint.Path = "/" + s.Bucket
+	if key != "" {
+		endpoint.Path += "/" + key
+	}
+	// SigV4 的 canonical query 需要依 key 排序，並以 %20 表示空白
+	endpoint.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, body, time.Now().UTC())
+	return req, nil
+}
+
+// do 送出請求並檢查回應狀態。
+func (s *S3BlobStore) do(req *http.Request) error {
+	client := s.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error making request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 && !(req.Method == http.MethodDelete && resp.StatusCode == http.StatusNotFound) {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("object storage %s %s: %s %s", req.Method, req.URL.Path, resp.Status, body)
+	}
+	return nil
+}
+
+// sign 依照 AWS Signature Version 4 為請求加上 Authorization header。
+func (s *S3BlobStore) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:" + payloadHash + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	signature := hex.EncodeToString(hmacSHA256(s.signingKey(date), stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature))
+}
+
+// signingKey 回傳 date（YYYYMMDD）當天的 SigV4 簽章金鑰。
+func (s *S3BlobStore) signingKey(date string) []byte {
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	return hmacSHA256(key, "aws4_request")
+}
+
+// randomHex 產生 n bytes 的隨機十六進位字串，用於不易猜測的檔案名稱。
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalBlobStore(t *testing.T) {
+	store := &LocalBlobStore{Dir: t.TempDir(), BaseURL: "https://bot.example.com/images/", Secret: "secret"}
+	ctx := context.Background()
+
+	url, err := store.Put(ctx, "cards/uid/a.jpg", []byte("jpeg"), "image/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != "https://bot.example.com/images/cards/uid/a.jpg" {
+		t.Errorf("url = %q", url)
+	}
+	if key, ok := store.Key(url + "?sig=x"); !ok || key != "cards/uid/a.jpg" {
+		t.Errorf("Key(%q) = %q, %v", url, key, ok)
+	}
+	if _, ok := store.Key("https://other.example.com/images/cards/uid/a.jpg"); ok {
+		t.Error("Key() accepted another host")
+	}
+
+	srv := httptest.NewServer(http.StripPrefix("/images", store))
+	defer srv.Close()
+	store.BaseURL = srv.URL + "/images/"
+
+	// 名片照片不公開，需要簽章網址
+	resp, err := http.Get(srv.URL + "/images/cards/uid/a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET unsigned card = %d, want 404", resp.StatusCode)
+	}
+	signed, err := store.SignedURL("cards/uid/a.jpg", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = http.Get(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "jpeg" {
+		t.Errorf("GET = %d %q", resp.StatusCode, body)
+	}
+
+	// 不列出目錄內容
+	resp, err = http.Get(srv.URL + "/images/cards/uid/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET directory = %d, want 404", resp.StatusCode)
+	}
+
+	if err := store.Delete(ctx, "cards/uid/a.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, "cards/uid/a.jpg"); err != nil {
+		t.Errorf("Delete missing file = %v, want nil", err)
+	}
+}
+
+func TestS3BlobStore(t *testing.T) {
+	var gotMethod, gotPath, gotAuth, gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotMethod, gotPath, gotAuth, gotBody = r.Method, r.URL.Path, r.Header.Get("Authorization"), string(body)
+	}))
+	defer srv.Close()
+
+	store := &S3BlobStore{
+		Endpoint:  srv.URL,
+		Region:    "auto",
+		Bucket:    "cards",
+		AccessKey: "AKID",
+		SecretKey: "secret",
+		PublicURL: "https://cdn.example.com",
+	}
+	url, err := store.Put(context.Background(), "cards/uid/a.jpg", []byte("jpeg"), "image/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != "https://cdn.example.com/cards/uid/a.jpg" {
+		t.Errorf("url = %q", url)
+	}
+	if gotMethod != http.MethodPut || gotPath != "/cards/cards/uid/a.jpg" || gotBody != "jpeg" {
+		t.Errorf("request = %s %s %q", gotMethod, gotPath, gotBody)
+	}
+	if !strings.HasPrefix(gotAuth, "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(gotAuth, "/auto/s3/aws4_request") {
+		t.Errorf("Authorization = %q", gotAuth)
+	}
+}
+
+func TestCropCardImage(t *testing.T) {
+	// 深色桌面上的一張白色名片
+	img := image.NewRGBA(image.Rect(0, 0, 400, 300))
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 400; x++ {
+			c := color.RGBA{30, 30, 30, 255}
+			if x >= 100 && x < 300 && y >= 100 && y < 220 {
+				c = color.RGBA{250, 250, 250, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := cropCardImage(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cropped, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w, h := cropped.Bounds().Dx(), cropped.Bounds().Dy(); w < 200 || w > 220 || h < 120 || h > 140 {
+		t.Errorf("cropped size = %dx%d, want about 200x120", w, h)
+	}
+}
+
+func TestStoreCardImagesUsesOpaquePrefixAndFormat(t *testing.T) {
+	s := &Server{Config: &Config{ChannelSecret: "secret"}}
+	dir := s.blobPrefix("U1234")
+	if strings.Contains(dir, "U1234") || dir == s.blobPrefix("U5678") || dir != s.blobPrefix("U1234") {
+		t.Fatalf("blobPrefix() = %q", dir)
+	}
+	s.Config.BlobPrefixKey = "other"
+	if s.blobPrefix("U1234") == dir {
+		t.Error("BLOB_PREFIX_KEY is ignored")
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	store := &LocalBlobStore{Dir: t.TempDir(), BaseURL: "https://bot.example.com/images/", Secret: "secret"}
+	original, cropped, err := storeCardImages(context.Background(), store, dir, buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := "https://bot.example.com/images/cards/" + dir + "/"
+	path, query, _ := strings.Cut(original, "?")
+	if !strings.HasPrefix(path, prefix) || !strings.HasSuffix(path, ".png") {
+		t.Errorf("original = %q, want a .png under %s", original, prefix)
+	}
+	if q, _ := url.ParseQuery(query); q.Get("sig") == "" || q.Get("expires") == "" {
+		t.Errorf("original = %q, want a signed URL", original)
+	}
+	if path, _, _ := strings.Cut(cropped, "?"); !strings.HasPrefix(path, prefix) || !strings.HasSuffix(path, "-crop.jpg") {
+		t.Errorf("cropped = %q", cropped)
+	}
+}
+
+func TestCardImageURLResigns(t *testing.T) {
+	store := &S3BlobStore{Endpoint: "https://s3.example.com", Region: "auto", Bucket: "cards", AccessKey: "AKID", SecretKey: "secret", PublicURL: "https://cdn.example.com"}
+	s := &Server{Images: store}
+
+	// Notion 中保存的舊網址（公開網址或已經過期的簽章網址）顯示時重新簽章
+	for _, stored := range []string{
+		"https://cdn.example.com/cards/book/a.jpg",
+		"https://s3.example.com/cards/cards/book/a.jpg?X-Amz-Date=20200101T000000Z&X-Amz-Signature=old",
+	} {
+		u, err := url.Parse(s.cardImageURL(stored))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.Host != "s3.example.com" || u.Path != "/cards/cards/book/a.jpg" || u.Query().Get("X-Amz-Signature") == "old" || u.Query().Get("X-Amz-Signature") == "" {
+			t.Errorf("cardImageURL(%q) = %q, want a fresh presigned URL", stored, u)
+		}
+	}
+	if got := s.cardImageURL("https://example.com/logo.jpg"); got != "https://example.com/logo.jpg" {
+		t.Errorf("cardImageURL() for another host = %q, want it unchanged", got)
+	}
+}
+
+func TestLocalBlobStoreSignedURL(t *testing.T) {
+	store := &LocalBlobStore{Dir: t.TempDir(), Secret: "secret"}
+	srv := httptest.NewServer(http.StripPrefix("/images", store))
+	defer srv.Close()
+	store.BaseURL = srv.URL + "/images/"
+	ctx := context.Background()
+
+	public, err := store.Put(ctx, "exports/book/a.csv", []byte("csv"), "text/csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := store.SignedURL("exports/book/a.csv", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := store.SignedURL("exports/book/a.csv", time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		url  string
+		want int
+	}{
+		{signed, http.StatusOK},
+		{public, http.StatusNotFound},
+		{expired, http.StatusNotFound},
+		{strings.Replace(signed, "a.csv", "b.csv", 1), http.StatusNotFound},
+		{srv.URL + "/images/cards/../exports/book/a.csv", http.StatusNotFound},
+	} {
+		resp, err := http.Get(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("GET %s = %d, want %d", tt.url, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+func TestS3BlobStoreSignedURL(t *testing.T) {
+	store := &S3BlobStore{Endpoint: "https://s3.example.com", Region: "auto", Bucket: "cards", AccessKey: "AKID", SecretKey: "secret", PublicURL: "https://cdn.example.com"}
+
+	link, err := store.SignedURL("exports/book/a.csv", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if u.Host != "s3.example.com" || u.Path != "/cards/exports/book/a.csv" {
+		t.Errorf("SignedURL() = %q, want the bucket endpoint", link)
+	}
+	if q.Get("X-Amz-Expires") != "3600" && q.Get("X-Amz-Expires") != "3599" || !strings.HasPrefix(q.Get("X-Amz-Credential"), "AKID/") || len(q.Get("X-Amz-Signature")) != 64 {
+		t.Errorf("SignedURL() query = %v", q)
+	}
+
+	if _, err := store.SignedURL("exports/book/a.csv", time.Now().Add(8*24*time.Hour)); err == nil {
+		t.Error("SignedURL() longer than 7 days should fail")
+	}
+}
@@ -1,25 +1,29 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
-	"os"
+	"net/url"
+	"runtime/debug"
 	"strings"
+	"time"
 
-	"github.com/line/line-bot-sdk-go/v7/linebot"
 	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
 	"github.com/line/line-bot-sdk-go/v8/linebot/webhook"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Const variables of Prompts.
 const ImagePrompt = "這是一張名片，你是一個名片秘書。請將以下資訊整理成 json 給我。如果看不出來的，幫我填寫 N/A， 只好 json 就好:  Name, Title, Address, Email, Phone, Company.   其中 Phone 的內容格式為 #886-0123-456-789,1234. 沒有分機就忽略 ,1234"
 
 // replyText: Reply text message to LINE server.
-func replyText(replyToken, text string) error {
-	if _, err := bot.ReplyMessage(
+func (s *Server) replyText(ctx context.Context, replyToken, text string) error {
+	if _, err := s.Bot.ReplyMessage(
 		&messaging_api.ReplyMessageRequest{
 			ReplyToken: replyToken,
 			Messages: []messaging_api.MessageInterface{
@@ -31,203 +35,542 @@ func replyText(replyToken, text string) error {
 	); err != nil {
 		return err
 	}
+	loggerFrom(ctx).Debug("Replied with text")
 	return nil
 }
 
 // callbackHandler: Handle callback from LINE server.
-func callbackHandler(w http.ResponseWriter, r *http.Request) {
-	card_prompt := os.Getenv("CARD_PROMPT")
-	if card_prompt == "" {
-		card_prompt = ImagePrompt
-	}
-
-	cb, err := webhook.ParseRequest(ChannelSecret, r)
+func (s *Server) callbackHandler(w http.ResponseWriter, r *http.Request) {
+	// 限制 request body 大小，避免超大的請求佔用記憶體
+	r.Body = http.MaxBytesReader(w, r.Body, int64(s.Config.MaxBodyBytes))
+	cb, err := webhook.ParseRequest(s.Config.ChannelSecret, r)
 	if err != nil {
-		if err == linebot.ErrInvalidSignature {
+		var tooLarge *http.MaxBytesError
+		if err == webhook.ErrInvalidSignature {
 			w.WriteHeader(400)
+		} else if errors.As(err, &tooLarge) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
 		} else {
 			w.WriteHeader(500)
 		}
 		return
 	}
+	// 先回應 200，事件在背景依序處理，避免辨識名片等較慢的工作讓 LINE 等待逾時後重送。
+	// 背景工作不隨 request 結束而取消，關閉伺服器時會等待處理完成。
+	w.WriteHeader(http.StatusOK)
+	base := context.WithoutCancel(r.Context())
+	s.Go(func() {
+		for _, event := range cb.Events {
+			// 每個事件的 log 都帶有事件 ID，辨識、儲存與回覆的 log 可以串起來
+			s.handleEvent(beginEvent(base, event), event)
+		}
+	})
+}
 
-	for _, event := range cb.Events {
-		log.Printf("Got event %v", event)
-		switch e := event.(type) {
-		case webhook.MessageEvent:
-			switch message := e.Message.(type) {
-			// Handle only on text message
-			case webhook.TextMessageContent:
-				if message.Text == "test" {
-					cards := []Person{
-						{
-							Name:    "test",
-							Title:   "test",
-							Address: "test",
-							Email:   "test",
-							Phone:   "test",
-						},
-					}
-					if err := SendFlexMsg(e.ReplyToken, cards, "test card"); err != nil {
-						log.Print(err)
-					}
-					continue
-				}
+// handleEvent 處理一個 webhook 事件，ctx 帶有事件的 logger。
+func (s *Server) handleEvent(ctx context.Context, event webhook.EventInterface) {
+	elog := loggerFrom(ctx)
 
-				// 取得用戶 ID
-				var uID string
-				switch source := e.Source.(type) {
-				case webhook.UserSource:
-					uID = source.UserId
-				case webhook.GroupSource:
-					uID = source.UserId
-				case webhook.RoomSource:
-					uID = source.UserId
-				}
-				log.Println("Got text msg ID:", message.Id, " UID:", uID)
+	// LINE 重送的事件已經處理過（或正在處理）時不再處理
+	if !s.claimEvent(ctx, event) {
+		elog.Info("Skipping already processed event")
+		s.Metrics.DuplicateEvent()
+		return
+	}
+	// 處理完成後記錄為已處理；處理中發生 panic 時移除紀錄，讓重送的事件可以再處理一次
+	defer func() {
+		if r := recover(); r != nil {
+			elog.Error("Panic handling event", "panic", r, "stack", string(debug.Stack()))
+			s.releaseEvent(ctx, event)
+			return
+		}
+		s.completeEvent(ctx, event)
+	}()
+	// 這個事件中的 Gemini 呼叫記在傳送事件的用戶名下，群組中也是記在個別成員
+	ctx = withTokenSubject(ctx, eventInfo(event).UserID)
+	// 備註、寫信、提醒等對話狀態以傳送者區分，群組成員不會接續別人的對話
+	ctx = withSessionKey(ctx, eventInfo(event).Session)
+
+	elog.Info("Got event")
+	s.Metrics.ObserveEvent(event)
+	switch e := event.(type) {
+	case webhook.MessageEvent:
+		switch message := e.Message.(type) {
+		// Handle only on text message
+		case webhook.TextMessageContent:
+			// 取得名片簿 ID，群組中為群組共用的名片簿
+			uID := getBookID(e.Source)
+			elog.Info("Got text message", "message_id", message.Id, "book", uID)
+
+			//using test as keyword to query database
+			nDB := s.newNotionDB(ctx, uID)
+
+			// 管理員調整用戶或群組的每日配額
+			if s.handleQuotaText(ctx, e.ReplyToken, getUserID(e.Source), message.Text) {
+				return
+			}
 
-				//using test as keyword to query database
-				nDB := &NotionDB{
-					DatabaseID: os.Getenv("NOTION_DB_PAGEID"),
-					Token:      os.Getenv("NOTION_INTEGRATION_TOKEN"),
-					UID:        uID,
-				}
+			// 管理員查詢 Gemini 的 token 用量與估計費用
+			if s.handleTokenUsageText(ctx, e.ReplyToken, getUserID(e.Source), message.Text) {
+				return
+			}
 
-				// Query the database with the provided uID and text
-				results, err := nDB.QueryDatabaseContains(message.Text)
-				log.Println("Got results:", results)
+			// 管理員查看名片辨識提示，或指定用戶、群組使用的提示
+			if s.handlePromptText(ctx, e.ReplyToken, getUserID(e.Source), message.Text) {
+				return
+			}
 
-				// If there's an error or no results, reply with an error message
-				if err != nil || len(results) == 0 {
-					ret := "查不到資料，請重新輸入"
-					if err != nil {
-						ret = fmt.Sprintf("%s: %s", ret, err.Error())
-					}
-					if err := replyText(e.ReplyToken, ret); err != nil {
-						log.Print(err)
-					}
-					continue
-				}
+			// 掃描、搜尋、最近新增、匯出、說明、設定等指令
+			if s.handleCommandText(ctx, e.ReplyToken, nDB, message.Text, isGroupSource(e.Source)) {
+				return
+			}
 
-				log.Println("Got results:", results)
-				err = SendFlexMsg(e.ReplyToken, results, "根據關鍵字查詢結果")
-				if err != nil {
-					log.Println("Error send result", err)
-				}
+			// 引用名片訊息回覆，或自己按下「新增備註」後輸入的文字，會加到該名片的備註。
+			// 這兩種都是傳送者明確的操作，所以在群組的搜尋關鍵字判斷之前處理
+			if pageID, ok := s.noteTarget(sessionKey(e.Source), message.QuotedMessageId); ok {
+				s.addContactNote(ctx, e.ReplyToken, nDB, pageID, message.Text)
+				return
+			}
 
-			// Handle only on Sticker message
-			case webhook.StickerMessageContent:
-				// log sticker id and package id.
-				log.Printf("Got sticker message, packageID: %s, stickerID: %s", message.PackageId, message.StickerId)
-
-			// Handle only image message
-			case webhook.ImageMessageContent:
-				// 取得用戶 ID
-				var uID string
-				switch source := e.Source.(type) {
-				case webhook.UserSource:
-					uID = source.UserId
-				case webhook.GroupSource:
-					uID = source.UserId
-				case webhook.RoomSource:
-					uID = source.UserId
-				}
+			// 引用追蹤信草稿回覆的文字訊息視為修改要求
+			if s.handleEmailRevision(ctx, e.ReplyToken, sessionKey(e.Source), message.QuotedMessageId, message.Text) {
+				return
+			}
 
-				log.Println("Got img msg ID:", message.Id)
-				//Get image binary from LINE server based on message ID.
-				data, err := GetImageBinary(blob, message.Id)
-				if err != nil {
-					log.Println("Got GetMessageContent err:", err)
-					continue
+			// 「3天後提醒我聯絡王小明」或「提醒列表」
+			if s.handleReminderText(ctx, e.ReplyToken, nDB, message.Text) {
+				return
+			}
+
+			// 群組中只有「找 王小明」這類訊息才搜尋，避免一般聊天都被當成搜尋
+			query := message.Text
+			if isGroupSource(e.Source) {
+				keyword, ok := groupSearchKeyword(query)
+				if !ok {
+					return
 				}
+				query = keyword
+			}
+
+			// 每位用戶與每個群組的搜尋次數有上限
+			if !s.allowUsage(ctx, e.ReplyToken, UsageSearch, e.Source) {
+				return
+			}
 
-				// Chat with Image
-				ret, err := GeminiImage(data, card_prompt)
+			results, err := s.searchContacts(ctx, nDB, query, true)
+
+			// 群組名片簿推出前，群組中傳送的名片存在傳送者的個人名片簿；群組名片簿查不到時改查傳送者自己的名片簿。
+			// 語意搜尋總是會回傳最相近的名片，所以只用關鍵字與模糊搜尋，避免把不相關的個人名片顯示在群組中
+			title := "根據關鍵字查詢結果"
+			if err == nil && len(results) == 0 && isGroupSource(e.Source) && getUserID(e.Source) != "" {
+				results, err = s.searchContacts(ctx, s.newNotionDB(ctx, getUserID(e.Source)), query, false)
+				title = "群組名片簿沒有符合的名片，以下是你的個人名片簿的查詢結果"
+			}
+
+			// If there's an error or no results, reply with an error message
+			if err != nil || len(results) == 0 {
+				ret := "查不到資料，請重新輸入"
 				if err != nil {
-					ret = "無法辨識圖片內容文字，請重新輸入:" + err.Error()
-					if err := replyText(e.ReplyToken, ret); err != nil {
-						log.Print(err)
+					// 錯誤內容只記錄在 log，不回覆給用戶
+					elog.Error("Error searching contacts", "err", err)
+					ret = SearchFailedText
+					if errors.Is(err, ErrUnavailable) {
+						ret = SearchUnavailableText
 					}
-					continue
 				}
+				if err := s.replyText(ctx, e.ReplyToken, ret); err != nil {
+					elog.Error("Error replying", "err", err)
+				}
+				return
+			}
+
+			err = s.SendFlexMsg(ctx, e.ReplyToken, results, title)
+			if err != nil {
+				elog.Error("Error sending result", "err", err)
+			}
 
-				log.Println("Got GeminiImage ret:", ret)
+		// Handle only on Sticker message
+		case webhook.StickerMessageContent:
+			// log sticker id and package id.
+			elog.Info("Got sticker message", "package_id", message.PackageId, "sticker_id", message.StickerId)
 
-				// Remove first and last line,	which are the backticks.
-				jsonData := removeFirstAndLastLine(ret)
-				log.Println("Got jsonData:", jsonData)
+		// Handle only image message
+		case webhook.ImageMessageContent:
+			s.handleImageMessage(ctx, e, message)
 
-				// Parse json and insert NotionDB
-				var person Person
-				err = json.Unmarshal([]byte(jsonData), &person)
-				if err != nil {
-					log.Println("Error parsing JSON:", err)
-				}
+		// Handle audio message as voice note of the latest card.
+		case webhook.AudioMessageContent:
+			elog.Info("Got audio message", "message_id", message.Id)
+			if s.Transcriber == nil {
+				return
+			}
 
-				nDB := &NotionDB{
-					DatabaseID: os.Getenv("NOTION_DB_PAGEID"),
-					Token:      os.Getenv("NOTION_INTEGRATION_TOKEN"),
-					UID:        uID,
-				}
+			nDB := s.newNotionDB(ctx, getBookID(e.Source))
+			s.addVoiceNote(ctx, e.ReplyToken, nDB, sessionKey(e.Source), message.Id)
 
-				// Check email first before adding to database.
-				dbUser, err := nDB.QueryDatabaseByEmail(person.Email)
-				if err == nil && len(dbUser) > 0 {
-					log.Println("Already exist in DB", dbUser)
-					if err := SendFlexMsg(e.ReplyToken, dbUser, "已經存在於資料庫中，請勿重複輸入"); err != nil {
-						log.Println("Error send result", err)
-					}
-					continue
-				}
+		// Handle only video message
+		case webhook.VideoMessageContent:
+			elog.Info("Got video message", "message_id", message.Id)
 
-				// Add namecard to notion database.
-				err = nDB.AddPageToDatabase(person)
-				if err != nil {
-					log.Println("Error adding page to database:", err)
-				}
+		default:
+			elog.Warn("Unknown message")
+		}
+	case webhook.PostbackEvent:
+		elog.Info("Got postback", "data", e.Postback.Data)
+		s.handlePostback(ctx, e)
+	case webhook.JoinEvent:
+		s.handleJoin(ctx, e.ReplyToken)
+	case webhook.FollowEvent:
+		s.cancelUnfollowPurge(getUserID(e.Source))
+		s.handleFollow(ctx, e.ReplyToken)
+	case webhook.UnfollowEvent:
+		s.handleUnfollow(getUserID(e.Source))
+	case webhook.BeaconEvent:
+		elog.Info("Got beacon", "hwid", e.Beacon.Hwid)
+	}
+}
 
-				if err := SendFlexMsg(e.ReplyToken, []Person{person}, "新增到資料庫"); err != nil {
-					log.Println("Error send result", err)
-				}
+// searchContacts 依序以條件、關鍵字、模糊搜尋與語意搜尋（semantic 為 true 時）查詢名片簿 nDB，回傳第一個有結果的方式的結果。
+func (s *Server) searchContacts(ctx context.Context, nDB *NotionDB, query string, semantic bool) ([]Person, error) {
+	elog := loggerFrom(ctx)
+
+	// 有標籤或日期區間時，先以條件查詢再比對關鍵字
+	var results []Person
+	var err error
+	if q := parseSearchQuery(query); q.hasContext() {
+		results, err = nDB.QueryDatabaseByContext(ctx, q.Tags, q.From, q.To)
+		results = filterByKeyword(results, q.Keyword)
+		elog.Debug("Got context results", "count", len(results))
+	} else {
+		// Query the database with the provided uID and text
+		results, err = nDB.QueryDatabaseContains(ctx, query)
+		elog.Debug("Got results", "count", len(results))
+	}
 
-			// Handle only video message
-			case webhook.VideoMessageContent:
-				log.Println("Got video msg ID:", message.Id)
+	// 完全比對沒有結果時，改用本地模糊搜尋（錯字、繁簡、拼音）
+	if err == nil && len(results) == 0 {
+		results, err = s.state.fuzzy.SearchFuzzy(ctx, nDB, query)
+		elog.Debug("Got fuzzy results", "count", len(results))
+	}
 
-			default:
-				log.Printf("Unknown message: %v", message)
-			}
-		case webhook.PostbackEvent:
-			log.Printf("Got postback: %v", e.Postback.Data)
-		case webhook.JoinEvent:
-			log.Printf("Got join event")
-		case webhook.FollowEvent:
-			log.Printf("message: Got followed event")
-		case webhook.BeaconEvent:
-			log.Printf("Got beacon: " + e.Beacon.Hwid)
+	// 模糊搜尋也沒有結果時，以語意搜尋找最相近的名片
+	if err == nil && len(results) == 0 && semantic && s.Semantic != nil {
+		results, err = s.Semantic.SearchBook(ctx, nDB, query, SemanticTopK)
+		elog.Debug("Got semantic results", "count", len(results))
+	}
+	return results, err
+}
+
+// handleImageMessage 辨識名片照片並新增到名片簿。下載、辨識、重複檢查、新增與回覆各自記錄為一個 span。
+func (s *Server) handleImageMessage(ctx context.Context, e webhook.MessageEvent, message webhook.ImageMessageContent) {
+	// 取得名片簿 ID，群組中為群組共用的名片簿
+	uID := getBookID(e.Source)
+
+	// 用戶拒絕保存名片資料時不辨識名片
+	if s.Consents != nil && s.Consents.Declined(getUserID(e.Source)) {
+		s.replyConsent(ctx, e.ReplyToken)
+		return
+	}
+
+	// 每次掃描都會呼叫 Gemini，每位用戶與每個群組的次數有上限
+	if !s.allowUsage(ctx, e.ReplyToken, UsageScan, e.Source) {
+		return
+	}
+
+	ctx, span := s.startCardSpan(ctx, message.Id)
+	defer span.End()
+	elog := loggerFrom(ctx)
+
+	// 依照群組、用戶的指定與 LINE 語言設定選擇提示
+	prompt := s.cardPrompt(ctx, e.Source)
+	elog.Info("Got image message", "message_id", message.Id, "prompt", prompt.Version)
+	result, err := s.processCardImage(ctx, uID, message.Id, prompt)
+	switch {
+	case errors.Is(err, ErrUnavailable):
+		// Gemini 或 Notion 暫時無法使用：請用戶稍後再試，名片排入佇列稍後重新處理
+		text := CardUnavailableText
+		if s.queueCardImage(ctx, uID, getUserID(e.Source), message.Id, prompt) {
+			text = CardQueuedText
+		}
+		s.replyTraced(ctx, func() error { return s.replyText(ctx, e.ReplyToken, text) })
+	case err != nil:
+		return
+	case len(result.People) == 0:
+		s.replyTraced(ctx, func() error { return s.replyText(ctx, e.ReplyToken, result.Text) })
+	default:
+		s.rememberRecentContact(sessionKey(e.Source), result)
+		s.replyTraced(ctx, func() error { return s.SendFlexMsg(ctx, e.ReplyToken, result.People, result.Text) })
+	}
+}
+
+// startCardSpan 開始處理名片照片的 ProcessCardImage span，並在 log 加上 trace ID。
+func (s *Server) startCardSpan(ctx context.Context, messageID string) (context.Context, trace.Span) {
+	ctx, span := s.tracer().Start(ctx, "ProcessCardImage", trace.WithAttributes(attribute.String("line.message_id", messageID)))
+	if sc := span.SpanContext(); sc.IsValid() {
+		ctx = withLogger(ctx, loggerFrom(ctx).With("trace_id", sc.TraceID().String()))
+	}
+	return ctx, span
+}
+
+// cardResult 是名片照片的處理結果：People 為空時只回覆 Text，否則以 Text 為說明送出名片。
+type cardResult struct {
+	People []Person
+	Text   string
+}
+
+// processCardImage 下載圖片訊息 messageID、辨識名片並新增到 uID 的名片簿。
+// 無法下載照片時回傳錯誤；Gemini 或 Notion 暫時無法使用時回傳 ErrUnavailable，呼叫端可以稍後再處理一次。
+func (s *Server) processCardImage(ctx context.Context, uID, messageID string, prompt Prompt) (cardResult, error) {
+	elog := loggerFrom(ctx)
+
+	//Get image binary from LINE server based on message ID.
+	_, stage := s.tracer().Start(ctx, "GetImageBinary")
+	data, err := GetImageBinary(s.Blob, messageID)
+	stage.SetAttributes(attribute.Int("image.bytes", len(data)))
+	endSpan(stage, err)
+	if err != nil {
+		elog.Error("Error getting message content", "err", err)
+		s.Metrics.ExtractionFailed("download")
+		return cardResult{}, err
+	}
+
+	// Chat with Image
+	stageCtx, stage := s.tracer().Start(ctx, "ExtractCard")
+	start := time.Now()
+	var ret string
+	err = s.Gemini.Do(stageCtx, func(ctx context.Context) error {
+		var err error
+		ret, err = s.Cards.ReadCard(ctx, data, prompt.Text)
+		return err
+	})
+	s.Metrics.ObserveExtraction(time.Since(start))
+	endSpan(stage, err)
+	if err != nil {
+		s.Metrics.ExtractionFailed("read")
+		if errors.Is(err, ErrUnavailable) {
+			elog.Warn("Card reader unavailable", "err", err)
+			return cardResult{}, err
+		}
+		return cardResult{Text: "無法辨識圖片內容文字，請重新輸入:" + err.Error()}, nil
+	}
+
+	elog.Debug("Got card reader result", "result", ret)
+
+	// Remove first and last line,	which are the backticks.
+	jsonData := removeFirstAndLastLine(ret)
+
+	// Parse json and insert NotionDB
+	var person Person
+	err = json.Unmarshal([]byte(jsonData), &person)
+	if err != nil {
+		elog.Warn("Error parsing card JSON", "err", err)
+		s.Metrics.ExtractionFailed("parse")
+	}
+
+	person.PromptVersion = prompt.Version
+
+	// 查詢公司登記資料補上統一編號
+	person = s.Companies.Enrich(ctx, person)
+
+	nDB := s.newNotionDB(ctx, uID)
+
+	// Check email first before adding to database, then phone when the card has no email.
+	_, stage = s.tracer().Start(ctx, "QueryDuplicates")
+	dbUser, err := nDB.QueryDatabaseByEmail(ctx, person.Email)
+	if err == nil && len(dbUser) == 0 && (person.Email == "" || person.Email == "N/A") {
+		dbUser, err = nDB.QueryDatabaseByPhone(ctx, person.Phone)
+	}
+	stage.SetAttributes(attribute.Bool("contact.duplicate", err == nil && len(dbUser) > 0))
+	endSpan(stage, err)
+	if errors.Is(err, ErrUnavailable) {
+		// 無法確認是否重複時不新增，避免服務恢復後出現重複的名片
+		elog.Warn("Notion unavailable", "err", err)
+		return cardResult{}, err
+	}
+	if err == nil && len(dbUser) > 0 {
+		elog.Info("Contact already exists", "contact", dbUser[0])
+		s.Metrics.DuplicateDetected()
+		return cardResult{People: dbUser, Text: "已經存在於資料庫中，請勿重複輸入"}, nil
+	}
+
+	// 保存原始照片與裁切後的名片圖片
+	if s.Images != nil {
+		person.OriginalImageURL, person.ImageURL, err = storeCardImages(ctx, s.Images, s.blobPrefix(uID), data)
+		if err != nil {
+			elog.Error("Error storing card image", "err", err)
+		}
+	}
+
+	// Add namecard to notion database.
+	_, stage = s.tracer().Start(ctx, "AddPageToDatabase")
+	person, err = nDB.CreatePage(ctx, person)
+	endSpan(stage, err)
+	if errors.Is(err, ErrUnavailable) {
+		elog.Warn("Notion unavailable", "err", err)
+		return cardResult{}, err
+	}
+	if err != nil {
+		elog.Error("Error adding page to database", "err", err)
+	} else {
+		// 建立名片的向量並存入語意搜尋索引
+		s.indexContact(ctx, uID, person)
+	}
+	s.state.fuzzy.Invalidate(uID)
+	return cardResult{People: []Person{person}, Text: "新增到資料庫"}, nil
+}
+
+// rememberRecentContact 記錄傳送者最近新增的名片，之後一段時間內的語音訊息會加到這張名片的備註。
+func (s *Server) rememberRecentContact(key string, result cardResult) {
+	if len(result.People) > 0 && result.People[0].PageID != "" {
+		s.state.recent.Set(key, result.People[0].PageID)
+	}
+}
+
+// replyTraced 以 Reply span 記錄回覆，失敗時寫入 log。
+func (s *Server) replyTraced(ctx context.Context, reply func() error) {
+	_, span := s.tracer().Start(ctx, "Reply")
+	err := reply()
+	endSpan(span, err)
+	if err != nil {
+		loggerFrom(ctx).Error("Error sending result", "err", err)
+	}
+}
+
+// claimEvent 以 webhookEventId 將事件記錄為處理中，回傳是否需要處理。沒有 IdempotencyStore 或事件 ID 時一律處理，
+// 記錄失敗時也照常處理，寧可重複也不要漏掉事件。
+func (s *Server) claimEvent(ctx context.Context, event webhook.EventInterface) bool {
+	id := eventInfo(event).ID
+	if s.Idempotency == nil || id == "" {
+		return true
+	}
+	ok, err := s.Idempotency.Claim(id, time.Now())
+	if err != nil {
+		loggerFrom(ctx).Error("Error recording webhook event", "err", err)
+	}
+	return ok
+}
+
+// completeEvent 將 claimEvent 記錄的事件標記為已處理。
+func (s *Server) completeEvent(ctx context.Context, event webhook.EventInterface) {
+	id := eventInfo(event).ID
+	if s.Idempotency == nil || id == "" {
+		return
+	}
+	if err := s.Idempotency.Complete(id, time.Now()); err != nil {
+		loggerFrom(ctx).Error("Error recording webhook event", "err", err)
+	}
+}
+
+// releaseEvent 移除 claimEvent 記錄的事件，處理失敗的事件在 LINE 重送時會再處理一次。
+func (s *Server) releaseEvent(ctx context.Context, event webhook.EventInterface) {
+	id := eventInfo(event).ID
+	if s.Idempotency == nil || id == "" {
+		return
+	}
+	if err := s.Idempotency.Release(id); err != nil {
+		loggerFrom(ctx).Error("Error releasing webhook event", "err", err)
+	}
+}
+
+// getUserID: Get user ID from the event source.
+func getUserID(source webhook.SourceInterface) string {
+	switch s := source.(type) {
+	case webhook.UserSource:
+		return s.UserId
+	case webhook.GroupSource:
+		return s.UserId
+	case webhook.RoomSource:
+		return s.UserId
+	}
+	return ""
+}
+
+// getBookID: Get the address book owner of the event source. Members of a group or room share the group's book.
+func getBookID(source webhook.SourceInterface) string {
+	switch s := source.(type) {
+	case webhook.GroupSource:
+		return s.GroupId
+	case webhook.RoomSource:
+		return s.RoomId
+	}
+	return getUserID(source)
+}
+
+// isGroupSource: Check whether the event comes from a group or room.
+func isGroupSource(source webhook.SourceInterface) bool {
+	switch source.(type) {
+	case webhook.GroupSource, webhook.RoomSource:
+		return true
+	}
+	return false
+}
+
+// handlePostback: Handle postback actions from flex message buttons.
+func (s *Server) handlePostback(ctx context.Context, e webhook.PostbackEvent) {
+	elog := loggerFrom(ctx)
+	data, err := url.ParseQuery(e.Postback.Data)
+	if err != nil {
+		elog.Warn("Error parsing postback data", "err", err)
+		return
+	}
+
+	uID := getBookID(e.Source)
+	nDB := s.newNotionDB(ctx, uID)
+
+	switch data.Get("action") {
+	case "note":
+		// 下一則文字訊息會成為這張名片的備註
+		s.state.notes.Set(sessionKey(e.Source), data.Get("page"))
+		if err := s.replyText(ctx, e.ReplyToken, "請輸入備註，可以加上 #標籤、@活動名稱 與日期 (例如 2026-06-03)"); err != nil {
+			elog.Error("Error replying", "err", err)
+		}
+	case "metdate":
+		note := Person{MetDate: e.Postback.Params["date"]}
+		if err := s.updateContactContext(ctx, nDB, data.Get("page"), note, e.ReplyToken); err != nil {
+			elog.Error("Error updating met date", "err", err)
+		}
+	case "remind", "snooze", "cancel_reminder":
+		s.handleReminderPostback(ctx, e.ReplyToken, uID, data)
+	case "email", "email_revise", "email_done":
+		s.handleEmailPostback(ctx, e.ReplyToken, sessionKey(e.Source), nDB, data)
+	case "company":
+		s.handleCompanyPostback(ctx, e.ReplyToken, nDB, data)
+	case "consent":
+		s.handleConsentPostback(ctx, e.ReplyToken, getUserID(e.Source), data)
+	case "command":
+		// 圖文選單的按鈕
+		if c, ok := findCommandByName(data.Get("name")); ok {
+			s.runCommand(ctx, c, e.ReplyToken, nDB, isGroupSource(e.Source))
+		}
+	case "purge":
+		// 只能刪除自己的資料，群組共用的名片簿不能從群組中刪除
+		if !isGroupSource(e.Source) {
+			s.handlePurgePostback(ctx, e.ReplyToken, uID, data)
 		}
 	}
 }
 
 // ProcessImage: Process an image and reply with a text.
-func processImage(target, m_id, prompt, errMsg string, blob *messaging_api.MessagingApiBlobAPI) {
+func (s *Server) processImage(ctx context.Context, target, m_id, prompt, errMsg string, blob *messaging_api.MessagingApiBlobAPI) {
+	elog := loggerFrom(ctx)
+
 	// Get image data
 	data, err := GetImageBinary(blob, m_id)
 	if err != nil {
-		log.Printf("Got GetMessageContent err: %v", err)
+		elog.Error("Error getting message content", "err", err)
 		return
 	}
 
 	// Chat with Image
-	ret, err := GeminiImage(data, prompt)
+	ret, err := s.Cards.ReadCard(ctx, data, prompt)
 	if err != nil {
-		log.Printf("Got %s err: %v", errMsg, err)
+		elog.Error("Error reading image", "action", errMsg, "err", err)
 		return
 	}
 
 	// Determine the push msg target.
-	if err := replyText(target, ret); err != nil {
-		log.Print(err)
+	if err := s.replyText(ctx, target, ret); err != nil {
+		elog.Error("Error replying", "err", err)
 	}
 }
 
@@ -236,14 +579,13 @@ func GetImageBinary(blob *messaging_api.MessagingApiBlobAPI, messageID string) (
 	// Get image binary from LINE server based on message ID.
 	content, err := blob.GetMessageContent(messageID)
 	if err != nil {
-		log.Println("Got GetMessageContent err:", err)
+		return nil, fmt.Errorf("error getting message content: %w", err)
 	}
 	defer content.Body.Close()
 	data, err := io.ReadAll(content.Body)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("error reading message content: %w", err)
 	}
-
 	return data, nil
 }
 
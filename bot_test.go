@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCallbackHandlerInvalidSignature(t *testing.T) {
+	h := newWebhookHarness(t)
+	req := httptest.NewRequest(http.MethodPost, "/callback", strings.NewReader(`{"events":[]}`))
+	req.Header.Set("X-Line-Signature", "invalid")
+	rec := httptest.NewRecorder()
+	h.Server.callbackHandler(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+	if calls := h.Calls(); len(calls) != 0 {
+		t.Errorf("calls = %v, want none", calls)
+	}
+}
+
+func TestCallbackHandlerSearch(t *testing.T) {
+	h := newWebhookHarness(t)
+	h.AddContact("U1", Person{Name: "王小明", Title: "資安經理", Company: "台灣銀行", Email: "ming@bank.example"})
+
+	if status := h.Post(h.Text(userSource("U1"), "王小明")); status != http.StatusOK {
+		t.Fatalf("status = %d", status)
+	}
+	// 其他用戶查不到這張名片
+	h.Post(h.Text(userSource("U2"), "王小明"))
+
+	replies := h.Replies()
+	if len(replies) != 2 {
+		t.Fatalf("replies = %v, want 2", replies)
+	}
+	if !strings.Contains(replies[0], `"type":"flex"`) || !strings.Contains(replies[0], "台灣銀行") {
+		t.Errorf("reply = %s, want the card as a flex message", replies[0])
+	}
+	if !strings.Contains(replies[1], "查不到資料") {
+		t.Errorf("reply to another user = %s, want not found", replies[1])
+	}
+}
+
+func TestCallbackHandlerImageDeclinedConsent(t *testing.T) {
+	h := newWebhookHarness(t)
+	store, err := NewConsentStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Server.Consents = store
+	if err := store.Set("U1", false, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	h.SetCard("m1", `{"name":"王小明"}`)
+
+	h.Post(h.Image(userSource("U1"), "m1"))
+
+	// 拒絕保存資料時不下載也不辨識圖片，只重新詢問同意
+	calls := h.Calls()
+	if len(calls) != 1 || calls[0].Path != "/v2/bot/message/reply" || !strings.Contains(string(calls[0].Body), "action=consent") {
+		t.Errorf("calls = %s, want only the consent question", formatCalls(calls))
+	}
+	if pages, _ := h.Notion.NotionDB("db", "U1").QueryDatabaseByUID(context.Background()); len(pages) != 0 {
+		t.Errorf("pages = %+v, want none", pages)
+	}
+}
+
+// blockingCardReader 在 release 關閉前不回傳辨識結果。
+type blockingCardReader struct {
+	CardReader
+	release chan struct{}
+}
+
+func (b *blockingCardReader) ReadCard(ctx context.Context, imgData []byte, prompt string) (string, error) {
+	<-b.release
+	return b.CardReader.ReadCard(ctx, imgData, prompt)
+}
+
+func TestCallbackHandlerAcknowledgesBeforeProcessing(t *testing.T) {
+	h := newWebhookHarness(t)
+	h.SetCard("m1", "```json\n{\"name\":\"王小明\"}\n```")
+	reader := &blockingCardReader{CardReader: h.Server.Cards, release: make(chan struct{})}
+	h.Server.Cards = reader
+
+	body, err := json.Marshal(map[string]any{"destination": "Ubot", "events": []json.RawMessage{h.Image(userSource("U1"), "m1")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/callback", bytes.NewReader(body))
+	req.Header.Set("X-Line-Signature", signWebhook(h.Server.Config.ChannelSecret, body))
+	rec := httptest.NewRecorder()
+	h.Server.callbackHandler(rec, req)
+
+	// 辨識還沒完成就已經回應 200
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if replies := h.Replies(); len(replies) != 0 {
+		t.Errorf("replies = %v, want none before processing finishes", replies)
+	}
+
+	close(reader.release)
+	if err := h.Server.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if replies := h.Replies(); len(replies) != 1 || !strings.Contains(replies[0], "王小明") {
+		t.Errorf("replies = %v, want the card", replies)
+	}
+}
+
+func TestCallbackHandlerSearchErrorHidesDetails(t *testing.T) {
+	h := newWebhookHarness(t)
+	// Notion 拒絕請求時，錯誤內容只記錄在 log
+	h.Server.Config.NotionToken = "secret_invalid"
+
+	h.Post(h.Text(userSource("U1"), "王小明"))
+	replies := h.Replies()
+	if len(replies) != 1 || !strings.Contains(replies[0], SearchFailedText) {
+		t.Fatalf("replies = %v, want %q", replies, SearchFailedText)
+	}
+	if strings.Contains(replies[0], "token") || strings.Contains(replies[0], "401") {
+		t.Errorf("reply = %s, want no error details", replies[0])
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+const (
+	// CompaniesPerBubble 是公司列表每一頁顯示的公司數。
+	CompaniesPerBubble = 10
+	// MaxCarouselBubbles 是 LINE Flex carousel 最多可以放的 bubble 數。
+	MaxCarouselBubbles = 12
+)
+
+// replyCompanyList 處理「公司」指令，列出用戶名片中的所有公司。
+func (s *Server) replyCompanyList(ctx context.Context, replyToken string, nDB *NotionDB) {
+	people, err := nDB.QueryDatabaseByUID(ctx)
+	if err != nil {
+		loggerFrom(ctx).Error("Error querying contacts for companies", "err", err)
+		if err := s.replyText(ctx, replyToken, "無法取得名片資料，請稍後再試"); err != nil {
+			loggerFrom(ctx).Error("Error replying", "err", err)
+		}
+		return
+	}
+
+	groups := s.Companies.GroupByCompany(people)
+	if len(groups) == 0 {
+		if err := s.replyText(ctx, replyToken, "目前還沒有任何公司的名片"); err != nil {
+			loggerFrom(ctx).Error("Error replying", "err", err)
+		}
+		return
+	}
+
+	if _, err := s.Bot.ReplyMessage(
+		&messaging_api.ReplyMessageRequest{
+			ReplyToken: replyToken,
+			Messages: []messaging_api.MessageInterface{
+				&messaging_api.FlexMessage{
+					Contents: companyListFlex(groups),
+					AltText:  fmt.Sprintf("共有 %d 間公司，請到手機上查看", len(groups)),
+				},
+			},
+		},
+	); err != nil {
+		loggerFrom(ctx).Error("Error replying", "err", err)
+	}
+}
+
+// handleCompanyPostback 處理公司列表的點選，以 carousel 顯示該公司的所有聯絡人。
+func (s *Server) handleCompanyPostback(ctx context.Context, replyToken string, nDB *NotionDB, data url.Values) {
+	name := data.Get("name")
+	people, err := nDB.QueryDatabaseByUID(ctx)
+	if err != nil {
+		loggerFrom(ctx).Error("Error querying contacts for company", "err", err)
+		if err := s.replyText(ctx, replyToken, "無法取得名片資料，請稍後再試"); err != nil {
+			loggerFrom(ctx).Error("Error replying", "err", err)
+		}
+		return
+	}
+
+	var contacts []Person
+	for _, g := range s.Companies.GroupByCompany(people) {
+		if g.Name == name {
+			contacts = g.People
+			break
+		}
+	}
+	if len(contacts) == 0 {
+		if err := s.replyText(ctx, replyToken, "找不到這間公司的名片"); err != nil {
+			loggerFrom(ctx).Error("Error replying", "err", err)
+		}
+		return
+	}
+
+	msg := fmt.Sprintf("%s 的聯絡人共 %d 位", name, len(contacts))
+	if len(contacts) > MaxCarouselBubbles {
+		msg += fmt.Sprintf("，顯示前 %d 位", MaxCarouselBubbles)
+		contacts = contacts[:MaxCarouselBubbles]
+	}
+	if err := s.SendFlexMsg(ctx, replyToken, contacts, msg); err != nil {
+		loggerFrom(ctx).Error("Error sending result", "err", err)
+	}
+}
+
+// companyListFlex 建立公司列表，每個 bubble 顯示 CompaniesPerBubble 間公司與人數，點選後以 postback 查詢。
+func companyListFlex(groups []CompanyGroup) *messaging_api.FlexCarousel {
+	if len(groups) > CompaniesPerBubble*MaxCarouselBubbles {
+		groups = groups[:CompaniesPerBubble*MaxCarouselBubbles]
+	}
+
+	var bubbles []messaging_api.FlexBubble
+	for start := 0; start < len(groups); start += CompaniesPerBubble {
+		end := min(start+CompaniesPerBubble, len(groups))
+
+		rows := []messaging_api.FlexComponentInterface{
+			&messaging_api.FlexText{
+				Text:   "公司列表",
+				Size:   "lg",
+				Weight: "bold",
+			},
+			&messaging_api.FlexSeparator{
+				Margin: "md",
+			},
+		}
+		for _, g := range groups[start:end] {
+			rows = append(rows, &messaging_api.FlexBox{
+				Layout:  messaging_api.FlexBoxLAYOUT_HORIZONTAL,
+				Margin:  "md",
+				Spacing: "sm",
+				Contents: []messaging_api.FlexComponentInterface{
+					&messaging_api.FlexText{
+						Flex:  4,
+						Text:  g.Name,
+						Color: "#1E6FD9",
+					},
+					&messaging_api.FlexText{
+						Flex:  1,
+						Align: "end",
+						Text:  fmt.Sprintf("%d 位", len(g.People)),
+						Color: "#888888",
+					},
+				},
+				Action: &messaging_api.PostbackAction{
+					Label:       truncateLabel(g.Name),
+					Data:        companyPostbackData(g.Name),
+					DisplayText: g.Name,
+				},
+			})
+		}
+
+		bubbles = append(bubbles, messaging_api.FlexBubble{
+			Body: &messaging_api.FlexBox{
+				Layout:   messaging_api.FlexBoxLAYOUT_VERTICAL,
+				Contents: rows,
+			},
+		})
+	}
+	return &messaging_api.FlexCarousel{Contents: bubbles}
+}
+
+// companyPostbackData 建立點選公司的 postback 資料。
+func companyPostbackData(name string) string {
+	return "action=company&name=" + url.QueryEscape(name)
+}
+
+// truncateLabel 將按鈕文字截斷為 LINE action label 的上限 20 字。
+func truncateLabel(s string) string {
+	if r := []rune(s); len(r) > 20 {
+		return string(r[:19]) + "…"
+	}
+	return s
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+func TestCompanyListFlex(t *testing.T) {
+	var groups []CompanyGroup
+	for i := 0; i < 25; i++ {
+		groups = append(groups, CompanyGroup{Name: fmt.Sprintf("公司 %d & Co", i), People: make([]Person, i+1)})
+	}
+
+	carousel := companyListFlex(groups)
+	if len(carousel.Contents) != 3 {
+		t.Fatalf("companyListFlex() returned %d bubbles, want 3", len(carousel.Contents))
+	}
+
+	// 標題、分隔線加上 5 間公司
+	last := carousel.Contents[2].Body.Contents
+	if len(last) != 7 {
+		t.Fatalf("last bubble has %d rows, want 7", len(last))
+	}
+	row := last[2].(*messaging_api.FlexBox)
+	action := row.Action.(*messaging_api.PostbackAction)
+	data, err := url.ParseQuery(action.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Get("action") != "company" || data.Get("name") != "公司 20 & Co" {
+		t.Errorf("postback data = %v, want company 公司 20 & Co", data)
+	}
+}
+
+func TestCompanyListFlexLimit(t *testing.T) {
+	groups := make([]CompanyGroup, CompaniesPerBubble*MaxCarouselBubbles+5)
+	for i := range groups {
+		groups[i] = CompanyGroup{Name: fmt.Sprint(i), People: []Person{{}}}
+	}
+	if n := len(companyListFlex(groups).Contents); n != MaxCarouselBubbles {
+		t.Errorf("companyListFlex() returned %d bubbles, want %d", n, MaxCarouselBubbles)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+	"time"
+)
+
+// cropThreshold 是判斷像素是否屬於背景的色差門檻（0-255）。
+const cropThreshold = 48
+
+// CardImageURLTTL 是名片照片簽章網址的有效期間，也是 S3 預先簽章網址的上限。
+const CardImageURLTTL = 7 * 24 * time.Hour
+
+// blobPrefix 回傳名片簿 bookID 在 BLOB_STORE 中的目錄名稱。目錄名稱是以 BLOB_PREFIX_KEY（未設定時為 ChannelSecret）
+// 計算的 HMAC，公開的圖片與匯出檔網址不會洩漏 LINE 用戶或群組 ID。
+func (s *Server) blobPrefix(bookID string) string {
+	mac := hmac.New(sha256.New, []byte(s.Config.BlobSecret()))
+	mac.Write([]byte(bookID))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// imageExtension 依照圖片內容判斷格式，回傳副檔名與 Content-Type。
+func imageExtension(data []byte) (string, string) {
+	contentType := http.DetectContentType(data)
+	switch contentType {
+	case "image/jpeg":
+		return ".jpg", contentType
+	case "image/png":
+		return ".png", contentType
+	case "image/gif":
+		return ".gif", contentType
+	case "image/webp":
+		return ".webp", contentType
+	}
+	return ".bin", "application/octet-stream"
+}
+
+// storeCardImages 在不公開的目錄 cards/<dir>（見 blobPrefix）保存原始名片照片與裁切後的版本，
+// 回傳原始與裁切圖片在 CardImageURLTTL 內有效的簽章網址。
+func storeCardImages(ctx context.Context, store BlobStore, dir string, data []byte) (string, string, error) {
+	id, err := randomHex(16)
+	if err != nil {
+		return "", "", err
+	}
+	prefix := fmt.Sprintf("cards/%s/%s", dir, id)
+	expires := time.Now().Add(CardImageURLTTL)
+
+	ext, contentType := imageExtension(data)
+	if _, err := store.Put(ctx, prefix+ext, data, contentType); err != nil {
+		return "", "", fmt.Errorf("error storing original image: %w", err)
+	}
+	originalURL, err := store.SignedURL(prefix+ext, expires)
+	if err != nil {
+		return "", "", fmt.Errorf("error signing original image: %w", err)
+	}
+
+	cropped, err := cropCardImage(data)
+	if err != nil {
+		// 無法解析的圖片就以原圖顯示
+		return originalURL, originalURL, nil
+	}
+	if _, err := store.Put(ctx, prefix+"-crop.jpg", cropped, "image/jpeg"); err != nil {
+		return originalURL, originalURL, fmt.Errorf("error storing cropped image: %w", err)
+	}
+	croppedURL, err := store.SignedURL(prefix+"-crop.jpg", expires)
+	if err != nil {
+		return originalURL, originalURL, fmt.Errorf("error signing cropped image: %w", err)
+	}
+	return originalURL, croppedURL, nil
+}
+
+// cardImageURL 為 Notion 中保存的名片照片網址重新簽章，讓超過 CardImageURLTTL 的名片在 LINE 中仍可顯示照片。
+// 不是 BLOB_STORE 的網址或無法簽章時回傳原本的網址。
+func (s *Server) cardImageURL(rawURL string) string {
+	if s.Images == nil || rawURL == "" {
+		return rawURL
+	}
+	key, ok := s.Images.Key(rawURL)
+	if !ok || !isPrivateBlob(key) {
+		return rawURL
+	}
+	signed, err := s.Images.SignedURL(key, time.Now().Add(CardImageURLTTL))
+	if err != nil {
+		return rawURL
+	}
+	return signed
+}
+
+// cropCardImage 以邊緣的平均顏色作為背景，裁切出與背景差異明顯的名片區域並轉為 JPEG。
+func cropCardImage(data []byte) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding image: %w", err)
+	}
+
+	b := img.Bounds()
+	bg := borderColor(img)
+	rect := image.Rectangle{Min: b.Max, Max: b.Min}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if colorDistance(img.At(x, y), bg) <= cropThreshold {
+				continue
+			}
+			rect.Min.X, rect.Min.Y = min(rect.Min.X, x), min(rect.Min.Y, y)
+			rect.Max.X, rect.Max.Y = max(rect.Max.X, x+1), max(rect.Max.Y, y+1)
+		}
+	}
+
+	// 找不到明顯的名片區域，或名片佔太小的比例時保留原圖
+	if rect.Empty() || rect.Dx()*rect.Dy()*10 < b.Dx()*b.Dy() {
+		rect = b
+	}
+
+	// 保留一點邊界
+	margin := max(rect.Dx(), rect.Dy()) / 50
+	rect = image.Rect(rect.Min.X-margin, rect.Min.Y-margin, rect.Max.X+margin, rect.Max.Y+margin).Intersect(b)
+
+	cropped := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	for y := 0; y < rect.Dy(); y++ {
+		for x := 0; x < rect.Dx(); x++ {
+			cropped.Set(x, y, img.At(rect.Min.X+x, rect.Min.Y+y))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, cropped, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// borderColor 計算圖片四周邊緣像素的平均顏色。
+func borderColor(img image.Image) color.RGBA {
+	b := img.Bounds()
+	var r, g, bl, n uint64
+	add := func(x, y int) {
+		cr, cg, cb, _ := img.At(x, y).RGBA()
+		r, g, bl, n = r+uint64(cr>>8), g+uint64(cg>>8), bl+uint64(cb>>8), n+1
+	}
+	for x := b.Min.X; x < b.Max.X; x++ {
+		add(x, b.Min.Y)
+		add(x, b.Max.Y-1)
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		add(b.Min.X, y)
+		add(b.Max.X-1, y)
+	}
+	if n == 0 {
+		return color.RGBA{}
+	}
+	return color.RGBA{R: uint8(r / n), G: uint8(g / n), B: uint8(bl / n), A: 255}
+}
+
+// colorDistance 回傳兩個顏色在 RGB 各通道差異的最大值（0-255）。
+func colorDistance(c color.Color, bg color.RGBA) int {
+	r, g, b, _ := c.RGBA()
+	abs := func(a, b int) int {
+		if a > b {
+			return a - b
+		}
+		return b - a
+	}
+	return max(abs(int(r>>8), int(bg.R)), abs(int(g>>8), int(bg.G)), abs(int(b>>8), int(bg.B)))
+}
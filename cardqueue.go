@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// CardQueueCheckInterval 是排程器檢查到期名片的間隔。
+	CardQueueCheckInterval = time.Minute
+	// CardJobMaxAttempts 是排入佇列的名片最多重新處理的次數，之後請用戶重新上傳。
+	CardJobMaxAttempts = 8
+	// CardUnavailableText 是服務暫時無法使用時回覆用戶的訊息，名片成功排入佇列時回覆 CardQueuedText。
+	CardUnavailableText = "名片辨識服務暫時忙碌中，請稍後再試。"
+	CardQueuedText      = CardUnavailableText + "這張名片已排入佇列，完成後會通知你。"
+	// SearchUnavailableText 是 Notion 暫時無法使用時搜尋的回覆，其他錯誤回覆 SearchFailedText。
+	SearchUnavailableText = "名片搜尋服務暫時忙碌中，請稍後再試。"
+	SearchFailedText      = "查詢名片時發生錯誤，請稍後再試。"
+)
+
+// CardJob 是一張因為 Gemini 或 Notion 暫時無法使用而延後處理的名片照片。
+type CardJob struct {
+	MessageID string    `json:"message_id"`        // LINE 圖片訊息 ID，照片在 LINE 保存期間內可以重新下載
+	BookID    string    `json:"book_id"`           // 名片簿 ID，也是推播結果的對象（用戶、群組或聊天室）
+	UserID    string    `json:"user_id,omitempty"` // 傳送照片的用戶，Gemini 用量記在這個用戶名下
+	QueuedAt  time.Time `json:"queued_at"`
+	Attempts  int       `json:"attempts"`
+	RunAt     time.Time `json:"run_at"`
+
+	// 排入佇列時選定的提示，重新處理時使用同一個提示；舊的紀錄沒有時使用 CARD_PROMPT
+	Prompt        string `json:"prompt,omitempty"`
+	PromptVersion string `json:"prompt_version,omitempty"`
+}
+
+// CardQueue 是存放於本地檔案的名片佇列，以圖片訊息 ID 區分，path 為空時只存在記憶體。
+type CardQueue struct {
+	mu    sync.Mutex
+	path  string
+	items map[string]CardJob
+}
+
+// NewCardQueue 建立名片佇列，若 path 不為空則從檔案載入尚未處理的名片。
+func NewCardQueue(path string) (*CardQueue, error) {
+	q := &CardQueue{path: path, items: make(map[string]CardJob)}
+	if path != "" {
+		if err := loadJSONFile(path, &q.items); err != nil {
+			return nil, fmt.Errorf("error loading card queue: %w", err)
+		}
+	}
+	return q, nil
+}
+
+// Schedule 新增或更新一張名片。
+func (q *CardQueue) Schedule(job CardJob) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.items[job.MessageID] = job
+	return q.save()
+}
+
+// Remove 移除已經處理完的名片。
+func (q *CardQueue) Remove(messageID string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.items, messageID)
+	return q.save()
+}
+
+// DeleteBook 移除名片簿 bookID 所有尚未處理的名片，回傳移除的數量。
+func (q *CardQueue) DeleteBook(bookID string) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for id, job := range q.items {
+		if job.BookID == bookID {
+			delete(q.items, id)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, q.save()
+}
+
+// Due 回傳所有已到期的名片。
+func (q *CardQueue) Due(now time.Time) []CardJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var jobs []CardJob
+	for _, job := range q.items {
+		if !job.RunAt.After(now) {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs
+}
+
+// Len 回傳佇列中的名片數。
+func (q *CardQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// save 將佇列寫入檔案，呼叫前需持有鎖。
+func (q *CardQueue) save() error {
+	if q.path == "" {
+		return nil
+	}
+	return saveJSONFile(q.path, q.items)
+}
+
+// retryDelay 是名片或提醒第 attempts 次處理失敗後到下一次處理的時間：1 分鐘開始每次加倍，最多 2 小時。
+func retryDelay(attempts int) time.Duration {
+	d := time.Minute
+	for i := 1; i < attempts && d < 2*time.Hour; i++ {
+		d *= 2
+	}
+	if d > 2*time.Hour {
+		d = 2 * time.Hour
+	}
+	return d
+}
+
+// queueCardImage 將名片照片排入佇列，回傳是否成功排入。
+func (s *Server) queueCardImage(ctx context.Context, bookID, userID, messageID string, prompt Prompt) bool {
+	// 未設定名片佇列時，Gemini 或 Notion 暫時無法使用的名片不會重新處理
+	if s.CardJobs == nil {
+		return false
+	}
+	now := time.Now()
+	job := CardJob{MessageID: messageID, BookID: bookID, UserID: userID, QueuedAt: now, RunAt: now.Add(retryDelay(1)), Prompt: prompt.Text, PromptVersion: prompt.Version}
+	if err := s.CardJobs.Schedule(job); err != nil {
+		loggerFrom(ctx).Error("Error queueing card", "err", err)
+		return false
+	}
+	loggerFrom(ctx).Info("Queued card for retry", "message_id", messageID, "run_at", job.RunAt)
+	return true
+}
+
+// runCardQueue 定期重新處理到期的名片並以 Push API 通知結果，直到 ctx 結束。
+func (s *Server) runCardQueue(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, job := range s.CardJobs.Due(now) {
+				s.retryCardJob(job, now)
+			}
+		}
+	}
+}
+
+// retryCardJob 重新處理佇列中的名片。服務仍無法使用時延後再試，超過 CardJobMaxAttempts 次後放棄並通知用戶。
+func (s *Server) retryCardJob(job CardJob, now time.Time) {
+	jlog := slog.With("job", "card", "message_id", job.MessageID)
+	ctx, span := s.startCardSpan(withLogger(context.Background(), jlog), job.MessageID)
+	span.SetAttributes(attribute.Int("card.attempt", job.Attempts+1))
+	defer span.End()
+
+	// 舊的紀錄沒有 UserID，用量記在名片簿名下
+	subject := job.UserID
+	if subject == "" {
+		subject = job.BookID
+	}
+	ctx = withTokenSubject(ctx, subject)
+
+	prompt := Prompt{Version: job.PromptVersion, Text: job.Prompt}
+	if prompt.Text == "" {
+		prompt = configPrompt(s.Config.CardPrompt)
+	}
+	result, err := s.processCardImage(ctx, job.BookID, job.MessageID, prompt)
+	switch {
+	case errors.Is(err, ErrUnavailable):
+		job.Attempts++
+		if job.Attempts < CardJobMaxAttempts {
+			job.RunAt = now.Add(retryDelay(job.Attempts + 1))
+			if err := s.CardJobs.Schedule(job); err != nil {
+				jlog.Error("Error saving card queue", "err", err)
+			}
+			return
+		}
+		jlog.Error("Giving up queued card", "attempts", job.Attempts, "err", err)
+		result = cardResult{Text: "名片辨識服務目前仍無法使用，請稍後重新上傳名片照片"}
+	case err != nil:
+		result = cardResult{Text: "無法取得名片照片，請重新上傳"}
+	}
+	if err := s.CardJobs.Remove(job.MessageID); err != nil {
+		jlog.Error("Error saving card queue", "err", err)
+	}
+
+	if len(result.People) > 0 {
+		s.rememberRecentContact(sessionKeyFor(job.BookID, subject), result)
+		err = s.PushFlexMsg(job.BookID, result.People, result.Text)
+	} else {
+		_, err = s.Bot.PushMessage(&messaging_api.PushMessageRequest{
+			To:       job.BookID,
+			Messages: []messaging_api.MessageInterface{&messaging_api.TextMessage{Text: result.Text}},
+		}, "")
+	}
+	if err != nil {
+		jlog.Error("Error pushing queued card result", "err", err)
+	}
+}
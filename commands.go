@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+// RecentContactsLimit 是「最近新增」顯示的名片數。
+const RecentContactsLimit = 10
+
+// Command 是可以從文字訊息或圖文選單的 postback 觸發的指令。
+type Command struct {
+	// Name 是 postback 使用的指令代號，例如 action=command&name=scan
+	Name string
+	// Texts 是觸發指令的文字訊息
+	Texts []string
+	// Help 是「說明」中的描述，空白時不顯示
+	Help string
+	// PrivateOnly 表示只能在一對一聊天中使用，避免群組成員操作到群組共用的名片簿
+	PrivateOnly bool
+	Run         func(s *Server, ctx context.Context, replyToken string, nDB *NotionDB)
+}
+
+// commands 是所有的指令，在 init 中設定以便「說明」可以列出所有指令。
+var commands []Command
+
+func init() {
+	commands = []Command{
+		{Name: "scan", Texts: []string{"掃描", "掃描名片"}, Help: "拍攝或上傳名片照片", Run: (*Server).replyScan},
+		{Name: "search", Texts: []string{"搜尋"}, Help: "搜尋名片的方式", Run: (*Server).replySearchHelp},
+		{Name: "recent", Texts: []string{"最近新增"}, Help: fmt.Sprintf("最近新增的 %d 張名片", RecentContactsLimit), Run: (*Server).replyRecentContacts},
+		{Name: "companies", Texts: []string{"公司", "公司列表"}, Help: "依公司瀏覽名片", Run: (*Server).replyCompanyList},
+		{Name: "reminders", Texts: []string{"提醒列表", "我的提醒"}, Help: "查看或取消提醒", Run: (*Server).replyReminders},
+		{Name: "export", Texts: []string{"匯出"}, Help: "將所有名片匯出成 CSV", Run: (*Server).replyExport},
+		{Name: "settings", Texts: []string{"設定"}, Help: "查看目前的設定", Run: (*Server).replySettings},
+		{Name: "purge", Texts: []string{"刪除我的資料"}, Help: "刪除你的所有名片與照片", PrivateOnly: true, Run: (*Server).replyPurgeConfirm},
+		{Name: "help", Texts: []string{"說明", "help"}, Help: "顯示這個說明", Run: (*Server).replyHelp},
+		{Name: "consent", Texts: []string{"同意"}, PrivateOnly: true, Run: (*Server).replyAgree},
+		{Name: "test", Texts: []string{"test"}, Run: (*Server).replyTestCard},
+	}
+}
+
+// findCommand 依照文字訊息找出對應的指令。
+func findCommand(text string) (Command, bool) {
+	text = strings.TrimSpace(text)
+	for _, c := range commands {
+		for _, t := range c.Texts {
+			if strings.EqualFold(text, t) {
+				return c, true
+			}
+		}
+	}
+	return Command{}, false
+}
+
+// findCommandByName 依照 postback 的指令代號找出對應的指令。
+func findCommandByName(name string) (Command, bool) {
+	for _, c := range commands {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Command{}, false
+}
+
+// handleCommandText 在文字訊息是指令時執行，回傳是否已處理。
+func (s *Server) handleCommandText(ctx context.Context, replyToken string, nDB *NotionDB, text string, group bool) bool {
+	c, ok := findCommand(text)
+	if !ok {
+		return false
+	}
+	s.runCommand(ctx, c, replyToken, nDB, group)
+	return true
+}
+
+// runCommand 執行指令，群組中不能使用的指令會提示用戶改用一對一聊天。
+func (s *Server) runCommand(ctx context.Context, c Command, replyToken string, nDB *NotionDB, group bool) {
+	if c.PrivateOnly && group {
+		if err := s.replyText(ctx, replyToken, fmt.Sprintf("請在與我的一對一聊天中使用「%s」", c.Texts[0])); err != nil {
+			loggerFrom(ctx).Error("Error replying", "err", err)
+		}
+		return
+	}
+	c.Run(s, ctx, replyToken, nDB)
+}
+
+// replyScan 回覆開啟相機或相簿的快速回覆按鈕。
+func (s *Server) replyScan(ctx context.Context, replyToken string, _ *NotionDB) {
+	if _, err := s.Bot.ReplyMessage(
+		&messaging_api.ReplyMessageRequest{
+			ReplyToken: replyToken,
+			Messages: []messaging_api.MessageInterface{
+				&messaging_api.TextMessage{
+					Text: "請拍攝或上傳名片照片",
+					QuickReply: &messaging_api.QuickReply{
+						Items: []messaging_api.QuickReplyItem{
+							{Type: "action", Action: &messaging_api.CameraAction{Label: "拍照"}},
+							{Type: "action", Action: &messaging_api.CameraRollAction{Label: "從相簿選擇"}},
+						},
+					},
+				},
+			},
+		},
+	); err != nil {
+		loggerFrom(ctx).Error("Error replying", "err", err)
+	}
+}
+
+// replySearchHelp 回覆搜尋名片的方式。
+func (s *Server) replySearchHelp(ctx context.Context, replyToken string, _ *NotionDB) {
+	text := strings.Join([]string{
+		"直接輸入關鍵字就可以搜尋名片，例如：",
+		"・姓名、職稱、公司、email 或地址：王小明",
+		"・標籤與會面日期：#客戶 2026-06-01~2026-06-30",
+		"・描述：在銀行做雲端資安的那位",
+	}, "\n")
+	if err := s.replyText(ctx, replyToken, text); err != nil {
+		loggerFrom(ctx).Error("Error replying", "err", err)
+	}
+}
+
+// replyRecentContacts 回覆最近新增的名片。
+func (s *Server) replyRecentContacts(ctx context.Context, replyToken string, nDB *NotionDB) {
+	people, err := nDB.QueryDatabaseRecent(ctx, RecentContactsLimit)
+	if err != nil || len(people) == 0 {
+		ret := "目前還沒有名片，傳送名片照片即可新增"
+		if err != nil {
+			loggerFrom(ctx).Error("Error querying recent contacts", "err", err)
+			ret = "無法取得名片資料，請稍後再試"
+		}
+		if err := s.replyText(ctx, replyToken, ret); err != nil {
+			loggerFrom(ctx).Error("Error replying", "err", err)
+		}
+		return
+	}
+	if err := s.SendFlexMsg(ctx, replyToken, people, "最近新增的名片"); err != nil {
+		loggerFrom(ctx).Error("Error sending result", "err", err)
+	}
+}
+
+// replyReminders 回覆提醒列表，未啟用提醒時告知用戶。
+func (s *Server) replyReminders(ctx context.Context, replyToken string, nDB *NotionDB) {
+	if s.Reminders == nil {
+		if err := s.replyText(ctx, replyToken, "目前沒有啟用提醒功能"); err != nil {
+			loggerFrom(ctx).Error("Error replying", "err", err)
+		}
+		return
+	}
+	s.replyReminderList(ctx, replyToken, sessionKeyFrom(ctx, nDB.UID))
+}
+
+// replyExport 將用戶的所有名片匯出成 CSV，存放在名片照片的儲存後回覆 ExportLinkTTL 內有效的下載連結，到期後刪除檔案。
+func (s *Server) replyExport(ctx context.Context, replyToken string, nDB *NotionDB) {
+	if s.Images == nil || s.Exports == nil {
+		if err := s.replyText(ctx, replyToken, "目前沒有設定檔案儲存 (BLOB_STORE)，無法匯出"); err != nil {
+			loggerFrom(ctx).Error("Error replying", "err", err)
+		}
+		return
+	}
+
+	people, err := nDB.QueryDatabaseByUID(ctx)
+	if err != nil {
+		loggerFrom(ctx).Error("Error querying contacts for export", "err", err)
+		if err := s.replyText(ctx, replyToken, "無法取得名片資料，請稍後再試"); err != nil {
+			loggerFrom(ctx).Error("Error replying", "err", err)
+		}
+		return
+	}
+
+	link, err := s.storeExport(ctx, nDB.UID, people)
+	if err != nil {
+		loggerFrom(ctx).Error("Error storing export", "err", err)
+		if err := s.replyText(ctx, replyToken, "匯出失敗，請稍後再試"); err != nil {
+			loggerFrom(ctx).Error("Error replying", "err", err)
+		}
+		return
+	}
+
+	if err := s.replyText(ctx, replyToken, fmt.Sprintf("已匯出 %d 張名片，連結在 %d 分鐘內有效：\n%s", len(people), int(ExportLinkTTL.Minutes()), link)); err != nil {
+		loggerFrom(ctx).Error("Error replying", "err", err)
+	}
+}
+
+// storeExport 保存名片簿 bookID 的匯出檔並記錄到期時間，回傳簽章過的下載連結。
+func (s *Server) storeExport(ctx context.Context, bookID string, people []Person) (string, error) {
+	data, err := exportContactsCSV(people)
+	if err != nil {
+		return "", err
+	}
+	id, err := randomHex(16)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	key := fmt.Sprintf("exports/%s/%s-%s.csv", s.blobPrefix(bookID), now.Format("20060102"), id)
+	if _, err := s.Images.Put(ctx, key, data, "text/csv; charset=utf-8"); err != nil {
+		return "", err
+	}
+
+	// 先記錄到期時間，之後的步驟失敗時檔案也會被刪除
+	expires := now.Add(ExportLinkTTL)
+	if err := s.Exports.Add(key, expires); err != nil {
+		if err := s.Images.Delete(ctx, key); err != nil {
+			loggerFrom(ctx).Error("Error deleting export", "err", err)
+		}
+		return "", err
+	}
+	return s.Images.SignedURL(key, expires)
+}
+
+// exportContactsCSV 將名片轉為 CSV，加上 UTF-8 BOM 讓 Excel 可以正確顯示中文。
+func exportContactsCSV(people []Person) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("\ufeff")
+	w := csv.NewWriter(&buf)
+	if err := w.Write([]string{"Name", "Title", "Company", "Email", "Phone", "Address", "Tags", "Event", "MetDate", "Notes"}); err != nil {
+		return nil, err
+	}
+	for _, p := range people {
+		record := []string{p.Name, p.Title, p.Company, p.Email, p.Phone, p.Address, strings.Join(p.Tags, ","), p.Event, p.MetDate, p.Notes}
+		for i, cell := range record {
+			record[i] = escapeCSVFormula(cell)
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// escapeCSVFormula 在以 =、+、-、@ 或控制字元開頭的儲存格前加上單引號，避免試算表把名片內容當作公式執行。
+func escapeCSVFormula(cell string) string {
+	if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+		return "'" + cell
+	}
+	return cell
+}
+
+// replySettings 回覆目前啟用的功能與名片數量。
+func (s *Server) replySettings(ctx context.Context, replyToken string, nDB *NotionDB) {
+	enabled := func(ok bool) string {
+		if ok {
+			return "開啟"
+		}
+		return "關閉"
+	}
+
+	lines := []string{"目前的設定："}
+	if people, err := nDB.QueryDatabaseByUID(ctx); err == nil {
+		lines = append(lines, fmt.Sprintf("・名片數量：%d", len(people)))
+	}
+	lines = append(lines,
+		"・語意搜尋："+enabled(s.Semantic != nil),
+		"・語音備註："+enabled(s.Transcriber != nil),
+		"・AI 追蹤信："+enabled(s.Chat != nil),
+		"・追蹤提醒："+enabled(s.Reminders != nil),
+		"・保存名片照片與匯出："+enabled(s.Images != nil),
+	)
+	if err := s.replyText(ctx, replyToken, strings.Join(lines, "\n")); err != nil {
+		loggerFrom(ctx).Error("Error replying", "err", err)
+	}
+}
+
+// replyHelp 列出所有指令。
+func (s *Server) replyHelp(ctx context.Context, replyToken string, _ *NotionDB) {
+	lines := []string{"傳送名片照片就會自動辨識並新增到資料庫，也可以輸入以下指令："}
+	for _, c := range commands {
+		if c.Help != "" {
+			lines = append(lines, fmt.Sprintf("・%s：%s", c.Texts[0], c.Help))
+		}
+	}
+	lines = append(lines, "其他文字會當作關鍵字搜尋名片。")
+	if err := s.replyText(ctx, replyToken, strings.Join(lines, "\n")); err != nil {
+		loggerFrom(ctx).Error("Error replying", "err", err)
+	}
+}
+
+// replyAgree 記錄用戶同意保存名片資料，用於之前拒絕過的用戶。
+func (s *Server) replyAgree(ctx context.Context, replyToken string, nDB *NotionDB) {
+	s.handleConsentPostback(ctx, replyToken, nDB.UID, url.Values{"answer": {"yes"}})
+}
+
+// replyTestCard 回覆測試用的名片，用來確認 Flex Message 的版面。
+func (s *Server) replyTestCard(ctx context.Context, replyToken string, _ *NotionDB) {
+	cards := []Person{
+		{
+			Name:    "test",
+			Title:   "test",
+			Address: "test",
+			Email:   "test",
+			Phone:   "test",
+		},
+	}
+	if err := s.SendFlexMsg(ctx, replyToken, cards, "test card"); err != nil {
+		loggerFrom(ctx).Error("Error replying", "err", err)
+	}
+}
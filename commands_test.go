@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+func TestFindCommand(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		{"掃描", "scan"},
+		{" 說明 ", "help"},
+		{"HELP", "help"},
+		{"最近新增", "recent"},
+		{"公司", "companies"},
+		{"提醒列表", "reminders"},
+	}
+	for _, tt := range tests {
+		c, ok := findCommand(tt.text)
+		if !ok || c.Name != tt.want {
+			t.Errorf("findCommand(%q) = %q, %v, want %q", tt.text, c.Name, ok, tt.want)
+		}
+	}
+
+	if _, ok := findCommand("王小明"); ok {
+		t.Error("findCommand() matched a search keyword")
+	}
+}
+
+func TestRichMenuDefinition(t *testing.T) {
+	menu, err := loadRichMenu("richmenu/richmenu.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(menu.Areas) != 6 {
+		t.Fatalf("rich menu has %d areas, want 6", len(menu.Areas))
+	}
+
+	data, err := json.Marshal(menu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), `"type":"postback"`); n != 6 {
+		t.Errorf("marshaled rich menu has %d postback actions, want 6", n)
+	}
+
+	for _, area := range menu.Areas {
+		b := area.Bounds
+		if b.X+b.Width > menu.Size.Width || b.Y+b.Height > menu.Size.Height {
+			t.Errorf("area %+v is out of the menu size", b)
+		}
+
+		// 每個按鈕都要對應到一個指令
+		action, ok := area.Action.(*messaging_api.PostbackAction)
+		if !ok {
+			t.Fatalf("area action = %T, want postback", area.Action)
+		}
+		name := strings.TrimPrefix(action.Data, "action=command&name=")
+		if _, ok := findCommandByName(name); !ok {
+			t.Errorf("rich menu action %q has no command", action.Data)
+		}
+	}
+}
+
+func TestExportContactsCSV(t *testing.T) {
+	data, err := exportContactsCSV([]Person{
+		{Name: "王小明", Company: "Acme, Inc.", Tags: []string{"客戶", "VIP"}, Notes: "第一行\n第二行"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "\ufeff") {
+		t.Error("exportContactsCSV() should start with a UTF-8 BOM")
+	}
+
+	records, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(string(data), "\ufeff"))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+	row := records[1]
+	if row[0] != "王小明" || row[2] != "Acme, Inc." || row[6] != "客戶,VIP" || row[9] != "第一行\n第二行" {
+		t.Errorf("exportContactsCSV() row = %q", row)
+	}
+}
+
+func TestExportContactsCSVEscapesFormulas(t *testing.T) {
+	data, err := exportContactsCSV([]Person{
+		{Name: `=HYPERLINK("https://evil.example","點我")`, Title: "+SUM(A1)", Company: "-2+3", Email: "@cmd", Notes: "正常備註"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(string(data), "\ufeff"))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := records[1]
+	for i, want := range []string{`'=HYPERLINK("https://evil.example","點我")`, "'+SUM(A1)", "'-2+3", "'@cmd"} {
+		if row[i] != want {
+			t.Errorf("cell %d = %q, want %q", i, row[i], want)
+		}
+	}
+	if row[9] != "正常備註" {
+		t.Errorf("notes = %q", row[9])
+	}
+}
+
+func TestExportExpires(t *testing.T) {
+	images := &LocalBlobStore{Dir: t.TempDir(), BaseURL: "https://bot.example.com/images/", Secret: "secret"}
+	exports, _ := NewExportStore("")
+	s := &Server{Config: DefaultConfig(), Images: images, Exports: exports}
+
+	link, err := s.storeExport(context.Background(), "U1", []Person{{Name: "王小明"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(link, "?expires=") || strings.Contains(link, "U1") {
+		t.Errorf("link = %q, want a signed link without the user ID", link)
+	}
+	keys := exports.Due(time.Now().Add(ExportLinkTTL))
+	if len(keys) != 1 {
+		t.Fatalf("Due() = %v, want the export", keys)
+	}
+	path, _ := images.path(keys[0])
+
+	s.deleteExpiredExports(context.Background(), time.Now())
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("export deleted before it expired: %v", err)
+	}
+	s.deleteExpiredExports(context.Background(), time.Now().Add(ExportLinkTTL))
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("export left after it expired: %v", err)
+	}
+	if keys := exports.Due(time.Now().Add(ExportLinkTTL)); len(keys) != 0 {
+		t.Errorf("Due() after cleanup = %v", keys)
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultCompanyAliases 是常見公司的正式名稱與其簡稱、英文名稱的對應。
+var defaultCompanyAliases = map[string][]string{
+	"台灣積體電路製造":  {"台積電", "台積", "tsmc", "taiwansemiconductormanufacturing", "台灣積體電路"},
+	"鴻海精密工業":    {"鴻海", "foxconn", "honhai", "honhaiprecisionindustry", "富士康"},
+	"聯發科技":      {"聯發科", "mediatek"},
+	"聯華電子":      {"聯電", "umc", "unitedmicroelectronics"},
+	"中華電信":      {"中華電", "cht", "chunghwatelecom"},
+	"華碩電腦":      {"華碩", "asus", "asustek", "asustekcomputer"},
+	"宏碁":        {"acer"},
+	"廣達電腦":      {"廣達", "quanta", "quantacomputer"},
+	"台達電子工業":    {"台達電", "台達", "delta", "deltaelectronics"},
+	"日月光投資控股":   {"日月光", "ase", "asetechnology"},
+	"緯創資通":      {"緯創", "wistron"},
+	"仁寶電腦工業":    {"仁寶", "compal", "compalelectronics"},
+	"國泰金融控股":    {"國泰金控", "國泰金", "國泰", "cathay", "cathayfinancial"},
+	"富邦金融控股":    {"富邦金控", "富邦金", "富邦", "fubon", "fubonfinancial"},
+	"中國信託商業銀行":  {"中信", "中國信託", "中信銀", "ctbc", "ctbcbank"},
+	"玉山商業銀行":    {"玉山", "玉山銀行", "esun", "esunbank"},
+	"Google":    {"google", "谷歌", "alphabet"},
+	"Microsoft": {"microsoft", "微軟"},
+	"Amazon":    {"amazon", "aws", "amazonwebservices", "亞馬遜"},
+	"LINE":      {"line", "lycorporation", "連加"},
+}
+
+// companySuffixes 是正規化時要移除的中文公司型態字尾。
+var companySuffixes = []string{"股份有限公司", "有限公司", "台灣分公司", "分公司", "公司", "集團"}
+
+// companyLatinSuffixes 是英文公司型態字尾，只在前面有空白或標點時移除（已轉為小寫）。
+var companyLatinSuffixes = []string{"incorporated", "corporation", "company", "limited", "inc", "corp", "co", "ltd", "llc", "plc", "gmbh"}
+
+// CompanyRecord 是公司登記資料。
+type CompanyRecord struct {
+	BusinessID     string `json:"business_id"` // 統一編號
+	Name           string `json:"name"`
+	Representative string `json:"representative,omitempty"`
+	Address        string `json:"address,omitempty"`
+}
+
+// CompanyRegistry 查詢公開的公司登記資料。
+type CompanyRegistry interface {
+	// Lookup 依照公司名稱查詢，查不到時回傳 nil 與 nil error。
+	Lookup(ctx context.Context, name string) (*CompanyRecord, error)
+}
+
+// CompanyDirectory 將公司名稱正規化，並可選擇性地查詢公司登記資料。
+type CompanyDirectory struct {
+	mu       sync.RWMutex
+	aliases  map[string]string
+	registry CompanyRegistry
+}
+
+// NewCompanyDirectory 以內建的別名表建立 CompanyDirectory，registry 可為 nil。
+func NewCompanyDirectory(registry CompanyRegistry) *CompanyDirectory {
+	d := &CompanyDirectory{aliases: make(map[string]string), registry: registry}
+	for canonical, aliases := range defaultCompanyAliases {
+		d.AddAliases(canonical, aliases...)
+	}
+	return d
+}
+
+// AddAliases 新增公司別名，別名會以 companyKey 正規化後比對。
+func (d *CompanyDirectory) AddAliases(canonical string, aliases ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.aliases[companyKey(canonical)] = canonical
+	for _, alias := range aliases {
+		d.aliases[companyKey(alias)] = canonical
+	}
+}
+
+// LoadAliases 從 JSON 檔案載入額外的別名，格式為 {"正式名稱": ["別名", ...]}。
+func (d *CompanyDirectory) LoadAliases(path string) error {
+	var aliases map[string][]string
+	if err := loadJSONFile(path, &aliases); err != nil {
+		return fmt.Errorf("error loading company aliases: %w", err)
+	}
+	for canonical, list := range aliases {
+		d.AddAliases(canonical, list...)
+	}
+	return nil
+}
+
+// Normalize 回傳公司的正規化名稱，不在別名表中的公司會移除公司型態字尾。
+func (d *CompanyDirectory) Normalize(name string) string {
+	// Gemini 解析不到公司時會回傳 N/A
+	key := companyKey(name)
+	if key == "" || strings.EqualFold(strings.TrimSpace(name), "N/A") {
+		return ""
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if canonical, ok := d.aliases[key]; ok {
+		return canonical
+	}
+	return trimCompanySuffix(name)
+}
+
+// Enrich 查詢公司登記資料並補上統一編號，沒有設定 registry 時直接回傳。
+func (d *CompanyDirectory) Enrich(ctx context.Context, person Person) Person {
+	if d.registry == nil || person.BusinessID != "" {
+		return person
+	}
+	name := d.Normalize(person.Company)
+	if name == "" {
+		return person
+	}
+
+	record, err := d.registry.Lookup(ctx, name)
+	if err != nil {
+		loggerFrom(ctx).Error("Error looking up company registry", "err", err)
+		return person
+	}
+	if record != nil {
+		person.BusinessID = record.BusinessID
+	}
+	return person
+}
+
+// CompanyGroup 是同一間公司的聯絡人。
+type CompanyGroup struct {
+	Name   string
+	People []Person
+}
+
+// GroupByCompany 依正規化後的公司名稱將聯絡人分組，人數多的公司排在前面。
+func (d *CompanyDirectory) GroupByCompany(people []Person) []CompanyGroup {
+	index := make(map[string]int)
+	var groups []CompanyGroup
+	for _, p := range people {
+		name := d.Normalize(p.Company)
+		if name == "" {
+			continue
+		}
+		i, ok := index[name]
+		if !ok {
+			i = len(groups)
+			index[name] = i
+			groups = append(groups, CompanyGroup{Name: name})
+		}
+		groups[i].People = append(groups[i].People, p)
+	}
+	sort.SliceStable(groups, func(i, j int) bool { return len(groups[i].People) > len(groups[j].People) })
+	return groups
+}
+
+// companyKey 將公司名稱轉為比對用的 key：移除公司型態字尾後轉小寫、繁簡折疊並移除空白標點。
+func companyKey(name string) string {
+	return normalizeText(trimCompanySuffix(name))
+}
+
+// trimCompanySuffix 重複移除公司型態字尾，例如「台灣積體電路製造股份有限公司」-> 「台灣積體電路製造」、
+// "Acme Co., Ltd." -> "Acme"。
+func trimCompanySuffix(name string) string {
+	name = strings.TrimSpace(name)
+	for {
+		trimmed := strings.TrimRight(name, " ,.")
+		lower := strings.ToLower(trimmed)
+		next := trimmed
+		for _, suffix := range companySuffixes {
+			if len(lower) > len(suffix) && strings.HasSuffix(lower, suffix) {
+				next = trimmed[:len(trimmed)-len(suffix)]
+				break
+			}
+		}
+		if next == trimmed {
+			for _, suffix := range companyLatinSuffixes {
+				if strings.HasSuffix(lower, " "+suffix) || strings.HasSuffix(lower, ","+suffix) || strings.HasSuffix(lower, "."+suffix) {
+					next = trimmed[:len(trimmed)-len(suffix)]
+					break
+				}
+			}
+		}
+		next = strings.TrimRight(next, " ,.")
+		if next == name || next == "" {
+			return name
+		}
+		name = next
+	}
+}
+
+// FixtureCompanyRegistry 是離線的公司登記資料，從 JSON 檔案載入，適合測試與沒有網路的環境。
+type FixtureCompanyRegistry struct {
+	records map[string]CompanyRecord
+}
+
+// NewFixtureCompanyRegistry 從 JSON 檔案（CompanyRecord 陣列）載入公司登記資料。
+func NewFixtureCompanyRegistry(path string) (*FixtureCompanyRegistry, error) {
+	var records []CompanyRecord
+	if err := loadJSONFile(path, &records); err != nil {
+		return nil, fmt.Errorf("error loading company fixture: %w", err)
+	}
+	r := &FixtureCompanyRegistry{records: make(map[string]CompanyRecord)}
+	for _, record := range records {
+		r.records[companyKey(record.Name)] = record
+	}
+	return r, nil
+}
+
+// Lookup 依照正規化後的公司名稱查詢。
+func (r *FixtureCompanyRegistry) Lookup(_ context.Context, name string) (*CompanyRecord, error) {
+	if record, ok := r.records[companyKey(name)]; ok {
+		return &record, nil
+	}
+	return nil, nil
+}
+
+// GCISTimeout 是查詢商工登記 API 的預設期限。
+const GCISTimeout = 10 * time.Second
+
+// GCISCompanyRegistry 查詢經濟部商工登記公示資料的公司登記關鍵字 API。
+type GCISCompanyRegistry struct {
+	// BaseURL 預設為 https://data.gcis.nat.gov.tw
+	BaseURL string
+	// HTTPClient 預設為期限 GCISTimeout 的 client
+	HTTPClient *http.Client
+	// Timeout 是每次查詢的期限，預設為 GCISTimeout
+	Timeout time.Duration
+}
+
+// gcisCompany 是商工登記 API 回傳的欄位。
+type gcisCompany struct {
+	BusinessAccountingNO string `json:"Business_Accounting_NO"`
+	CompanyName          string `json:"Company_Name"`
+	ResponsibleName      string `json:"Responsible_Name"`
+	CompanyLocation      string `json:"Company_Location"`
+}
+
+// Lookup 以公司名稱關鍵字查詢核准設立中的公司，回傳名稱最接近的一筆。
+func (g *GCISCompanyRegistry) Lookup(ctx context.Context, name string) (*CompanyRecord, error) {
+	base := g.BaseURL
+	if base == "" {
+		base = "https://data.gcis.nat.gov.tw"
+	}
+	query := url.Values{}
+	query.Set("$format", "json")
+	// OData 字串以單引號包住，內容中的單引號寫成兩個
+	query.Set("$filter", fmt.Sprintf("Company_Name like '%s' and Company_Status eq 01", strings.ReplaceAll(name, "'", "''")))
+	query.Set("$skip", "0")
+	query.Set("$top", "10")
+	endpoint := base + "/od/data/api/6BBA2268-1367-4B42-9CCA-BC17499EBE8C?" + query.Encode()
+
+	timeout := g.Timeout
+	if timeout <= 0 {
+		timeout = GCISTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := g.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: timeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error making request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("company registry: %s", resp.Status)
+	}
+
+	// 查無資料時 API 回傳空的 body
+	var results []gcisCompany
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error decoding company registry response: %w", err)
+	}
+
+	key := companyKey(name)
+	var best *gcisCompany
+	for i, c := range results {
+		if companyKey(c.CompanyName) == key {
+			best = &results[i]
+			break
+		}
+		if best == nil {
+			best = &results[i]
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+	return &CompanyRecord{
+		BusinessID:     best.BusinessAccountingNO,
+		Name:           best.CompanyName,
+		Representative: best.ResponsibleName,
+		Address:        best.CompanyLocation,
+	}, nil
+}
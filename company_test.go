@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCompanyNormalize(t *testing.T) {
+	d := NewCompanyDirectory(nil)
+	tests := []struct {
+		in, want string
+	}{
+		{"台積電", "台灣積體電路製造"},
+		{"TSMC", "台灣積體電路製造"},
+		{"台灣積體電路製造股份有限公司", "台灣積體電路製造"},
+		{"Taiwan Semiconductor Manufacturing Co., Ltd.", "台灣積體電路製造"},
+		{"鴻海精密工業股份有限公司", "鴻海精密工業"},
+		{"Acme Co., Ltd.", "Acme"},
+		{"Costco", "Costco"},
+		{"N/A", ""},
+	}
+	for _, tt := range tests {
+		if got := d.Normalize(tt.in); got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	d.AddAliases("範例科技", "Example")
+	if got := d.Normalize("Example Inc."); got != "範例科技" {
+		t.Errorf("Normalize() with custom alias = %q, want 範例科技", got)
+	}
+}
+
+func TestGroupByCompany(t *testing.T) {
+	d := NewCompanyDirectory(nil)
+	people := []Person{
+		{Name: "王小明", Company: "Acme Inc."},
+		{Name: "李大華", Company: "TSMC"},
+		{Name: "陳美玲", Company: "台積電"},
+		{Name: "林志明", Company: ""},
+	}
+
+	groups := d.GroupByCompany(people)
+	if len(groups) != 2 {
+		t.Fatalf("GroupByCompany() returned %d groups, want 2", len(groups))
+	}
+	if groups[0].Name != "台灣積體電路製造" || len(groups[0].People) != 2 {
+		t.Errorf("groups[0] = %+v, want 台灣積體電路製造 with 2 people", groups[0])
+	}
+	if groups[1].Name != "Acme" || len(groups[1].People) != 1 {
+		t.Errorf("groups[1] = %+v, want Acme with 1 person", groups[1])
+	}
+}
+
+func TestCompanyEnrich(t *testing.T) {
+	registry, err := NewFixtureCompanyRegistry("testdata/company_registry.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewCompanyDirectory(registry)
+
+	person := d.Enrich(context.Background(), Person{Name: "李大華", Company: "台積電"})
+	if person.BusinessID != "22099131" {
+		t.Errorf("Enrich() BusinessID = %q, want 22099131", person.BusinessID)
+	}
+
+	person = d.Enrich(context.Background(), Person{Name: "王小明", Company: "Acme Inc."})
+	if person.BusinessID != "" {
+		t.Errorf("Enrich() BusinessID = %q, want empty for unknown company", person.BusinessID)
+	}
+}
+
+func TestGCISCompanyRegistry(t *testing.T) {
+	var filter string
+	body := `[{"Business_Accounting_NO":"22099131","Company_Name":"台灣積體電路製造股份有限公司","Responsible_Name":"魏哲家","Company_Location":"新竹科學園區"}]`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filter = r.URL.Query().Get("$filter")
+		io.WriteString(w, body)
+	}))
+	defer srv.Close()
+	g := &GCISCompanyRegistry{BaseURL: srv.URL, Timeout: time.Second}
+
+	record, err := g.Lookup(context.Background(), "台灣積體電路製造")
+	if err != nil || record == nil || record.BusinessID != "22099131" {
+		t.Fatalf("Lookup() = %+v, %v", record, err)
+	}
+
+	// 名稱中的單引號不能結束 OData 字串
+	if _, err := g.Lookup(context.Background(), "O'Reilly' or 1 eq 1"); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Company_Name like 'O''Reilly'' or 1 eq 1' and Company_Status eq 01"; filter != want {
+		t.Errorf("$filter = %q, want %q", filter, want)
+	}
+
+	// 查無資料時回傳空的 body，其他無法解析的回應回傳錯誤
+	body = ""
+	if record, err := g.Lookup(context.Background(), "不存在"); record != nil || err != nil {
+		t.Errorf("Lookup() with an empty body = %+v, %v", record, err)
+	}
+	body = "<html>maintenance</html>"
+	if _, err := g.Lookup(context.Background(), "台積電"); err == nil {
+		t.Error("Lookup() with an HTML body should fail")
+	}
+}
+
+func TestGCISCompanyRegistryTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	g := &GCISCompanyRegistry{BaseURL: srv.URL, Timeout: 50 * time.Millisecond}
+
+	if _, err := g.Lookup(context.Background(), "台積電"); err == nil {
+		t.Error("Lookup() should time out")
+	}
+}
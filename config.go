@@ -0,0 +1,430 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config 是 bot 的所有設定。每個欄位依序由預設值、設定檔 (YAML)、環境變數與命令列參數載入，後者覆蓋前者。
+// env 是環境變數名稱，yaml 是設定檔的 key，命令列參數為 yaml key 的底線換成減號，例如 -notion-db-page-id。
+// secret 欄位在設定摘要中不會顯示內容。
+type Config struct {
+	// LINE
+	ChannelAccessToken string `yaml:"channel_access_token" env:"ChannelAccessToken" secret:"true"`
+	ChannelSecret      string `yaml:"channel_secret" env:"ChannelSecret" secret:"true"`
+
+	// Notion
+	NotionToken      string `yaml:"notion_integration_token" env:"NOTION_INTEGRATION_TOKEN" secret:"true"`
+	NotionDatabaseID string `yaml:"notion_db_page_id" env:"NOTION_DB_PAGEID"`
+	NotionBaseURL    string `yaml:"notion_base_url" env:"NOTION_BASE_URL"`
+
+	// Gemini
+	GeminiAPIKey string `yaml:"gemini_api_key" env:"GOOGLE_GEMINI_API_KEY" secret:"true"`
+	CardPrompt   string `yaml:"card_prompt" env:"CARD_PROMPT"`
+	// 版本化的名片辨識提示模板，PROMPT_DIR 為空時所有人使用 CARD_PROMPT
+	PromptDir             string `yaml:"prompt_dir" env:"PROMPT_DIR"`
+	PromptAssignmentsPath string `yaml:"prompt_assignments_path" env:"PROMPT_ASSIGNMENTS_PATH"`
+	VectorIndexPath       string `yaml:"vector_index_path" env:"VECTOR_INDEX_PATH"`
+
+	// Gemini 的 token 用量與估計費用，GEMINI_DAILY_BUDGET 為 0 時不送出預算警示
+	GeminiPrices      string  `yaml:"gemini_prices" env:"GEMINI_PRICES"`
+	GeminiDailyBudget float64 `yaml:"gemini_daily_budget" env:"GEMINI_DAILY_BUDGET"`
+	TokenUsagePath    string  `yaml:"token_usage_path" env:"TOKEN_USAGE_PATH"`
+
+	// HTTP 伺服器
+	Port            int           `yaml:"port" env:"PORT"`
+	PublicBaseURL   string        `yaml:"public_base_url" env:"PUBLIC_BASE_URL"`
+	ReadTimeout     time.Duration `yaml:"read_timeout" env:"HTTP_READ_TIMEOUT"`
+	WriteTimeout    time.Duration `yaml:"write_timeout" env:"HTTP_WRITE_TIMEOUT"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout" env:"HTTP_IDLE_TIMEOUT"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"`
+	MaxBodyBytes    int           `yaml:"max_body_bytes" env:"CALLBACK_MAX_BODY_BYTES"`
+
+	// Gemini 與 Notion 的重試、斷路器與每次呼叫的期限
+	RetryMaxAttempts int           `yaml:"retry_max_attempts" env:"RETRY_MAX_ATTEMPTS"`
+	RetryBaseDelay   time.Duration `yaml:"retry_base_delay" env:"RETRY_BASE_DELAY"`
+	RetryMaxDelay    time.Duration `yaml:"retry_max_delay" env:"RETRY_MAX_DELAY"`
+	BreakerThreshold int           `yaml:"breaker_threshold" env:"BREAKER_THRESHOLD"`
+	BreakerCooldown  time.Duration `yaml:"breaker_cooldown" env:"BREAKER_COOLDOWN"`
+	GeminiTimeout    time.Duration `yaml:"gemini_timeout" env:"GEMINI_TIMEOUT"`
+	NotionTimeout    time.Duration `yaml:"notion_timeout" env:"NOTION_TIMEOUT"`
+
+	// 每位用戶與每個群組的掃描、搜尋次數限制，0 表示不限制；ADMIN_USER_IDS 可以用「配額」指令調整每日配額
+	ScanRateLimit    int    `yaml:"scan_rate_limit" env:"SCAN_RATE_LIMIT"`
+	ScanDailyQuota   int    `yaml:"scan_daily_quota" env:"SCAN_DAILY_QUOTA"`
+	SearchRateLimit  int    `yaml:"search_rate_limit" env:"SEARCH_RATE_LIMIT"`
+	SearchDailyQuota int    `yaml:"search_daily_quota" env:"SEARCH_DAILY_QUOTA"`
+	UsageStorePath   string `yaml:"usage_store_path" env:"USAGE_STORE_PATH"`
+	AdminUserIDs     string `yaml:"admin_user_ids" env:"ADMIN_USER_IDS"`
+
+	// Log
+	LogLevel  string `yaml:"log_level" env:"LOG_LEVEL"`
+	LogFormat string `yaml:"log_format" env:"LOG_FORMAT"`
+	LogRedact string `yaml:"log_redact" env:"LOG_REDACT"`
+
+	// OpenTelemetry tracing
+	OTLPEndpoint string `yaml:"otlp_endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+	OTLPHeaders  string `yaml:"otlp_headers" env:"OTEL_EXPORTER_OTLP_HEADERS" secret:"true"`
+	ServiceName  string `yaml:"service_name" env:"OTEL_SERVICE_NAME"`
+
+	// 名片照片儲存
+	BlobStore     string `yaml:"blob_store" env:"BLOB_STORE"`
+	BlobLocalDir  string `yaml:"blob_local_dir" env:"BLOB_LOCAL_DIR"`
+	S3Endpoint    string `yaml:"s3_endpoint" env:"S3_ENDPOINT"`
+	S3Region      string `yaml:"s3_region" env:"S3_REGION"`
+	S3Bucket      string `yaml:"s3_bucket" env:"S3_BUCKET"`
+	S3AccessKey   string `yaml:"s3_access_key" env:"S3_ACCESS_KEY" secret:"true"`
+	S3SecretKey   string `yaml:"s3_secret_key" env:"S3_SECRET_KEY" secret:"true"`
+	S3PublicURL   string `yaml:"s3_public_url" env:"S3_PUBLIC_URL"`
+	BlobPrefixKey string `yaml:"blob_prefix_key" env:"BLOB_PREFIX_KEY" secret:"true"`
+
+	// 公司名稱正規化
+	CompanyRegistry        string `yaml:"company_registry" env:"COMPANY_REGISTRY"`
+	CompanyRegistryFixture string `yaml:"company_registry_fixture" env:"COMPANY_REGISTRY_FIXTURE"`
+	CompanyAliasesPath     string `yaml:"company_aliases_path" env:"COMPANY_ALIASES_PATH"`
+
+	// 本地資料
+	ReminderStorePath string `yaml:"reminder_store_path" env:"REMINDER_STORE_PATH"`
+	ConsentStorePath  string `yaml:"consent_store_path" env:"CONSENT_STORE_PATH"`
+	PurgeStorePath    string `yaml:"purge_store_path" env:"PURGE_STORE_PATH"`
+	AuditLogPath      string `yaml:"audit_log_path" env:"AUDIT_LOG_PATH"`
+	CardQueuePath     string `yaml:"card_queue_path" env:"CARD_QUEUE_PATH"`
+	ExportStorePath   string `yaml:"export_store_path" env:"EXPORT_STORE_PATH"`
+
+	// 已處理的 webhook 事件，EventStorePath 為空時只存在記憶體
+	EventStorePath string        `yaml:"event_store_path" env:"EVENT_STORE_PATH"`
+	EventTTL       time.Duration `yaml:"event_ttl" env:"WEBHOOK_EVENT_TTL"`
+
+	// 個資欄位加密
+	PIIEncryptionKeys string `yaml:"pii_encryption_keys" env:"PII_ENCRYPTION_KEYS" secret:"true"`
+	PIIActiveKey      string `yaml:"pii_active_key" env:"PII_ACTIVE_KEY"`
+	PIIIndexKey       string `yaml:"pii_index_key" env:"PII_INDEX_KEY" secret:"true"`
+}
+
+// DefaultConfig 回傳所有欄位的預設值。
+func DefaultConfig() *Config {
+	return &Config{
+		NotionBaseURL:         "https://api.notion.com",
+		CardPrompt:            ImagePrompt,
+		PromptAssignmentsPath: "data/prompt_assignments.json",
+		VectorIndexPath:       "data/vectors.json",
+		GeminiPrices:          "gemini-pro=0.5/1.5,gemini-pro-vision=0.5/1.5,gemini-1.5-pro-latest=3.5/10.5",
+		TokenUsagePath:        "data/token_usage.json",
+		Port:                  8080,
+		ReadTimeout:           10 * time.Second,
+		WriteTimeout:          60 * time.Second,
+		IdleTimeout:           120 * time.Second,
+		ShutdownTimeout:       30 * time.Second,
+		MaxBodyBytes:          1 << 20,
+		RetryMaxAttempts:      3,
+		RetryBaseDelay:        500 * time.Millisecond,
+		RetryMaxDelay:         10 * time.Second,
+		BreakerThreshold:      5,
+		BreakerCooldown:       30 * time.Second,
+		GeminiTimeout:         60 * time.Second,
+		NotionTimeout:         30 * time.Second,
+		ScanRateLimit:         5,
+		ScanDailyQuota:        100,
+		SearchRateLimit:       20,
+		SearchDailyQuota:      500,
+		UsageStorePath:        "data/usage.json",
+		LogLevel:              "info",
+		LogFormat:             "text",
+		LogRedact:             "email,phone,name",
+		ServiceName:           "linebot-smart-namecard",
+		BlobLocalDir:          "data/images",
+		ReminderStorePath:     "data/reminders.json",
+		ConsentStorePath:      "data/consents.json",
+		PurgeStorePath:        "data/purges.json",
+		AuditLogPath:          "data/audit.jsonl",
+		CardQueuePath:         "data/card_queue.json",
+		ExportStorePath:       "data/exports.json",
+		EventStorePath:        "data/events.json",
+		EventTTL:              24 * time.Hour,
+	}
+}
+
+// LoadConfig 載入設定：設定檔由 -config 參數或 CONFIG_FILE 環境變數指定，沒有時只使用環境變數與參數。
+// getenv 通常是 os.Getenv，測試時可以替換。
+func LoadConfig(args []string, getenv func(string) string) (*Config, error) {
+	cfg := DefaultConfig()
+
+	fs := flag.NewFlagSet("linebot-smart-namecard", flag.ContinueOnError)
+	configPath := fs.String("config", getenv("CONFIG_FILE"), "YAML config file")
+	flags := make(map[string]*string)
+	forEachConfigField(cfg, func(field reflect.StructField, _ reflect.Value) {
+		name := strings.ReplaceAll(field.Tag.Get("yaml"), "_", "-")
+		flags[field.Name] = fs.String(name, "", fmt.Sprintf("overrides %s", field.Tag.Get("env")))
+	})
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configPath != "" {
+		data, err := os.ReadFile(*configPath)
+		if err != nil {
+			return nil, fmt.Errorf("error reading config file: %w", err)
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(cfg); err != nil {
+			return nil, fmt.Errorf("error parsing config file %s: %w", *configPath, err)
+		}
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	var errs []error
+	forEachConfigField(cfg, func(field reflect.StructField, v reflect.Value) {
+		value := getenv(field.Tag.Get("env"))
+		ok := value != ""
+		if name := strings.ReplaceAll(field.Tag.Get("yaml"), "_", "-"); set[name] {
+			value, ok = *flags[field.Name], true
+		}
+		if !ok {
+			return
+		}
+		if err := setConfigField(v, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", field.Tag.Get("env"), err))
+		}
+	})
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// notionIDPattern 是 Notion 資料庫 ID：32 個十六進位字元，可以包含 UUID 的減號。
+var notionIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{12}$`)
+
+// Validate 檢查 command（serve、richmenu 或 rotate-keys）需要的設定與所有已設定欄位的格式，回傳所有問題。
+func (c *Config) Validate(command string) error {
+	var errs []error
+	require := func(value, env string) {
+		if value == "" {
+			errs = append(errs, fmt.Errorf("%s is required", env))
+		}
+	}
+	switch command {
+	case "serve":
+		require(c.ChannelAccessToken, "ChannelAccessToken")
+		require(c.ChannelSecret, "ChannelSecret")
+		require(c.NotionToken, "NOTION_INTEGRATION_TOKEN")
+		require(c.NotionDatabaseID, "NOTION_DB_PAGEID")
+		require(c.GeminiAPIKey, "GOOGLE_GEMINI_API_KEY")
+	case "richmenu":
+		require(c.ChannelAccessToken, "ChannelAccessToken")
+	case "rotate-keys":
+		require(c.NotionToken, "NOTION_INTEGRATION_TOKEN")
+		require(c.NotionDatabaseID, "NOTION_DB_PAGEID")
+		require(c.PIIEncryptionKeys, "PII_ENCRYPTION_KEYS")
+	default:
+		errs = append(errs, fmt.Errorf("unknown command %q", command))
+	}
+
+	if c.NotionDatabaseID != "" && !notionIDPattern.MatchString(c.NotionDatabaseID) {
+		errs = append(errs, errors.New("NOTION_DB_PAGEID must be a 32 character Notion database ID"))
+	}
+	if c.Port <= 0 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("PORT %d is out of range", c.Port))
+	}
+	for _, f := range []struct {
+		env   string
+		value time.Duration
+	}{
+		{"HTTP_READ_TIMEOUT", c.ReadTimeout},
+		{"HTTP_WRITE_TIMEOUT", c.WriteTimeout},
+		{"HTTP_IDLE_TIMEOUT", c.IdleTimeout},
+		{"SHUTDOWN_TIMEOUT", c.ShutdownTimeout},
+		{"WEBHOOK_EVENT_TTL", c.EventTTL},
+		{"RETRY_BASE_DELAY", c.RetryBaseDelay},
+		{"RETRY_MAX_DELAY", c.RetryMaxDelay},
+		{"BREAKER_COOLDOWN", c.BreakerCooldown},
+		{"GEMINI_TIMEOUT", c.GeminiTimeout},
+		{"NOTION_TIMEOUT", c.NotionTimeout},
+	} {
+		if f.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", f.env))
+		}
+	}
+	for _, f := range []struct {
+		env   string
+		value int
+	}{
+		{"CALLBACK_MAX_BODY_BYTES", c.MaxBodyBytes},
+		{"RETRY_MAX_ATTEMPTS", c.RetryMaxAttempts},
+		{"BREAKER_THRESHOLD", c.BreakerThreshold},
+	} {
+		if f.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", f.env))
+		}
+	}
+	for _, f := range []struct {
+		env   string
+		value int
+	}{
+		{"SCAN_RATE_LIMIT", c.ScanRateLimit},
+		{"SCAN_DAILY_QUOTA", c.ScanDailyQuota},
+		{"SEARCH_RATE_LIMIT", c.SearchRateLimit},
+		{"SEARCH_DAILY_QUOTA", c.SearchDailyQuota},
+	} {
+		if f.value < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative", f.env))
+		}
+	}
+	if c.GeminiDailyBudget < 0 {
+		errs = append(errs, errors.New("GEMINI_DAILY_BUDGET must not be negative"))
+	}
+	if _, err := ParseModelPrices(c.GeminiPrices); err != nil {
+		errs = append(errs, fmt.Errorf("GEMINI_PRICES: %w", err))
+	}
+	if _, err := c.NewLogger(io.Discard); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := parseOTLPHeaders(c.OTLPHeaders); err != nil {
+		errs = append(errs, err)
+	}
+	for _, f := range []struct{ env, value string }{
+		{"NOTION_BASE_URL", c.NotionBaseURL},
+		{"OTEL_EXPORTER_OTLP_ENDPOINT", c.OTLPEndpoint},
+		{"PUBLIC_BASE_URL", c.PublicBaseURL},
+		{"S3_ENDPOINT", c.S3Endpoint},
+		{"S3_PUBLIC_URL", c.S3PublicURL},
+	} {
+		if f.value == "" {
+			continue
+		}
+		if u, err := url.Parse(f.value); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("%s must be an http(s) URL, got %q", f.env, f.value))
+		}
+	}
+
+	switch c.BlobStore {
+	case "":
+	case "local":
+		require(c.PublicBaseURL, "PUBLIC_BASE_URL (BLOB_STORE=local)")
+	case "s3":
+		require(c.S3Endpoint, "S3_ENDPOINT (BLOB_STORE=s3)")
+		require(c.S3Bucket, "S3_BUCKET (BLOB_STORE=s3)")
+		require(c.S3AccessKey, "S3_ACCESS_KEY (BLOB_STORE=s3)")
+		require(c.S3SecretKey, "S3_SECRET_KEY (BLOB_STORE=s3)")
+	default:
+		errs = append(errs, fmt.Errorf("BLOB_STORE must be local or s3, got %q", c.BlobStore))
+	}
+
+	switch c.CompanyRegistry {
+	case "", "gcis":
+	case "fixture":
+		require(c.CompanyRegistryFixture, "COMPANY_REGISTRY_FIXTURE (COMPANY_REGISTRY=fixture)")
+	default:
+		errs = append(errs, fmt.Errorf("COMPANY_REGISTRY must be gcis or fixture, got %q", c.CompanyRegistry))
+	}
+
+	if c.PIIEncryptionKeys != "" {
+		if _, err := c.FieldCipher(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// BlobSecret 回傳計算檔案目錄名稱與簽署下載網址的金鑰：BLOB_PREFIX_KEY，未設定時為 ChannelSecret。
+func (c *Config) BlobSecret() string {
+	if c.BlobPrefixKey != "" {
+		return c.BlobPrefixKey
+	}
+	return c.ChannelSecret
+}
+
+// FieldCipher 依照 PII_ENCRYPTION_KEYS、PII_ACTIVE_KEY 與 PII_INDEX_KEY 建立欄位加密，未設定金鑰時回傳 nil。
+func (c *Config) FieldCipher() (*FieldCipher, error) {
+	if c.PIIEncryptionKeys == "" {
+		return nil, nil
+	}
+	keys, err := ParseFieldKeys(c.PIIEncryptionKeys)
+	if err != nil {
+		return nil, fmt.Errorf("PII_ENCRYPTION_KEYS: %w", err)
+	}
+	indexKey, err := base64.StdEncoding.DecodeString(c.PIIIndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("PII_INDEX_KEY: %w", err)
+	}
+	cipher, err := NewFieldCipher(keys, c.PIIActiveKey, indexKey)
+	if err != nil {
+		return nil, fmt.Errorf("PII_ENCRYPTION_KEYS: %w", err)
+	}
+	return cipher, nil
+}
+
+// Summary 回傳每個設定一行的摘要，secret 欄位只顯示是否已設定。
+func (c *Config) Summary() string {
+	var b strings.Builder
+	forEachConfigField(c, func(field reflect.StructField, v reflect.Value) {
+		value := fmt.Sprint(v.Interface())
+		if field.Tag.Get("secret") == "true" && value != "" {
+			value = "<redacted>"
+		}
+		if field.Name == "CardPrompt" && len([]rune(value)) > 40 {
+			value = string([]rune(value)[:40]) + "…"
+		}
+		fmt.Fprintf(&b, "%s=%s\n", field.Tag.Get("env"), value)
+	})
+	return b.String()
+}
+
+// forEachConfigField 依照宣告順序走訪 Config 的欄位。
+func forEachConfigField(c *Config, fn func(reflect.StructField, reflect.Value)) {
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		fn(v.Type().Field(i), v.Field(i))
+	}
+}
+
+// setConfigField 將字串轉成欄位的型別後寫入。
+func setConfigField(v reflect.Value, value string) error {
+	switch v.Interface().(type) {
+	case string:
+		v.SetString(value)
+	case int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		v.SetInt(int64(n))
+	case float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		v.SetFloat(f)
+	case bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		v.SetBool(b)
+	case time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		v.SetInt(int64(d))
+	default:
+		return fmt.Errorf("unsupported config type %s", v.Type())
+	}
+	return nil
+}
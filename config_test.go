@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("notion_db_page_id: from-file\nblob_store: local\nport: 3000\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{
+		"CONFIG_FILE":      path,
+		"NOTION_DB_PAGEID": "from-env",
+		"ChannelSecret":    "secret",
+	}
+
+	cfg, err := LoadConfig([]string{"-notion-db-page-id", "from-flag"}, func(key string) string { return env[key] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 命令列參數 > 環境變數 > 設定檔 > 預設值
+	if cfg.NotionDatabaseID != "from-flag" {
+		t.Errorf("NotionDatabaseID = %q, want from-flag", cfg.NotionDatabaseID)
+	}
+	if cfg.ChannelSecret != "secret" || cfg.BlobStore != "local" || cfg.Port != 3000 {
+		t.Errorf("got %+v", cfg)
+	}
+	if cfg.ReminderStorePath != "data/reminders.json" || cfg.CardPrompt != ImagePrompt {
+		t.Errorf("defaults not applied: %+v", cfg)
+	}
+
+	if _, err := LoadConfig(nil, func(key string) string { return map[string]string{"PORT": "http"}[key] }); err == nil || !strings.Contains(err.Error(), "PORT") {
+		t.Errorf("LoadConfig() with PORT=http err = %v", err)
+	}
+	if err := os.WriteFile(path, []byte("notion_db_pageid: typo\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(nil, func(key string) string { return env[key] }); err == nil {
+		t.Error("LoadConfig() accepted an unknown key in the config file")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.BlobStore = "s3"
+	cfg.PublicBaseURL = "bot.example.com"
+	cfg.NotionDatabaseID = "b764"
+
+	err := cfg.Validate("serve")
+	if err == nil {
+		t.Fatal("Validate() = nil for an empty config")
+	}
+	for _, want := range []string{"ChannelAccessToken is required", "NOTION_INTEGRATION_TOKEN is required", "NOTION_DB_PAGEID must be", "PUBLIC_BASE_URL must be", "S3_BUCKET"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate() = %v, want %q", err, want)
+		}
+	}
+
+	cfg = DefaultConfig()
+	cfg.ChannelAccessToken = "token"
+	if err := cfg.Validate("richmenu"); err != nil {
+		t.Errorf("Validate(richmenu) = %v", err)
+	}
+	cfg.ChannelSecret, cfg.NotionToken, cfg.GeminiAPIKey = "secret", "notion", "gemini"
+	cfg.NotionDatabaseID = "b7645f1e-2c3d-4e5f-8a9b-0c1d2e3f4a5b"
+	cfg.PIIEncryptionKeys, cfg.PIIActiveKey = "k1:c2hvcnQ=", "k1"
+	if err := cfg.Validate("serve"); err == nil || !strings.Contains(err.Error(), "PII_ENCRYPTION_KEYS") {
+		t.Errorf("Validate() with a short key = %v", err)
+	}
+	cfg.PIIEncryptionKeys = ""
+	if err := cfg.Validate("serve"); err != nil {
+		t.Errorf("Validate(serve) = %v", err)
+	}
+
+	cfg.LogLevel, cfg.LogRedact = "verbose", "address"
+	if err := cfg.Validate("serve"); err == nil || !strings.Contains(err.Error(), "LOG_LEVEL") || !strings.Contains(err.Error(), "LOG_REDACT") {
+		t.Errorf("Validate() with bad log settings = %v", err)
+	}
+}
+
+func TestConfigSummaryRedactsSecrets(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ChannelSecret = "line-channel-secret"
+	cfg.NotionToken = "secret_notion"
+	cfg.NotionDatabaseID = "b764"
+
+	summary := cfg.Summary()
+	for _, secret := range []string{"line-channel-secret", "secret_notion"} {
+		if strings.Contains(summary, secret) {
+			t.Errorf("Summary() leaks %q:\n%s", secret, summary)
+		}
+	}
+	for _, want := range []string{"ChannelSecret=<redacted>", "NOTION_DB_PAGEID=b764", "ChannelAccessToken=\n", "PORT=8080"} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("Summary() missing %q:\n%s", want, summary)
+		}
+	}
+}
+
+func TestConfigExample(t *testing.T) {
+	cfg, err := LoadConfig([]string{"-config", "config.example.yaml"}, func(string) string { return "" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *cfg != *DefaultConfig() {
+		t.Errorf("config.example.yaml differs from the defaults:\n%s", cfg.Summary())
+	}
+}
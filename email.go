@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+// MaxMailtoLength 是 LINE URI action 的長度上限，超過時 mailto 連結只帶主旨。
+const MaxMailtoLength = 1000
+
+// emailLanguages 是可選擇的追蹤信語言。
+var emailLanguages = map[string]string{
+	"zh-TW": "繁體中文",
+	"en":    "English",
+	"ja":    "日本語",
+}
+
+// emailTones 是可選擇的追蹤信語氣。
+var emailTones = map[string]string{
+	"formal":   "正式、專業",
+	"friendly": "親切、輕鬆",
+}
+
+// emailRevisions 是草稿下方快速回覆按鈕的修改要求。
+var emailRevisions = map[string]string{
+	"short":  "請寫得更簡短",
+	"formal": "請寫得更正式",
+}
+
+// emailDraft 是一封追蹤信的多輪對話狀態。
+type emailDraft struct {
+	To         string
+	Subject    string
+	Body       string
+	History    []*genai.Content
+	MessageIDs []string // 最近一次回覆草稿的訊息 ID，引用這些訊息回覆才視為修改要求
+}
+
+// emailPrompt 建立撰寫追蹤信的提示，包含聯絡人資訊與我們的備註。
+func emailPrompt(person Person, lang, tone string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "請幫我寫一封在商務場合認識後的追蹤 email，使用%s，語氣%s。\n", emailLanguages[lang], emailTones[tone])
+	fmt.Fprintf(&b, "收件人：%s，職稱：%s，公司：%s。\n", person.Name, person.Title, person.Company)
+	if person.Event != "" || person.MetDate != "" {
+		fmt.Fprintf(&b, "我們在 %s %s 認識。\n", person.MetDate, person.Event)
+	}
+	if person.Notes != "" {
+		fmt.Fprintf(&b, "我的備註：%s\n", person.Notes)
+	}
+	b.WriteString("請只輸出信件，第一行格式為「Subject: 主旨」，空一行後是信件內文。")
+	return b.String()
+}
+
+// parseDraft 從模型回覆中取出主旨與內文。
+func parseDraft(text string) (string, string) {
+	text = strings.TrimSpace(text)
+	first, rest, _ := strings.Cut(text, "\n")
+	for _, prefix := range []string{"Subject:", "主旨:", "主旨：", "件名:", "件名："} {
+		if strings.HasPrefix(strings.TrimLeft(first, "*# "), prefix) {
+			subject := strings.TrimSpace(strings.TrimPrefix(strings.TrimLeft(first, "*# "), prefix))
+			return strings.Trim(subject, "* "), strings.TrimSpace(rest)
+		}
+	}
+	return "", text
+}
+
+// mailtoLink 建立預先填好主旨與內文的 mailto 連結，太長時只保留主旨。
+func mailtoLink(to, subject, body string) string {
+	escape := func(s string) string {
+		return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+	}
+	to = url.PathEscape(to)
+	link := fmt.Sprintf("mailto:%s?subject=%s&body=%s", to, escape(subject), escape(body))
+	if len(link) > MaxMailtoLength {
+		link = fmt.Sprintf("mailto:%s?subject=%s", to, escape(subject))
+	}
+	return link
+}
+
+// draftEmail 請模型撰寫或修改草稿，並更新對話狀態。請求經過 gemini 的重試與斷路器。
+func draftEmail(ctx context.Context, gemini *Dependency, model ChatModel, draft emailDraft, msg string) (emailDraft, error) {
+	var reply string
+	var history []*genai.Content
+	err := gemini.Do(ctx, func(ctx context.Context) error {
+		var err error
+		reply, history, err = model.Chat(ctx, draft.History, msg)
+		return err
+	})
+	if err != nil {
+		return draft, err
+	}
+	draft.History = history
+	subject, body := parseDraft(reply)
+	if subject != "" {
+		draft.Subject = subject
+	}
+	draft.Body = body
+	return draft, nil
+}
+
+// handleEmailPostback 處理名片上的「寫信」按鈕：先選擇語言與語氣，再撰寫草稿；也處理草稿下方的修改與完成按鈕。
+// key 是傳送者的對話狀態 key（見 sessionKey），群組中每位成員各自修改自己的草稿。
+func (s *Server) handleEmailPostback(ctx context.Context, replyToken, key string, nDB *NotionDB, data url.Values) {
+	if s.Chat == nil {
+		return
+	}
+
+	switch data.Get("action") {
+	case "email_revise":
+		if msg, ok := emailRevisions[data.Get("req")]; ok {
+			s.reviseEmail(ctx, replyToken, key, msg)
+		}
+		return
+	case "email_done":
+		s.finishEmail(ctx, replyToken, key)
+		return
+	}
+
+	pageID := data.Get("page")
+	lang, tone := data.Get("lang"), data.Get("tone")
+	if _, ok := emailLanguages[lang]; !ok {
+		s.replyEmailOptions(ctx, replyToken, pageID)
+		return
+	}
+	if _, ok := emailTones[tone]; !ok {
+		tone = "formal"
+	}
+
+	person, err := nDB.GetPage(ctx, pageID)
+	if err != nil {
+		loggerFrom(ctx).Error("Error getting contact for email", "err", err)
+		if err := s.replyText(ctx, replyToken, "找不到這張名片，請重新查詢"); err != nil {
+			loggerFrom(ctx).Error("Error replying", "err", err)
+		}
+		return
+	}
+
+	draft, err := draftEmail(ctx, s.Gemini, s.Chat, emailDraft{To: person.Email}, emailPrompt(person, lang, tone))
+	if err != nil {
+		loggerFrom(ctx).Error("Error drafting email", "err", err)
+		if err := s.replyText(ctx, replyToken, "無法產生信件草稿，請稍後再試"); err != nil {
+			loggerFrom(ctx).Error("Error replying", "err", err)
+		}
+		return
+	}
+	s.replyEmailDraft(ctx, replyToken, key, draft)
+}
+
+// handleEmailRevision 在用戶引用自己的草稿回覆時，將文字訊息視為修改要求，回傳是否已處理。
+// 沒有引用草稿的訊息照常處理，寫信期間不會攔截一般的對話與搜尋。
+func (s *Server) handleEmailRevision(ctx context.Context, replyToken, key, quotedMessageID, text string) bool {
+	draft, ok := s.state.emails.Get(key)
+	if !ok || s.Chat == nil || quotedMessageID == "" || !slices.Contains(draft.MessageIDs, quotedMessageID) {
+		return false
+	}
+
+	if text == "完成" || text == "結束寫信" {
+		s.finishEmail(ctx, replyToken, key)
+		return true
+	}
+	s.reviseEmail(ctx, replyToken, key, text)
+	return true
+}
+
+// reviseEmail 依照修改要求 msg 修改用戶的草稿。
+func (s *Server) reviseEmail(ctx context.Context, replyToken, key, msg string) {
+	draft, ok := s.state.emails.Get(key)
+	if !ok {
+		if err := s.replyText(ctx, replyToken, "草稿已過期，請重新按下名片上的「寫信」"); err != nil {
+			loggerFrom(ctx).Error("Error replying", "err", err)
+		}
+		return
+	}
+
+	draft, err := draftEmail(ctx, s.Gemini, s.Chat, draft, msg+"\n請用同樣的格式輸出修改後的完整信件。")
+	if err != nil {
+		loggerFrom(ctx).Error("Error revising email", "err", err)
+		if err := s.replyText(ctx, replyToken, "無法修改信件草稿，請稍後再試"); err != nil {
+			loggerFrom(ctx).Error("Error replying", "err", err)
+		}
+		return
+	}
+	s.replyEmailDraft(ctx, replyToken, key, draft)
+}
+
+// finishEmail 結束用戶的寫信對話。
+func (s *Server) finishEmail(ctx context.Context, replyToken, key string) {
+	s.state.emails.Delete(key)
+	if err := s.replyText(ctx, replyToken, "已結束寫信"); err != nil {
+		loggerFrom(ctx).Error("Error replying", "err", err)
+	}
+}
+
+// replyEmailOptions 回覆選擇語言與語氣的快速回覆按鈕。
+func (s *Server) replyEmailOptions(ctx context.Context, replyToken, pageID string) {
+	options := []struct{ label, lang, tone string }{
+		{"中文・正式", "zh-TW", "formal"},
+		{"中文・輕鬆", "zh-TW", "friendly"},
+		{"English・Formal", "en", "formal"},
+		{"English・Friendly", "en", "friendly"},
+		{"日本語・丁寧", "ja", "formal"},
+	}
+
+	var items []messaging_api.QuickReplyItem
+	for _, o := range options {
+		items = append(items, messaging_api.QuickReplyItem{
+			Type: "action",
+			Action: &messaging_api.PostbackAction{
+				Label:       o.label,
+				Data:        fmt.Sprintf("action=email&page=%s&lang=%s&tone=%s", pageID, o.lang, o.tone),
+				DisplayText: o.label,
+			},
+		})
+	}
+
+	if _, err := s.Bot.ReplyMessage(
+		&messaging_api.ReplyMessageRequest{
+			ReplyToken: replyToken,
+			Messages: []messaging_api.MessageInterface{
+				&messaging_api.TextMessage{
+					Text:       "要用什麼語言與語氣寫信？",
+					QuickReply: &messaging_api.QuickReply{Items: items},
+				},
+			},
+		},
+	); err != nil {
+		loggerFrom(ctx).Error("Error replying", "err", err)
+	}
+}
+
+// replyEmailDraft 回覆信件草稿與開啟郵件 App 的按鈕，並以 key 保存草稿與回覆的訊息 ID。
+func (s *Server) replyEmailDraft(ctx context.Context, replyToken, key string, draft emailDraft) {
+	text := fmt.Sprintf("主旨：%s\n\n%s\n\n引用這則草稿回覆修改要求可以繼續調整，或按下方的按鈕。", draft.Subject, draft.Body)
+	items := []messaging_api.QuickReplyItem{
+		{Type: "action", Action: &messaging_api.PostbackAction{Label: "更簡短", Data: "action=email_revise&req=short", DisplayText: emailRevisions["short"]}},
+		{Type: "action", Action: &messaging_api.PostbackAction{Label: "更正式", Data: "action=email_revise&req=formal", DisplayText: emailRevisions["formal"]}},
+		{Type: "action", Action: &messaging_api.PostbackAction{Label: "完成", Data: "action=email_done", DisplayText: "完成"}},
+	}
+
+	resp, err := s.Bot.ReplyMessage(
+		&messaging_api.ReplyMessageRequest{
+			ReplyToken: replyToken,
+			Messages: []messaging_api.MessageInterface{
+				&messaging_api.TextMessage{
+					Text: text,
+				},
+				&messaging_api.FlexMessage{
+					AltText: "開啟郵件 App 寄出追蹤信",
+					Contents: &messaging_api.FlexBubble{
+						Body: &messaging_api.FlexBox{
+							Layout: messaging_api.FlexBoxLAYOUT_VERTICAL,
+							Contents: []messaging_api.FlexComponentInterface{
+								&messaging_api.FlexButton{
+									Style: messaging_api.FlexButtonSTYLE_PRIMARY,
+									Action: &messaging_api.UriAction{
+										Label: "開啟郵件 App",
+										Uri:   mailtoLink(draft.To, draft.Subject, draft.Body),
+									},
+								},
+							},
+						},
+					},
+					QuickReply: &messaging_api.QuickReply{Items: items},
+				},
+			},
+		},
+	)
+	if err != nil {
+		loggerFrom(ctx).Error("Error replying", "err", err)
+	}
+	draft.MessageIDs = nil
+	if resp != nil {
+		for _, m := range resp.SentMessages {
+			draft.MessageIDs = append(draft.MessageIDs, m.Id)
+		}
+	}
+	s.state.emails.Set(key, draft)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// fakeChatModel 依序回傳預先準備的回覆，並記錄收到的訊息。
+type fakeChatModel struct {
+	replies  []string
+	messages []string
+}
+
+func (f *fakeChatModel) Chat(_ context.Context, history []*genai.Content, msg string) (string, []*genai.Content, error) {
+	f.messages = append(f.messages, msg)
+	reply := f.replies[0]
+	f.replies = f.replies[1:]
+	history = append(history,
+		&genai.Content{Role: "user", Parts: []genai.Part{genai.Text(msg)}},
+		&genai.Content{Role: "model", Parts: []genai.Part{genai.Text(reply)}},
+	)
+	return reply, history, nil
+}
+
+func TestDraftEmail(t *testing.T) {
+	model := &fakeChatModel{replies: []string{
+		"Subject: 很高興在 COMPUTEX 認識您\n\n王先生您好，\n感謝您撥空交流。",
+		"**Subject:** 感謝交流\n\n王先生您好，謝謝。",
+	}}
+	person := Person{Name: "王小明", Title: "業務經理", Company: "台積電", Email: "ming@example.com", Event: "COMPUTEX", Notes: "對報價有興趣"}
+
+	prompt := emailPrompt(person, "zh-TW", "formal")
+	for _, want := range []string{"繁體中文", "正式", "王小明", "台積電", "COMPUTEX", "對報價有興趣"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("emailPrompt() missing %q: %s", want, prompt)
+		}
+	}
+
+	draft, err := draftEmail(context.Background(), nil, model, emailDraft{To: person.Email}, prompt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if draft.Subject != "很高興在 COMPUTEX 認識您" || !strings.HasPrefix(draft.Body, "王先生您好") {
+		t.Errorf("draft = %+v", draft)
+	}
+
+	// 第二輪修改會帶著前一輪的對話紀錄
+	draft, err = draftEmail(context.Background(), nil, model, draft, "請寫得更簡短")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if draft.Subject != "感謝交流" || len(draft.History) != 4 {
+		t.Errorf("revised draft = %q, history = %d", draft.Subject, len(draft.History))
+	}
+}
+
+func TestMailtoLink(t *testing.T) {
+	link := mailtoLink("ming@example.com", "Hello there", "Line 1\nLine 2")
+	want := "mailto:ming@example.com?subject=Hello%20there&body=Line%201%0ALine%202"
+	if link != want {
+		t.Errorf("mailtoLink() = %q, want %q", link, want)
+	}
+
+	long := mailtoLink("ming@example.com", "Hi", strings.Repeat("很長的內文", 100))
+	if long != "mailto:ming@example.com?subject=Hi" {
+		t.Errorf("mailtoLink() with long body = %q", long)
+	}
+
+	// 收件人來自名片辨識，不能改變連結的其他部分
+	if link := mailtoLink("a@example.com?bcc=x@evil.example", "Hi", ""); strings.Contains(link, "?bcc=") {
+		t.Errorf("mailtoLink() with a crafted address = %q", link)
+	}
+}
+
+func TestWebhookEmailRevisionRequiresQuote(t *testing.T) {
+	h := newWebhookHarness(t)
+	model := &fakeChatModel{replies: []string{"Subject: 你好\n\n草稿一", "Subject: 你好\n\n草稿二"}}
+	h.Server.Chat = model
+	p := h.AddContact("G1", Person{Name: "王小明", Email: "ming@example.com"})
+
+	h.Post(h.Postback(groupSource("G1", "U1"), "action=email&page="+p.PageID+"&lang=zh-TW&tone=formal"))
+	draft, ok := h.Server.state.emails.Get(sessionKeyFor("G1", "U1"))
+	if !ok || len(draft.MessageIDs) == 0 {
+		t.Fatalf("draft = %+v, %v, want the reply message IDs", draft, ok)
+	}
+
+	// 沒有引用草稿的訊息，或其他成員引用草稿，都不會被當作修改要求
+	h.Post(h.Text(groupSource("G1", "U1"), "今天天氣不錯"))
+	h.Post(h.QuoteText(groupSource("G1", "U2"), "請寫得更短", draft.MessageIDs[0]))
+	if len(model.messages) != 1 {
+		t.Fatalf("model got %d messages, want only the first draft", len(model.messages))
+	}
+
+	h.Post(h.QuoteText(groupSource("G1", "U1"), "請寫得更短", draft.MessageIDs[0]))
+	if len(model.messages) != 2 || !strings.HasPrefix(model.messages[1], "請寫得更短") {
+		t.Fatalf("model messages = %q", model.messages)
+	}
+
+	h.Post(h.Postback(groupSource("G1", "U1"), "action=email_done"))
+	if _, ok := h.Server.state.emails.Get(sessionKeyFor("G1", "U1")); ok {
+		t.Error("draft left after 完成")
+	}
+}
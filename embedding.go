@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// SemanticMinScore 是語意搜尋結果預設的最低相似度（cosine similarity）。
+const SemanticMinScore = 0.6
+
+// SemanticTopK 是語意搜尋回傳的最多筆數。
+const SemanticTopK = 5
+
+// Embedder 將文字轉換為向量。
+type Embedder interface {
+	Embed(ctx context.Context, text string) ([]float32, error)
+}
+
+// GeminiEmbedder 使用 Gemini embedding model 產生向量。
+type GeminiEmbedder struct {
+	APIKey string
+	Model  string
+	Meter  *TokenMeter // 記錄呼叫次數，nil 時不記錄
+}
+
+// Embed 呼叫 Gemini embedding API 取得文字的向量。
+func (g *GeminiEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	client, err := newGeminiClient(ctx, g.APIKey, g.Meter)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	model := g.Model
+	if model == "" {
+		model = "embedding-001"
+	}
+	resp, err := client.EmbeddingModel(model).EmbedContent(ctx, genai.Text(text))
+	if err != nil {
+		return nil, err
+	}
+	if resp.Embedding == nil {
+		return nil, fmt.Errorf("empty embedding")
+	}
+	return resp.Embedding.Values, nil
+}
+
+// vectorEntry 是向量索引中的一筆名片資料。
+type vectorEntry struct {
+	UID    string    `json:"uid"`
+	Person Person    `json:"person"`
+	Vector []float32 `json:"vector"`
+}
+
+// SemanticIndex 是存放於本地檔案的名片向量索引。
+type SemanticIndex struct {
+	// MinScore 是結果的最低相似度，低於此分數的名片不會回傳
+	MinScore float64
+	// Gemini 是 embedding API 的重試策略與斷路器，nil 時不重試
+	Gemini *Dependency
+
+	mu         sync.RWMutex
+	embedder   Embedder
+	cipher     *FieldCipher
+	path       string
+	entries    []vectorEntry
+	backfilled map[string]bool // 本次執行已經從資料庫補齊索引的名片簿
+}
+
+// NewSemanticIndex 建立語意搜尋索引，若 path 不為空則從檔案載入既有的向量。
+// cipher 不為 nil 時，檔案中名片的個資欄位以密文保存。
+func NewSemanticIndex(embedder Embedder, path string, cipher *FieldCipher) (*SemanticIndex, error) {
+	s := &SemanticIndex{MinScore: SemanticMinScore, embedder: embedder, cipher: cipher, path: path, backfilled: make(map[string]bool)}
+	if path != "" {
+		if err := loadJSONFile(path, &s.entries); err != nil {
+			return nil, fmt.Errorf("error loading vector index: %w", err)
+		}
+		for i, e := range s.entries {
+			s.entries[i].Person = cipher.DecryptPerson(e.Person)
+		}
+	}
+	return s, nil
+}
+
+// contactDocument 將名片與備註組成用於產生向量的文字。
+func contactDocument(person Person) string {
+	fields := []string{
+		"Name: " + person.Name,
+		"Title: " + person.Title,
+		"Company: " + person.Company,
+		"Address: " + person.Address,
+		"Email: " + person.Email,
+	}
+	if person.Event != "" {
+		fields = append(fields, "Event: "+person.Event)
+	}
+	if len(person.Tags) > 0 {
+		fields = append(fields, "Tags: "+strings.Join(person.Tags, ", "))
+	}
+	if person.Notes != "" {
+		fields = append(fields, "Notes: "+person.Notes)
+	}
+	return strings.Join(fields, "\n")
+}
+
+// embed 經過 Gemini 的重試與斷路器產生 text 的向量。
+func (s *SemanticIndex) embed(ctx context.Context, text string) ([]float32, error) {
+	var vec []float32
+	err := s.Gemini.Do(ctx, func(ctx context.Context) error {
+		var err error
+		vec, err = s.embedder.Embed(ctx, text)
+		return err
+	})
+	return vec, err
+}
+
+// Index 產生名片的向量並存入索引。同一名片簿同一 Notion 頁面的舊資料會被取代。
+func (s *SemanticIndex) Index(ctx context.Context, uid string, person Person) error {
+	if person.PageID == "" {
+		return fmt.Errorf("contact has no page ID")
+	}
+	vec, err := s.embed(ctx, contactDocument(person))
+	if err != nil {
+		return fmt.Errorf("error embedding contact: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(vectorEntry{UID: uid, Person: person, Vector: normalizeVector(vec)})
+	return s.save()
+}
+
+// put 以 (UID, PageID) 為鍵新增或取代一筆索引資料。呼叫前需持有寫入鎖。
+func (s *SemanticIndex) put(entry vectorEntry) {
+	for i, e := range s.entries {
+		if e.UID == entry.UID && e.Person.PageID == entry.Person.PageID {
+			s.entries[i] = entry
+			return
+		}
+	}
+	s.entries = append(s.entries, entry)
+}
+
+// Backfill 將資料庫中還沒有向量的名片補進此名片簿的索引，並移除資料庫中已不存在或沒有 PageID 的舊資料。
+// 每個名片簿在程式執行期間只需要補齊一次，之後由新增與更新名片時的 Index 維護。
+func (s *SemanticIndex) Backfill(ctx context.Context, nDB *NotionDB) error {
+	s.mu.RLock()
+	done := s.backfilled[nDB.UID]
+	indexed := make(map[string]bool)
+	for _, e := range s.entries {
+		if e.UID == nDB.UID {
+			indexed[e.Person.PageID] = true
+		}
+	}
+	s.mu.RUnlock()
+	if done {
+		return nil
+	}
+
+	people, err := nDB.QueryDatabaseByUID(ctx)
+	if err != nil {
+		return err
+	}
+	pages := make(map[string]bool, len(people))
+	var added []vectorEntry
+	var embedErr error
+	for _, p := range people {
+		pages[p.PageID] = true
+		if indexed[p.PageID] {
+			continue
+		}
+		vec, err := s.embed(ctx, contactDocument(p))
+		if err != nil {
+			embedErr = fmt.Errorf("error embedding contact: %w", err)
+			break
+		}
+		added = append(added, vectorEntry{UID: nDB.UID, Person: p, Vector: normalizeVector(vec)})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := s.entries[:0]
+	for _, e := range s.entries {
+		if e.UID != nDB.UID || e.Person.PageID != "" && pages[e.Person.PageID] {
+			entries = append(entries, e)
+		}
+	}
+	s.entries = entries
+	for _, e := range added {
+		s.put(e)
+	}
+	if embedErr == nil {
+		s.backfilled[nDB.UID] = true
+	}
+	if err := s.save(); err != nil {
+		return err
+	}
+	return embedErr
+}
+
+// indexContact 建立名片的向量並存入 uid 的語意搜尋索引，未啟用語意搜尋時略過。
+// 索引失敗不影響名片的新增或更新，只記錄錯誤。
+func (s *Server) indexContact(ctx context.Context, uid string, person Person) {
+	if s.Semantic == nil {
+		return
+	}
+	if err := s.Semantic.Index(ctx, uid, person); err != nil {
+		loggerFrom(ctx).Error("Error indexing page for semantic search", "err", err)
+	}
+}
+
+// SearchBook 以語意搜尋此名片簿的名片，若此名片簿尚未補齊索引則先從 Notion 資料庫補齊。
+func (s *SemanticIndex) SearchBook(ctx context.Context, nDB *NotionDB, query string, k int) ([]Person, error) {
+	if err := s.Backfill(ctx, nDB); err != nil {
+		return nil, fmt.Errorf("error backfilling vector index: %w", err)
+	}
+	return s.Search(ctx, nDB.UID, query, k)
+}
+
+// Search 以最近鄰搜尋找出與查詢語意最接近的名片。
+func (s *SemanticIndex) Search(ctx context.Context, uid, query string, k int) ([]Person, error) {
+	vec, err := s.embed(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("error embedding query: %w", err)
+	}
+	vec = normalizeVector(vec)
+
+	type hit struct {
+		person Person
+		score  float64
+	}
+	var hits []hit
+
+	s.mu.RLock()
+	for _, e := range s.entries {
+		if e.UID != uid {
+			continue
+		}
+		if score := dotProduct(vec, e.Vector); score >= s.MinScore {
+			hits = append(hits, hit{person: e.Person, score: score})
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].score > hits[j].score })
+	var results []Person
+	for i := 0; i < len(hits) && i < k; i++ {
+		results = append(results, hits[i].person)
+	}
+	return results, nil
+}
+
+// DeleteUser 從索引中移除用戶的所有名片。
+func (s *SemanticIndex) DeleteUser(uid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := s.entries[:0]
+	for _, e := range s.entries {
+		if e.UID != uid {
+			entries = append(entries, e)
+		}
+	}
+	s.entries = entries
+	delete(s.backfilled, uid)
+
+	return s.save()
+}
+
+// save 將索引寫入檔案，設定欄位加密時名片的個資欄位以密文保存。呼叫前需持有寫入鎖。
+func (s *SemanticIndex) save() error {
+	if s.path == "" {
+		return nil
+	}
+	entries := make([]vectorEntry, len(s.entries))
+	for i, e := range s.entries {
+		person, err := s.cipher.EncryptPerson(e.Person)
+		if err != nil {
+			return err
+		}
+		entries[i] = vectorEntry{UID: e.UID, Person: person, Vector: e.Vector}
+	}
+	return saveJSONFile(s.path, entries)
+}
+
+// normalizeVector 將向量正規化為單位長度，讓內積即為 cosine similarity。
+func normalizeVector(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return v
+	}
+	norm := math.Sqrt(sum)
+	out := make([]float32, len(v))
+	for i, x := range v {
+		out[i] = float32(float64(x) / norm)
+	}
+	return out
+}
+
+// dotProduct 計算兩個向量的內積，長度不同時以較短者為準。
+func dotProduct(a, b []float32) float64 {
+	var sum float64
+	for i := 0; i < len(a) && i < len(b); i++ {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"unicode"
+)
+
+// fakeEmbedder 是可重現的本地 Embedder，以 hashing trick 將單字與漢字映射到固定維度。
+type fakeEmbedder struct{}
+
+func (fakeEmbedder) Embed(_ context.Context, text string) ([]float32, error) {
+	vec := make([]float32, 128)
+	add := func(token string) {
+		h := fnv.New32a()
+		h.Write([]byte(token))
+		vec[h.Sum32()%uint32(len(vec))]++
+	}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len(word) > 2 {
+			add(word)
+		}
+	}
+	for _, r := range text {
+		if unicode.Is(unicode.Han, r) {
+			add(string(r))
+		}
+	}
+	return vec, nil
+}
+
+func TestSemanticIndexSearch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vectors.json")
+	idx, err := NewSemanticIndex(fakeEmbedder{}, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx.MinScore = 0.2
+
+	ctx := context.Background()
+	people := []Person{
+		{Name: "Bob Lin", Title: "Cloud Security Architect", Company: "First Bank", Email: "bob@bank.com", PageID: "p1"},
+		{Name: "Carol Wu", Title: "Pastry Chef", Company: "Sweet Bakery", Email: "carol@bakery.com", PageID: "p2"},
+	}
+	for _, p := range people {
+		if err := idx.Index(ctx, "uid", p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := idx.Search(ctx, "uid", "cloud security guy at the bank", SemanticTopK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) == 0 || results[0].Name != "Bob Lin" {
+		t.Fatalf("Search() = %v, want Bob Lin first", results)
+	}
+
+	if results, _ := idx.Search(ctx, "other", "cloud security bank", SemanticTopK); len(results) != 0 {
+		t.Errorf("Search for other user = %v, want no results", results)
+	}
+
+	// 重新載入後應保有相同資料
+	reloaded, err := NewSemanticIndex(fakeEmbedder{}, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded.MinScore = 0.2
+	results, err = reloaded.Search(ctx, "uid", "pastry chef bakery", SemanticTopK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) == 0 || results[0].Name != "Carol Wu" {
+		t.Fatalf("reloaded Search() = %v, want Carol Wu first", results)
+	}
+
+	// 同一頁面重新索引會取代舊資料，同名同 Email 但不同頁面的名片各自保留
+	if err := idx.Index(ctx, "uid", Person{Name: "Bob Lin", Title: "CISO", Company: "First Bank", Email: "bob@bank.com", PageID: "p1"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.entries) != 2 {
+		t.Errorf("len(entries) = %d, want 2", len(idx.entries))
+	}
+	if err := idx.Index(ctx, "uid", Person{Name: "Bob Lin", Title: "Engineer", Company: "Other Bank", Email: "bob@bank.com", PageID: "p3"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.entries) != 3 {
+		t.Errorf("len(entries) = %d, want 3", len(idx.entries))
+	}
+	if err := idx.Index(ctx, "uid", Person{Name: "No Page"}); err == nil {
+		t.Error("Index() without a page ID succeeded, want error")
+	}
+}
+
+func TestSemanticIndexBackfill(t *testing.T) {
+	h := newWebhookHarness(t)
+	nDB := h.Notion.NotionDB("db", "U1")
+	kept := h.AddContact("U1", Person{Name: "Bob Lin", Title: "Cloud Security Architect", Company: "First Bank"})
+	h.AddContact("U1", Person{Name: "Carol Wu", Title: "Pastry Chef", Company: "Sweet Bakery"})
+
+	idx, err := NewSemanticIndex(fakeEmbedder{}, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx.MinScore = 0.2
+	// 舊版以 Email 為鍵、沒有 PageID 的資料，以及已經刪除的名片都會被移除
+	idx.entries = []vectorEntry{
+		{UID: "U1", Person: Person{Name: "Legacy"}},
+		{UID: "U1", Person: Person{Name: "Deleted", PageID: "gone"}},
+		{UID: "U1", Person: kept, Vector: []float32{1}},
+		{UID: "U2", Person: Person{Name: "Other", PageID: "x"}},
+	}
+
+	ctx := context.Background()
+	results, err := idx.SearchBook(ctx, nDB, "pastry chef bakery", SemanticTopK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) == 0 || results[0].Name != "Carol Wu" {
+		t.Fatalf("SearchBook() = %v, want Carol Wu first", results)
+	}
+	if len(idx.entries) != 3 {
+		t.Fatalf("entries = %+v, want Bob, Carol and the other book", idx.entries)
+	}
+	// 已經有向量的名片不重新產生
+	for _, e := range idx.entries {
+		if e.Person.PageID == kept.PageID && len(e.Vector) != 1 {
+			t.Errorf("indexed contact was embedded again")
+		}
+	}
+}
+
+// eventEmbedder 記錄每次產生向量時 ctx 是否帶有事件的 logger，再交給 fakeEmbedder。
+type eventEmbedder struct {
+	fakeEmbedder
+	mu        sync.Mutex
+	withEvent []bool
+}
+
+func (e *eventEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	_, ok := ctx.Value(loggerKey{}).(*slog.Logger)
+	e.mu.Lock()
+	e.withEvent = append(e.withEvent, ok)
+	e.mu.Unlock()
+	return e.fakeEmbedder.Embed(ctx, text)
+}
+
+func TestWebhookIndexesContacts(t *testing.T) {
+	h := newWebhookHarness(t)
+	embedder := &eventEmbedder{}
+	index, err := NewSemanticIndex(embedder, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Server.Semantic = index
+
+	h.SetCard("m1", "```json\n{\"name\":\"王小明\",\"company\":\"第一銀行\",\"email\":\"ming@bank.example\"}\n```")
+	h.Post(h.Image(userSource("U1"), "m1"))
+	people, err := h.Notion.NotionDB("db", "U1").QueryDatabaseByUID(context.Background())
+	if err != nil || len(people) != 1 {
+		t.Fatalf("contacts = %+v, %v", people, err)
+	}
+	h.Post(h.Postback(userSource("U1"), "action=note&page="+people[0].PageID))
+	h.Post(h.Text(userSource("U1"), "對雲端資安有興趣"))
+
+	// 新增與補充備註後都更新索引，並使用事件的 ctx
+	index.mu.RLock()
+	entries := append([]vectorEntry(nil), index.entries...)
+	index.mu.RUnlock()
+	if len(entries) != 1 || entries[0].UID != "U1" || entries[0].Person.Notes != "對雲端資安有興趣" {
+		t.Fatalf("index entries = %+v", entries)
+	}
+	if len(embedder.withEvent) != 2 {
+		t.Fatalf("embedded %d times, want 2", len(embedder.withEvent))
+	}
+	for i, ok := range embedder.withEvent {
+		if !ok {
+			t.Errorf("embedding %d did not use the event context", i)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// ExportLinkTTL 是匯出檔下載連結的有效時間，過期後刪除檔案。
+	ExportLinkTTL = time.Hour
+	// ExportCheckInterval 是排程器檢查過期匯出檔的間隔。
+	ExportCheckInterval = time.Minute
+)
+
+// ExportStore 記錄尚未刪除的匯出檔與到期時間（檔案 key -> 到期時間），path 為空時只存在記憶體。
+type ExportStore struct {
+	mu    sync.Mutex
+	path  string
+	items map[string]time.Time
+}
+
+// NewExportStore 建立匯出檔紀錄，若 path 不為空則從檔案載入尚未刪除的匯出檔。
+func NewExportStore(path string) (*ExportStore, error) {
+	s := &ExportStore{path: path, items: make(map[string]time.Time)}
+	if path != "" {
+		if err := loadJSONFile(path, &s.items); err != nil {
+			return nil, fmt.Errorf("error loading exports: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// Add 記錄匯出檔 key 在 expires 到期。
+func (s *ExportStore) Add(key string, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[key] = expires
+	return s.save()
+}
+
+// Due 回傳所有已到期的匯出檔。
+func (s *ExportStore) Due(now time.Time) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for key, expires := range s.items {
+		if !expires.After(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Remove 移除已刪除的匯出檔。
+func (s *ExportStore) Remove(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.items, key)
+	return s.save()
+}
+
+// save 將紀錄寫入檔案，呼叫前需持有鎖。
+func (s *ExportStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	return saveJSONFile(s.path, s.items)
+}
+
+// runExportCleanup 定期刪除過期的匯出檔，直到 ctx 結束。
+func (s *Server) runExportCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.deleteExpiredExports(ctx, now)
+		}
+	}
+}
+
+// deleteExpiredExports 刪除過期的匯出檔，刪除失敗時下次再試。
+func (s *Server) deleteExpiredExports(ctx context.Context, now time.Time) {
+	for _, key := range s.Exports.Due(now) {
+		if err := s.Images.Delete(ctx, key); err != nil {
+			slog.Error("Error deleting export", "job", "export", "err", err)
+			continue
+		}
+		if err := s.Exports.Remove(key); err != nil {
+			slog.Error("Error saving exports", "job", "export", "err", err)
+		}
+	}
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+// fakeNotion 是測試用的 Notion API，實作名片簿用到的資料庫查詢、新增、讀取、更新與封存頁面。
+// 不支援的 filter 會回傳 validation_error，避免測試在 filter 寫錯時誤判為查不到資料。
+type fakeNotion struct {
+	*httptest.Server
+
+	// Token 不為空時檢查 Authorization header
+	Token string
+	// MaxPageSize 是每次查詢最多回傳的頁面數，用來測試分頁
+	MaxPageSize int
+
+	mu      sync.Mutex
+	pages   []*fakePage
+	queries int
+	clock   time.Time
+}
+
+// fakePage 是 fakeNotion 保存的頁面，Properties 以 API 的 JSON 格式保存。
+type fakePage struct {
+	ID         string
+	DatabaseID string
+	Created    time.Time
+	Edited     time.Time
+	Archived   bool
+	Properties map[string]map[string]any
+}
+
+// newFakeNotion 啟動 fakeNotion，測試結束時關閉。
+func newFakeNotion(t *testing.T) *fakeNotion {
+	t.Helper()
+	f := &fakeNotion{
+		Token:       "secret_test",
+		MaxPageSize: 100,
+		clock:       time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/databases/", f.handleQuery)
+	mux.HandleFunc("/v1/pages", f.handleCreate)
+	mux.HandleFunc("/v1/pages/", f.handlePage)
+	f.Server = httptest.NewServer(f.authorize(mux))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// NotionDB 建立連到 fakeNotion 的名片簿。
+func (f *fakeNotion) NotionDB(databaseID, uid string) *NotionDB {
+	return &NotionDB{DatabaseID: databaseID, Token: f.Token, UID: uid, BaseURL: f.URL}
+}
+
+// Page 回傳頁面目前的內容，找不到時為 nil。
+func (f *fakeNotion) Page(id string) *fakePage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.find(id)
+}
+
+// Queries 回傳收到的資料庫查詢次數，分頁的每一頁各算一次。
+func (f *fakeNotion) Queries() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.queries
+}
+
+// Text 回傳頁面屬性的純文字內容。
+func (p *fakePage) Text(property string) string {
+	return fakePlainText(p.Properties[property])
+}
+
+func (f *fakeNotion) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if f.Token != "" && r.Header.Get("Authorization") != "Bearer "+f.Token {
+			writeNotionError(w, http.StatusUnauthorized, "unauthorized", "API token is invalid.")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleQuery 處理 POST /v1/databases/{id}/query。
+func (f *fakeNotion) handleQuery(w http.ResponseWriter, r *http.Request) {
+	id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/v1/databases/"), "/query")
+	if !ok || r.Method != http.MethodPost {
+		writeNotionError(w, http.StatusNotFound, "invalid_request_url", "Invalid request URL.")
+		return
+	}
+
+	var req struct {
+		Filter      map[string]any   `json:"filter"`
+		Sorts       []map[string]any `json:"sorts"`
+		StartCursor string           `json:"start_cursor"`
+		PageSize    int              `json:"page_size"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeNotionError(w, http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queries++
+
+	var matched []*fakePage
+	for _, p := range f.pages {
+		if p.DatabaseID != id || p.Archived {
+			continue
+		}
+		if req.Filter != nil {
+			ok, err := matchFilter(p, req.Filter)
+			if err != nil {
+				writeNotionError(w, http.StatusBadRequest, "validation_error", err.Error())
+				return
+			}
+			if !ok {
+				continue
+			}
+		}
+		matched = append(matched, p)
+	}
+	if err := sortPages(matched, req.Sorts); err != nil {
+		writeNotionError(w, http.StatusBadRequest, "validation_error", err.Error())
+		return
+	}
+
+	start := 0
+	if req.StartCursor != "" {
+		start = -1
+		for i, p := range matched {
+			if p.ID == req.StartCursor {
+				start = i
+				break
+			}
+		}
+		if start < 0 {
+			writeNotionError(w, http.StatusBadRequest, "validation_error", "start_cursor is invalid.")
+			return
+		}
+	}
+	size := f.MaxPageSize
+	if req.PageSize > 0 && req.PageSize < size {
+		size = req.PageSize
+	}
+	end := min(start+size, len(matched))
+
+	results := []any{}
+	for _, p := range matched[start:end] {
+		results = append(results, p.JSON())
+	}
+	var next any
+	if end < len(matched) {
+		next = matched[end].ID
+	}
+	writeNotionJSON(w, map[string]any{
+		"object":      "list",
+		"results":     results,
+		"has_more":    next != nil,
+		"next_cursor": next,
+	})
+}
+
+// handleCreate 處理 POST /v1/pages。
+func (f *fakeNotion) handleCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeNotionError(w, http.StatusMethodNotAllowed, "invalid_request", "Method not allowed.")
+		return
+	}
+	var req struct {
+		Parent struct {
+			DatabaseID string `json:"database_id"`
+		} `json:"parent"`
+		Properties map[string]map[string]any `json:"properties"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeNotionError(w, http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+	if req.Parent.DatabaseID == "" {
+		writeNotionError(w, http.StatusBadRequest, "validation_error", "parent.database_id should be defined.")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.clock = f.clock.Add(time.Second)
+	p := &fakePage{
+		ID:         fmt.Sprintf("00000000-0000-4000-8000-%012d", len(f.pages)+1),
+		DatabaseID: req.Parent.DatabaseID,
+		Created:    f.clock,
+		Edited:     f.clock,
+		Properties: make(map[string]map[string]any),
+	}
+	if err := p.update(req.Properties); err != nil {
+		writeNotionError(w, http.StatusBadRequest, "validation_error", err.Error())
+		return
+	}
+	f.pages = append(f.pages, p)
+	writeNotionJSON(w, p.JSON())
+}
+
+// handlePage 處理 GET 與 PATCH /v1/pages/{id}。
+func (f *fakeNotion) handlePage(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p := f.find(strings.TrimPrefix(r.URL.Path, "/v1/pages/"))
+	if p == nil {
+		writeNotionError(w, http.StatusNotFound, "object_not_found", "Could not find page.")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPatch:
+		var req struct {
+			Properties map[string]map[string]any `json:"properties"`
+			Archived   *bool                     `json:"archived"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeNotionError(w, http.StatusBadRequest, "invalid_json", err.Error())
+			return
+		}
+		if err := p.update(req.Properties); err != nil {
+			writeNotionError(w, http.StatusBadRequest, "validation_error", err.Error())
+			return
+		}
+		if req.Archived != nil {
+			p.Archived = *req.Archived
+		}
+		f.clock = f.clock.Add(time.Second)
+		p.Edited = f.clock
+	default:
+		writeNotionError(w, http.StatusMethodNotAllowed, "invalid_request", "Method not allowed.")
+		return
+	}
+	writeNotionJSON(w, p.JSON())
+}
+
+// find 依照 ID 找頁面，呼叫前需持有鎖。
+func (f *fakeNotion) find(id string) *fakePage {
+	for _, p := range f.pages {
+		if p.ID == id {
+			return p
+		}
+	}
+	return nil
+}
+
+// update 合併要更新的屬性，並補上 API 回應中會有的 type 與 plain_text。
+func (p *fakePage) update(properties map[string]map[string]any) error {
+	for name, prop := range properties {
+		typ := ""
+		for _, t := range []string{"title", "rich_text", "multi_select", "date", "files"} {
+			if _, ok := prop[t]; ok {
+				typ = t
+				break
+			}
+		}
+		if typ == "" {
+			return fmt.Errorf("unsupported property %q", name)
+		}
+		prop["type"] = typ
+		if typ == "title" || typ == "rich_text" {
+			items, _ := prop[typ].([]any)
+			// Notion 限制每個屬性最多 100 段，每段最多 2000 字
+			if len(items) > NotionMaxRichTextItems {
+				return fmt.Errorf("body.properties.%s.%s.length should be ≤ %d", name, typ, NotionMaxRichTextItems)
+			}
+			for _, item := range items {
+				if m, ok := item.(map[string]any); ok {
+					m["type"] = "text"
+					text, _ := m["text"].(map[string]any)
+					content, _ := text["content"].(string)
+					if len(utf16.Encode([]rune(content))) > NotionMaxTextLength {
+						return fmt.Errorf("body.properties.%s.%s[].text.content.length should be ≤ %d", name, typ, NotionMaxTextLength)
+					}
+					if _, ok := m["plain_text"]; !ok {
+						m["plain_text"] = content
+					}
+				}
+			}
+		}
+		p.Properties[name] = prop
+	}
+	return nil
+}
+
+// JSON 回傳頁面的 API 格式。
+func (p *fakePage) JSON() map[string]any {
+	return map[string]any{
+		"object":           "page",
+		"id":               p.ID,
+		"created_time":     p.Created.Format(time.RFC3339),
+		"last_edited_time": p.Edited.Format(time.RFC3339),
+		"archived":         p.Archived,
+		"parent":           map[string]any{"type": "database_id", "database_id": p.DatabaseID},
+		"properties":       p.Properties,
+	}
+}
+
+// matchFilter 判斷頁面是否符合 filter，支援 and、or 與 title、rich_text、multi_select、date 條件。
+func matchFilter(p *fakePage, filter map[string]any) (bool, error) {
+	for _, op := range []string{"and", "or"} {
+		list, ok := filter[op].([]any)
+		if !ok {
+			continue
+		}
+		for _, item := range list {
+			sub, ok := item.(map[string]any)
+			if !ok {
+				return false, fmt.Errorf("invalid %s filter", op)
+			}
+			matched, err := matchFilter(p, sub)
+			if err != nil {
+				return false, err
+			}
+			if op == "and" && !matched {
+				return false, nil
+			}
+			if op == "or" && matched {
+				return true, nil
+			}
+		}
+		return op == "and", nil
+	}
+
+	name, _ := filter["property"].(string)
+	if name == "" {
+		return false, fmt.Errorf("filter without property: %v", filter)
+	}
+	prop := p.Properties[name]
+	for typ, cond := range filter {
+		if typ == "property" || typ == "type" {
+			continue
+		}
+		c, ok := cond.(map[string]any)
+		if !ok || len(c) != 1 {
+			return false, fmt.Errorf("invalid %s filter on %q", typ, name)
+		}
+		for op, want := range c {
+			switch typ {
+			case "title", "rich_text":
+				return matchText(fakePlainText(prop), op, want)
+			case "multi_select":
+				return matchMultiSelect(prop, op, want)
+			case "date":
+				return matchDate(prop, op, want)
+			}
+			return false, fmt.Errorf("unsupported filter type %q", typ)
+		}
+	}
+	return false, fmt.Errorf("filter without condition on %q", name)
+}
+
+func matchText(value, op string, want any) (bool, error) {
+	s, _ := want.(string)
+	switch op {
+	case "equals":
+		return value == s, nil
+	case "does_not_equal":
+		return value != s, nil
+	case "contains":
+		// Notion 的 contains 不分大小寫
+		return strings.Contains(strings.ToLower(value), strings.ToLower(s)), nil
+	case "starts_with":
+		return strings.HasPrefix(strings.ToLower(value), strings.ToLower(s)), nil
+	case "is_empty":
+		return value == "", nil
+	case "is_not_empty":
+		return value != "", nil
+	}
+	return false, fmt.Errorf("unsupported text filter %q", op)
+}
+
+func matchMultiSelect(prop map[string]any, op string, want any) (bool, error) {
+	if op != "contains" && op != "does_not_contain" {
+		return false, fmt.Errorf("unsupported multi_select filter %q", op)
+	}
+	found := false
+	options, _ := prop["multi_select"].([]any)
+	for _, option := range options {
+		if m, ok := option.(map[string]any); ok && m["name"] == want {
+			found = true
+		}
+	}
+	return found == (op == "contains"), nil
+}
+
+func matchDate(prop map[string]any, op string, want any) (bool, error) {
+	date, _ := prop["date"].(map[string]any)
+	start, _ := date["start"].(string)
+	s, _ := want.(string)
+	if start == "" {
+		return false, nil
+	}
+	// 名片的會面日期只有日期，以日期比對
+	value, bound := fakeDay(start), fakeDay(s)
+	switch op {
+	case "equals":
+		return value == bound, nil
+	case "on_or_after":
+		return value >= bound, nil
+	case "on_or_before":
+		return value <= bound, nil
+	case "after":
+		return value > bound, nil
+	case "before":
+		return value < bound, nil
+	}
+	return false, fmt.Errorf("unsupported date filter %q", op)
+}
+
+// sortPages 依照 sorts 排序，支援 created_time、last_edited_time 與文字屬性。
+func sortPages(pages []*fakePage, sorts []map[string]any) error {
+	for i := len(sorts) - 1; i >= 0; i-- {
+		s := sorts[i]
+		desc := s["direction"] == "descending"
+		var less func(a, b *fakePage) bool
+		switch {
+		case s["timestamp"] == "created_time":
+			less = func(a, b *fakePage) bool { return a.Created.Before(b.Created) }
+		case s["timestamp"] == "last_edited_time":
+			less = func(a, b *fakePage) bool { return a.Edited.Before(b.Edited) }
+		case s["property"] != nil:
+			name, _ := s["property"].(string)
+			less = func(a, b *fakePage) bool { return a.Text(name) < b.Text(name) }
+		default:
+			return fmt.Errorf("unsupported sort %v", s)
+		}
+		sort.SliceStable(pages, func(i, j int) bool {
+			if desc {
+				return less(pages[j], pages[i])
+			}
+			return less(pages[i], pages[j])
+		})
+	}
+	return nil
+}
+
+// fakePlainText 串接 title 或 rich_text 屬性的 plain_text。
+func fakePlainText(prop map[string]any) string {
+	items, _ := prop["rich_text"].([]any)
+	if title, ok := prop["title"].([]any); ok {
+		items = title
+	}
+	var b strings.Builder
+	for _, item := range items {
+		if m, ok := item.(map[string]any); ok {
+			text, _ := m["plain_text"].(string)
+			b.WriteString(text)
+		}
+	}
+	return b.String()
+}
+
+// fakeDay 取出 RFC 3339 日期時間的日期部分。
+func fakeDay(s string) string {
+	if len(s) > len(DateLayout) {
+		return s[:len(DateLayout)]
+	}
+	return s
+}
+
+func writeNotionJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeNotionError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"object":  "error",
+		"status":  status,
+		"code":    code,
+		"message": message,
+	})
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"unicode"
+)
+
+// encryptedPrefix 是加密欄位的前綴，沒有前綴的值視為尚未加密的舊資料。
+const encryptedPrefix = "enc:v1:"
+
+// FieldCipher 以信封加密保護名片的姓名、電話、Email 與地址：每個值使用隨機的資料金鑰 (DEK) 以 AES-GCM 加密，
+// DEK 再以設定中的主金鑰 (KEK) 加密後一起保存。更換主金鑰時只需要重新加密 DEK。
+// 另外以 HMAC 產生 blind index，讓 Email 與電話在加密後仍然可以完全比對。
+//
+// 所有方法都可以在 nil 上呼叫，此時不加密。
+type FieldCipher struct {
+	keys     map[string][]byte
+	active   string
+	indexKey []byte
+}
+
+// NewFieldCipher 建立欄位加密。keys 是金鑰 ID 對應 32 bytes 的主金鑰，active 是加密新資料使用的金鑰 ID，
+// indexKey 是產生 blind index 的 HMAC 金鑰，更換後既有的 blind index 會失效。
+func NewFieldCipher(keys map[string][]byte, active string, indexKey []byte) (*FieldCipher, error) {
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("invalid key id %q", id)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("key %q must be 32 bytes, got %d", id, len(key))
+		}
+	}
+	if _, ok := keys[active]; !ok {
+		return nil, fmt.Errorf("active key %q not found", active)
+	}
+	if len(indexKey) < 16 {
+		return nil, errors.New("blind index key must be at least 16 bytes")
+	}
+	return &FieldCipher{keys: keys, active: active, indexKey: indexKey}, nil
+}
+
+// ParseFieldKeys 解析 "id1:base64,id2:base64" 格式的金鑰設定。
+func ParseFieldKeys(spec string) (map[string][]byte, error) {
+	keys := make(map[string][]byte)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(item, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid key %q, want id:base64", item)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %w", id, err)
+		}
+		keys[id] = key
+	}
+	return keys, nil
+}
+
+// Encrypt 加密一個欄位，空字串維持空字串。
+func (c *FieldCipher) Encrypt(plaintext string) (string, error) {
+	if c == nil || plaintext == "" {
+		return plaintext, nil
+	}
+
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return "", err
+	}
+	sealed, err := sealGCM(dek, []byte(plaintext), nil)
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := sealGCM(c.keys[c.active], dek, []byte(c.active))
+	if err != nil {
+		return "", err
+	}
+	return encryptedPrefix + c.active + ":" + base64.RawStdEncoding.EncodeToString(wrapped) + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt 解密一個欄位，沒有加密前綴的舊資料直接回傳。
+func (c *FieldCipher) Decrypt(value string) (string, error) {
+	id, wrapped, sealed, ok, err := parseEncrypted(value)
+	if !ok || err != nil {
+		return value, err
+	}
+	if c == nil {
+		return "", errors.New("encrypted field but no key configured")
+	}
+
+	dek, err := c.unwrap(id, wrapped)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := openGCM(dek, sealed, nil)
+	if err != nil {
+		return "", fmt.Errorf("error decrypting field: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// Rotate 以目前的主金鑰重新加密欄位的 DEK，回傳新的值與是否有變更。明文的舊資料會被加密。
+func (c *FieldCipher) Rotate(value string) (string, bool, error) {
+	if c == nil || value == "" {
+		return value, false, nil
+	}
+	id, wrapped, sealed, ok, err := parseEncrypted(value)
+	if err != nil {
+		return value, false, err
+	}
+	if !ok {
+		encrypted, err := c.Encrypt(value)
+		return encrypted, err == nil, err
+	}
+	if id == c.active {
+		return value, false, nil
+	}
+
+	dek, err := c.unwrap(id, wrapped)
+	if err != nil {
+		return value, false, err
+	}
+	rewrapped, err := sealGCM(c.keys[c.active], dek, []byte(c.active))
+	if err != nil {
+		return value, false, err
+	}
+	return encryptedPrefix + c.active + ":" + base64.RawStdEncoding.EncodeToString(rewrapped) + ":" + base64.RawStdEncoding.EncodeToString(sealed), true, nil
+}
+
+// BlindIndex 回傳欄位正規化後的 HMAC，用於在加密資料上完全比對。field 為 email 或 phone。
+func (c *FieldCipher) BlindIndex(field, value string) string {
+	value = normalizeBlindValue(field, value)
+	if c == nil || value == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, c.indexKey)
+	mac.Write([]byte(field + ":" + value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// EncryptPerson 加密名片的姓名、電話、Email 與地址。
+func (c *FieldCipher) EncryptPerson(p Person) (Person, error) {
+	if c == nil {
+		return p, nil
+	}
+	for _, field := range []*string{&p.Name, &p.Phone, &p.Email, &p.Address} {
+		encrypted, err := c.Encrypt(*field)
+		if err != nil {
+			return p, fmt.Errorf("error encrypting contact: %w", err)
+		}
+		*field = encrypted
+	}
+	return p, nil
+}
+
+// DecryptPerson 解密名片的姓名、電話、Email 與地址，無法解密的欄位會清空並記錄錯誤。
+func (c *FieldCipher) DecryptPerson(p Person) Person {
+	for _, field := range []*string{&p.Name, &p.Phone, &p.Email, &p.Address} {
+		plaintext, err := c.Decrypt(*field)
+		if err != nil {
+			slog.Error("Error decrypting contact field", "err", err)
+		}
+		*field = plaintext
+	}
+	return p
+}
+
+// runRotateKeysCommand 以目前的主金鑰重新加密 Notion 資料庫中的所有名片與本地的向量索引。
+//
+//	linebot-smart-namecard rotate-keys
+func (s *Server) runRotateKeysCommand() error {
+	ctx := context.Background()
+	nDB := s.newNotionDB(ctx, "")
+	updated, err := nDB.RotateEncryptedFields(ctx)
+	slog.Info("Rotated pages", "count", updated)
+	if err != nil {
+		return err
+	}
+
+	// 向量索引在儲存時會以目前的主金鑰重新加密
+	if s.Semantic != nil {
+		s.Semantic.mu.Lock()
+		defer s.Semantic.mu.Unlock()
+		return s.Semantic.save()
+	}
+	return nil
+}
+
+// unwrap 以金鑰 ID 對應的主金鑰解開 DEK。
+func (c *FieldCipher) unwrap(id string, wrapped []byte) ([]byte, error) {
+	kek, ok := c.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", id)
+	}
+	dek, err := openGCM(kek, wrapped, []byte(id))
+	if err != nil {
+		return nil, fmt.Errorf("error unwrapping data key: %w", err)
+	}
+	return dek, nil
+}
+
+// parseEncrypted 解析加密欄位，ok 為 false 表示不是加密的值。
+func parseEncrypted(value string) (id string, wrapped, sealed []byte, ok bool, err error) {
+	rest, found := strings.CutPrefix(value, encryptedPrefix)
+	if !found {
+		return "", nil, nil, false, nil
+	}
+	parts := strings.Split(rest, ":")
+	if len(parts) != 3 {
+		return "", nil, nil, true, errors.New("malformed encrypted field")
+	}
+	if wrapped, err = base64.RawStdEncoding.DecodeString(parts[1]); err != nil {
+		return "", nil, nil, true, fmt.Errorf("malformed encrypted field: %w", err)
+	}
+	if sealed, err = base64.RawStdEncoding.DecodeString(parts[2]); err != nil {
+		return "", nil, nil, true, fmt.Errorf("malformed encrypted field: %w", err)
+	}
+	return parts[0], wrapped, sealed, true, nil
+}
+
+// normalizeBlindValue 正規化要比對的值：Email 轉小寫，電話只保留數字並移除分機。
+func normalizeBlindValue(field, value string) string {
+	value = strings.TrimSpace(value)
+	if value == "N/A" {
+		return ""
+	}
+	switch field {
+	case "email":
+		return strings.ToLower(value)
+	case "phone":
+		number, _, _ := strings.Cut(value, ",")
+		return strings.Map(func(r rune) rune {
+			if unicode.IsDigit(r) {
+				return r
+			}
+			return -1
+		}, number)
+	}
+	return value
+}
+
+// sealGCM 以 AES-256-GCM 加密，回傳 nonce 加上密文。
+func sealGCM(key, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// openGCM 解密 sealGCM 產生的資料。
+func openGCM(key, data, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
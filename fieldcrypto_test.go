@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testFieldCipher(t *testing.T, active string, ids ...string) *FieldCipher {
+	t.Helper()
+	keys := make(map[string][]byte)
+	for _, id := range ids {
+		keys[id] = bytes.Repeat([]byte(id[len(id)-1:]), 32)
+	}
+	c, err := NewFieldCipher(keys, active, []byte("blind-index-key-for-tests"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestFieldCipherRoundTrip(t *testing.T) {
+	c := testFieldCipher(t, "k1", "k1")
+
+	encrypted, err := c.Encrypt("王小明")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encrypted, "enc:v1:k1:") || strings.Contains(encrypted, "王小明") {
+		t.Errorf("Encrypt() = %q", encrypted)
+	}
+	if again, _ := c.Encrypt("王小明"); again == encrypted {
+		t.Error("Encrypt() should use a random data key for every value")
+	}
+	if got, err := c.Decrypt(encrypted); err != nil || got != "王小明" {
+		t.Errorf("Decrypt() = %q, %v", got, err)
+	}
+
+	// 尚未加密的舊資料直接回傳
+	if got, err := c.Decrypt("legacy@example.com"); err != nil || got != "legacy@example.com" {
+		t.Errorf("Decrypt(plaintext) = %q, %v", got, err)
+	}
+
+	// 被竄改的密文無法解密
+	tampered := encrypted[:len(encrypted)-2] + "AA"
+	if _, err := c.Decrypt(tampered); err == nil {
+		t.Error("Decrypt() of a tampered value should fail")
+	}
+}
+
+func TestFieldCipherNil(t *testing.T) {
+	var c *FieldCipher
+	p := Person{Name: "王小明", Email: "a@example.com"}
+	got, err := c.EncryptPerson(p)
+	if err != nil || got.Name != p.Name || got.Email != p.Email {
+		t.Errorf("nil EncryptPerson() = %+v, %v", got, err)
+	}
+	if c.BlindIndex("email", "a@example.com") != "" {
+		t.Error("nil BlindIndex() should be empty")
+	}
+}
+
+func TestFieldCipherRotate(t *testing.T) {
+	old := testFieldCipher(t, "k1", "k1")
+	encrypted, _ := old.Encrypt("0912-345-678")
+
+	c := testFieldCipher(t, "k2", "k1", "k2")
+	rotated, changed, err := c.Rotate(encrypted)
+	if err != nil || !changed || !strings.HasPrefix(rotated, "enc:v1:k2:") {
+		t.Fatalf("Rotate() = %q, %v, %v", rotated, changed, err)
+	}
+	if _, changed, _ := c.Rotate(rotated); changed {
+		t.Error("Rotate() of a value already under the active key should not change it")
+	}
+
+	// 移除舊金鑰後仍然可以解密已更新的值
+	newOnly := testFieldCipher(t, "k2", "k2")
+	if got, err := newOnly.Decrypt(rotated); err != nil || got != "0912-345-678" {
+		t.Errorf("Decrypt() after rotation = %q, %v", got, err)
+	}
+	if _, err := newOnly.Decrypt(encrypted); err == nil {
+		t.Error("Decrypt() with a retired key should fail")
+	}
+
+	// 明文的舊資料在 rotate 時會被加密
+	if migrated, changed, err := c.Rotate("a@example.com"); err != nil || !changed || !strings.HasPrefix(migrated, "enc:v1:k2:") {
+		t.Errorf("Rotate(plaintext) = %q, %v, %v", migrated, changed, err)
+	}
+}
+
+func TestBlindIndex(t *testing.T) {
+	c := testFieldCipher(t, "k1", "k1")
+
+	if c.BlindIndex("email", "Evan@Example.com ") != c.BlindIndex("email", "evan@example.com") {
+		t.Error("BlindIndex() should ignore email case and spaces")
+	}
+	if c.BlindIndex("phone", "#886-912-345-678,1234") != c.BlindIndex("phone", "886 912 345 678") {
+		t.Error("BlindIndex() should ignore phone formatting and extensions")
+	}
+	if c.BlindIndex("email", "a@example.com") == c.BlindIndex("phone", "a@example.com") {
+		t.Error("BlindIndex() should differ between fields")
+	}
+	if c.BlindIndex("email", "N/A") != "" {
+		t.Error("BlindIndex(N/A) should be empty")
+	}
+}
+
+func TestParseFieldKeys(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	keys, err := ParseFieldKeys("k2026:" + key + ", k2025:" + key)
+	if err != nil || len(keys) != 2 || len(keys["k2026"]) != 32 {
+		t.Errorf("ParseFieldKeys() = %v, %v", keys, err)
+	}
+	if _, err := ParseFieldKeys("nokey"); err == nil {
+		t.Error("ParseFieldKeys() should reject a key without id")
+	}
+	if _, err := NewFieldCipher(map[string][]byte{"k1": {1, 2, 3}}, "k1", []byte("blind-index-key-for-tests")); err == nil {
+		t.Error("NewFieldCipher() should reject a short key")
+	}
+}
+
+func TestSemanticIndexEncryptedAtRest(t *testing.T) {
+	cipher := testFieldCipher(t, "k1", "k1")
+	path := filepath.Join(t.TempDir(), "vectors.json")
+	index, err := NewSemanticIndex(fakeEmbedder{}, path, cipher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.MinScore = 0
+	if err := index.Index(context.Background(), "U1", Person{Name: "王小明", Email: "ming@example.com", Company: "Acme", PageID: "p1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "ming@example.com") || strings.Contains(string(data), "王小明") {
+		t.Error("vector index file contains plaintext contact fields")
+	}
+
+	index, err = NewSemanticIndex(fakeEmbedder{}, path, cipher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.MinScore = 0
+	results, _ := index.Search(context.Background(), "U1", "Acme", 1)
+	if len(results) != 1 || results[0].Email != "ming@example.com" {
+		t.Errorf("Search() after reload = %+v", results)
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"net/url"
 
 	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
@@ -9,42 +11,73 @@ import (
 const LogoImageUrl = "https://raw.githubusercontent.com/kkdai/linebot-smart-namecard/main/img/logo.jpeg"
 
 // SendFlexMsg: Send flex message to LINE server.
-func SendFlexMsg(replyToken string, people []Person, msg string) error {
+func (s *Server) SendFlexMsg(ctx context.Context, replyToken string, people []Person, msg string) error {
+	resp, err := s.Bot.ReplyMessage(
+		&messaging_api.ReplyMessageRequest{
+			ReplyToken: replyToken,
+			Messages:   s.cardMessages(people, msg),
+		},
+	)
+	if err != nil {
+		return err
+	}
+	loggerFrom(ctx).Debug("Replied with cards", "cards", len(people))
+	s.trackSentContacts(resp.SentMessages, people)
+	return nil
+}
+
+// PushFlexMsg 以 Push API 送出名片，用在已經沒有 reply token 的背景工作。
+func (s *Server) PushFlexMsg(to string, people []Person, msg string) error {
+	resp, err := s.Bot.PushMessage(&messaging_api.PushMessageRequest{To: to, Messages: s.cardMessages(people, msg)}, "")
+	if err != nil {
+		return err
+	}
+	s.trackSentContacts(resp.SentMessages, people)
+	return nil
+}
+
+// cardMessages 回傳說明文字與名片輪播的訊息，超過 MaxCarouselBubbles 張時只顯示前面幾張。
+func (s *Server) cardMessages(people []Person, msg string) []messaging_api.MessageInterface {
+	if len(people) > MaxCarouselBubbles {
+		msg += fmt.Sprintf("（共 %d 張，只顯示前 %d 張）", len(people), MaxCarouselBubbles)
+		people = people[:MaxCarouselBubbles]
+	}
+
 	var cards []messaging_api.FlexBubble
 	for _, card := range people {
-		cards = append(cards, getCardFlex(card))
+		cards = append(cards, s.getCardFlex(card))
 	}
 
 	contents := &messaging_api.FlexCarousel{
 		Contents: cards,
 	}
-
-	if _, err := bot.ReplyMessage(
-		&messaging_api.ReplyMessageRequest{
-			ReplyToken: replyToken,
-			Messages: []messaging_api.MessageInterface{
-				&messaging_api.TextMessage{
-					Text: msg,
-				},
-				&messaging_api.FlexMessage{
-					Contents: contents,
-					AltText:  "請到手機上查看名片資訊",
-				},
-			},
+	return []messaging_api.MessageInterface{
+		&messaging_api.TextMessage{
+			Text: msg,
+		},
+		&messaging_api.FlexMessage{
+			Contents: contents,
+			AltText:  "請到手機上查看名片資訊",
 		},
-	); err != nil {
-		return err
 	}
-	return nil
+}
+
+// trackSentContacts 記錄單張名片的訊息 ID，用戶引用回覆這則訊息時可以補充備註。
+func (s *Server) trackSentContacts(sent []messaging_api.SentMessage, people []Person) {
+	if len(people) == 1 && people[0].PageID != "" {
+		for _, m := range sent {
+			s.state.sent.Set(m.Id, people[0].PageID)
+		}
+	}
 }
 
 // getCardFlex: Send flex message to LINE server.
-func getCardFlex(card Person) messaging_api.FlexBubble {
+func (s *Server) getCardFlex(card Person) messaging_api.FlexBubble {
 	// Get URL encode for company name and address
 	companyEncode := url.QueryEscape(card.Company)
 	addressEncode := url.QueryEscape(card.Address)
 
-	return messaging_api.FlexBubble{
+	bubble := messaging_api.FlexBubble{
 		Size: messaging_api.FlexBubbleSIZE_GIGA,
 		Body: &messaging_api.FlexBox{
 			Layout:  messaging_api.FlexBoxLAYOUT_HORIZONTAL,
@@ -117,4 +150,82 @@ func getCardFlex(card Person) messaging_api.FlexBubble {
 			},
 		},
 	}
+
+	// 有保存名片照片時，以照片作為 hero 圖片取代預設的 logo
+	if card.ImageURL != "" {
+		bubble.Hero = &messaging_api.FlexImage{
+			AspectMode:  "cover",
+			AspectRatio: "20:13",
+			Size:        "full",
+			Url:         s.cardImageURL(card.ImageURL),
+			Action: &messaging_api.UriAction{
+				Uri: s.cardImageURL(card.OriginalImageURL),
+			},
+		}
+		bubble.Body.Contents = bubble.Body.Contents[1:]
+	}
+
+	// 顯示認識的活動、日期、標籤與備註
+	if text := contactContextText(card); text != "" {
+		info := bubble.Body.Contents[len(bubble.Body.Contents)-1].(*messaging_api.FlexBox)
+		info.Contents = append(info.Contents, &messaging_api.FlexText{
+			Align:  "end",
+			Margin: "xxl",
+			Size:   "sm",
+			Color:  "#888888",
+			Wrap:   true,
+			Text:   text,
+		})
+	}
+
+	// 已存在資料庫的名片才能補充備註與會面日期
+	if card.PageID != "" {
+		bubble.Footer = &messaging_api.FlexBox{
+			Layout:  messaging_api.FlexBoxLAYOUT_HORIZONTAL,
+			Spacing: "sm",
+			Contents: []messaging_api.FlexComponentInterface{
+				&messaging_api.FlexButton{
+					Style:  messaging_api.FlexButtonSTYLE_LINK,
+					Height: messaging_api.FlexButtonHEIGHT_SM,
+					Action: &messaging_api.PostbackAction{
+						Label:       "新增備註",
+						Data:        "action=note&page=" + card.PageID,
+						DisplayText: "新增備註",
+					},
+				},
+				&messaging_api.FlexButton{
+					Style:  messaging_api.FlexButtonSTYLE_LINK,
+					Height: messaging_api.FlexButtonHEIGHT_SM,
+					Action: &messaging_api.DatetimePickerAction{
+						Label: "會面日期",
+						Data:  "action=metdate&page=" + card.PageID,
+						Mode:  messaging_api.DatetimePickerActionMODE_DATE,
+					},
+				},
+			},
+		}
+		if s.Chat != nil && card.Email != "" && card.Email != "N/A" {
+			bubble.Footer.Contents = append(bubble.Footer.Contents, &messaging_api.FlexButton{
+				Style:  messaging_api.FlexButtonSTYLE_LINK,
+				Height: messaging_api.FlexButtonHEIGHT_SM,
+				Action: &messaging_api.PostbackAction{
+					Label:       "寫信",
+					Data:        "action=email&page=" + card.PageID,
+					DisplayText: "寫追蹤信",
+				},
+			})
+		}
+		if s.Reminders != nil {
+			bubble.Footer.Contents = append(bubble.Footer.Contents, &messaging_api.FlexButton{
+				Style:  messaging_api.FlexButtonSTYLE_LINK,
+				Height: messaging_api.FlexButtonHEIGHT_SM,
+				Action: &messaging_api.PostbackAction{
+					Label:       "一週後提醒",
+					Data:        "action=remind&page=" + card.PageID + "&after=1w",
+					DisplayText: "一週後提醒我聯絡",
+				},
+			})
+		}
+	}
+	return bubble
 }
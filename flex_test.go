@@ -0,0 +1,28 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+func TestCardMessagesLimit(t *testing.T) {
+	s := &Server{}
+	people := make([]Person, MaxCarouselBubbles+3)
+	messages := s.cardMessages(people, "找到 15 張名片")
+
+	text := messages[0].(*messaging_api.TextMessage).Text
+	if !strings.Contains(text, "只顯示前 12 張") {
+		t.Errorf("text = %q, want it to say only the first 12 cards are shown", text)
+	}
+	carousel := messages[1].(*messaging_api.FlexMessage).Contents.(*messaging_api.FlexCarousel)
+	if len(carousel.Contents) != MaxCarouselBubbles {
+		t.Errorf("carousel has %d bubbles, want %d", len(carousel.Contents), MaxCarouselBubbles)
+	}
+
+	messages = s.cardMessages(people[:2], "找到 2 張名片")
+	if text := messages[0].(*messaging_api.TextMessage).Text; text != "找到 2 張名片" {
+		t.Errorf("text = %q, want it unchanged", text)
+	}
+}
@@ -6,18 +6,28 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 
 	"github.com/google/generative-ai-go/genai"
-	"google.golang.org/api/option"
 )
 
-func GeminiImage(imgData []byte, prompt string) (string, error) {
-	ctx := context.Background()
-	client, err := genai.NewClient(ctx, option.WithAPIKey(geminiKey))
+// CardReader 辨識名片照片，回傳模型輸出的文字。
+type CardReader interface {
+	ReadCard(ctx context.Context, imgData []byte, prompt string) (string, error)
+}
+
+// GeminiCardReader 使用 Gemini vision 模型辨識名片。
+type GeminiCardReader struct {
+	APIKey string
+	Meter  *TokenMeter // 記錄 token 用量，nil 時不記錄
+}
+
+// ReadCard 將名片照片與提示交給 Gemini 辨識。
+func (g *GeminiCardReader) ReadCard(ctx context.Context, imgData []byte, prompt string) (string, error) {
+	client, err := newGeminiClient(ctx, g.APIKey, g.Meter)
 	if err != nil {
-		log.Fatal(err)
+		return "", fmt.Errorf("error creating Gemini client: %w", err)
 	}
 	defer client.Close()
 
@@ -28,52 +38,110 @@ func GeminiImage(imgData []byte, prompt string) (string, error) {
 		genai.ImageData("png", imgData),
 		genai.Text(prompt),
 	}
-	log.Println("Begin processing image...")
+	logger := loggerFrom(ctx)
+	logger.Debug("Begin processing image")
 	resp, err := model.GenerateContent(ctx, data...)
-	log.Println("Finished processing image...", resp)
 	if err != nil {
-		log.Fatal(err)
 		return "", err
 	}
+	logger.Debug("Finished processing image", "candidates", len(resp.Candidates))
+
+	return printResponse(resp)
+}
+
+// geminiModelsURL 是 Gemini 的模型列表 API，readiness 檢查用它確認 API key 可以使用而不產生費用。
+const geminiModelsURL = "https://generativelanguage.googleapis.com/v1beta/models"
+
+// Ping 列出一個模型，確認 Gemini API 可以連線且 API key 有效。
+func (g *GeminiCardReader) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, geminiModelsURL+"?pageSize=1", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("x-goog-api-key", g.APIKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("gemini models: %s", resp.Status)
+	}
+	return nil
+}
 
-	return printResponse(resp), nil
+func GeminiImage(apiKey string, imgData []byte, prompt string) (string, error) {
+	return (&GeminiCardReader{APIKey: apiKey}).ReadCard(context.Background(), imgData, prompt)
 }
 
 // Gemini Chat Complete: Iput a prompt and get the response string.
-func GeminiChatComplete(req string) string {
-	ctx := context.Background()
-	client, err := genai.NewClient(ctx, option.WithAPIKey(geminiKey))
+func GeminiChatComplete(apiKey, req string) string {
+	res, _, err := (&GeminiChatModel{APIKey: apiKey}).Chat(context.Background(), nil, req)
 	if err != nil {
-		log.Fatal(err)
+		slog.Error("Error chatting with Gemini", "err", err)
+		return ""
+	}
+	return res
+}
+
+// ChatModel 是可以多輪對話的語言模型。
+type ChatModel interface {
+	// Chat 以過去的對話紀錄加上新訊息取得回覆，並回傳包含這次對話的新紀錄。
+	Chat(ctx context.Context, history []*genai.Content, msg string) (string, []*genai.Content, error)
+}
+
+// GeminiChatModel 使用 Gemini 進行多輪對話。
+type GeminiChatModel struct {
+	APIKey string
+	Meter  *TokenMeter // 記錄 token 用量，nil 時不記錄
+}
+
+// Chat 以 Gemini chat session 送出訊息，失敗時不會修改傳入的對話紀錄。
+func (g *GeminiChatModel) Chat(ctx context.Context, history []*genai.Content, msg string) (string, []*genai.Content, error) {
+	client, err := newGeminiClient(ctx, g.APIKey, g.Meter)
+	if err != nil {
+		return "", history, err
 	}
 	defer client.Close()
 	model := client.GenerativeModel("gemini-pro")
 	value := float32(0.8)
 	model.Temperature = &value
 	cs := model.StartChat()
+	cs.History = append([]*genai.Content(nil), history...)
 
-	send := func(msg string) *genai.GenerateContentResponse {
-		fmt.Printf("== Me: %s\n== Model:\n", msg)
-		res, err := cs.SendMessage(ctx, genai.Text(msg))
-		if err != nil {
-			log.Fatal(err)
-		}
-		return res
+	loggerFrom(ctx).Debug("Sending chat message", "turns", len(history))
+	res, err := cs.SendMessage(ctx, genai.Text(msg))
+	if err != nil {
+		return "", history, err
 	}
-
-	res := send(req)
-	return printResponse(res)
+	text, err := printResponse(res)
+	if err != nil {
+		return "", history, err
+	}
+	return text, cs.History, nil
 }
 
-func printResponse(resp *genai.GenerateContentResponse) string {
+// printResponse 串接所有候選回應的內容。被安全過濾等原因擋下的候選沒有內容，會被略過；
+// 沒有任何可用的內容時回傳錯誤，並附上第一個候選的結束原因。
+func printResponse(resp *genai.GenerateContentResponse) (string, error) {
 	var ret string
+	found := false
 	for _, cand := range resp.Candidates {
+		if cand == nil || cand.Content == nil {
+			continue
+		}
 		for _, part := range cand.Content.Parts {
 			ret = ret + fmt.Sprintf("%v", part)
-			fmt.Println(part)
+			found = true
+		}
+	}
+	if !found {
+		if len(resp.Candidates) > 0 && resp.Candidates[0] != nil {
+			return "", fmt.Errorf("no content in Gemini response (finish reason: %v)", resp.Candidates[0].FinishReason)
 		}
+		return "", fmt.Errorf("no candidates in Gemini response")
 	}
-	return ret
+	return ret, nil
 }
 
 const api_url = "https://generativelanguage.googleapis.com/v1beta/models/gemini-pro:generateContent"
@@ -127,7 +195,7 @@ func newGenerateContentRequest(text string) GenerateContentRequest {
 	return request
 }
 
-func generateContent(contentRequest GenerateContentRequest) error {
+func generateContent(apiKey string, contentRequest GenerateContentRequest) error {
 	jsonData, err := json.Marshal(contentRequest)
 	if err != nil {
 		return fmt.Errorf("error marshalling request data: %w", err)
@@ -139,7 +207,7 @@ func generateContent(contentRequest GenerateContentRequest) error {
 	}
 
 	query := req.URL.Query()
-	query.Add("key", geminiKey)
+	query.Add("key", apiKey)
 	req.URL.RawQuery = query.Encode()
 
 	req.Header.Set("Content-Type", "application/json")
@@ -156,8 +224,7 @@ func generateContent(contentRequest GenerateContentRequest) error {
 		return fmt.Errorf("error reading response body: %w", err)
 	}
 
-	fmt.Println("Response status:", resp.Status)
-	fmt.Println("Response body:", string(body))
+	slog.Debug("Gemini response", "status", resp.Status, "body", string(body))
 	return nil
 }
 
@@ -181,10 +248,7 @@ type ResponseData []struct {
 			Probability string `json:"probability"`
 		} `json:"safetyRatings"`
 	} `json:"candidates"`
-	UsageMetadata struct {
-		PromptTokenCount int `json:"promptTokenCount"`
-		TotalTokenCount  int `json:"totalTokenCount"`
-	} `json:"usageMetadata"`
+	UsageMetadata UsageMetadata `json:"usageMetadata"`
 }
 
 func processResponseData(jsonData []byte) (ResponseData, error) {
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+func TestPrintResponseSkipsEmptyCandidates(t *testing.T) {
+	resp := &genai.GenerateContentResponse{Candidates: []*genai.Candidate{
+		{FinishReason: genai.FinishReasonSafety},
+		{Content: &genai.Content{Parts: []genai.Part{genai.Text("王小明")}}},
+	}}
+	if text, err := printResponse(resp); err != nil || text != "王小明" {
+		t.Errorf("printResponse() = %q, %v, want 王小明", text, err)
+	}
+
+	// 所有候選都被擋下時回傳錯誤，而不是 panic 或空字串
+	resp = &genai.GenerateContentResponse{Candidates: []*genai.Candidate{{FinishReason: genai.FinishReasonSafety}}}
+	if _, err := printResponse(resp); err == nil || !strings.Contains(err.Error(), "Safety") {
+		t.Errorf("printResponse() error = %v, want the safety finish reason", err)
+	}
+	if _, err := printResponse(&genai.GenerateContentResponse{}); err == nil {
+		t.Error("printResponse() without candidates succeeded, want error")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ReadinessCacheTTL 是 readiness 檢查結果的快取時間，監控頻繁呼叫 /readyz 時不會每次都打外部服務。
+const ReadinessCacheTTL = 30 * time.Second
+
+// ReadinessProbeTimeout 是每個 readiness 檢查的逾時。
+const ReadinessProbeTimeout = 5 * time.Second
+
+// Probe 檢查一個外部服務是否可以連線。
+type Probe struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// Pinger 是可以用低成本請求確認連線的服務。
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Readiness 執行 readiness probe 並快取結果。
+type Readiness struct {
+	Probes []Probe
+	TTL    time.Duration
+
+	mu      sync.Mutex
+	checked time.Time
+	results map[string]error
+	now     func() time.Time
+}
+
+// Check 回傳每個 probe 的結果，快取過期前直接回傳上次的結果。
+func (r *Readiness) Check(ctx context.Context) map[string]error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now
+	if r.now != nil {
+		now = r.now
+	}
+	if r.results != nil && now().Sub(r.checked) < r.TTL {
+		return r.results
+	}
+
+	results := make(map[string]error, len(r.Probes))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, p := range r.Probes {
+		wg.Add(1)
+		go func(p Probe) {
+			defer wg.Done()
+			err := runProbe(ctx, p)
+			mu.Lock()
+			results[p.Name] = err
+			mu.Unlock()
+		}(p)
+	}
+	wg.Wait()
+
+	r.results = results
+	r.checked = now()
+	return results
+}
+
+// runProbe 執行 p，超過 ReadinessProbeTimeout 仍未完成時視為失敗。
+func runProbe(ctx context.Context, p Probe) error {
+	ctx, cancel := context.WithTimeout(ctx, ReadinessProbeTimeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- p.Check(ctx) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// readinessProbes 回傳 LINE、Gemini 與 Notion 名片簿的連線檢查。
+func (s *Server) readinessProbes() []Probe {
+	probes := []Probe{
+		{Name: "line", Check: func(ctx context.Context) error {
+			// WithContext 會修改共用的 client，所以這裡不傳入 ctx，逾時由 runProbe 處理
+			_, err := s.Bot.GetBotInfo()
+			return err
+		}},
+		{Name: "notion", Check: func(ctx context.Context) error {
+			return s.newNotionDB(ctx, "").Ping(ctx)
+		}},
+	}
+	if p, ok := s.Cards.(Pinger); ok {
+		probes = append(probes, Probe{Name: "gemini", Check: p.Ping})
+	}
+	return probes
+}
+
+// healthzHandler 只要程序在執行就回傳 200。
+func (s *Server) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// readyzHandler 回傳外部服務的連線狀態，有任何一個無法連線時回傳 503。
+// 回應只有 ok 或 unavailable，錯誤內容可能包含內部網址或 API 回應，只寫在 log。
+func (s *Server) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	results := s.Ready.Check(r.Context())
+
+	status := http.StatusOK
+	resp := struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}{Status: "ok", Checks: make(map[string]string, len(results))}
+	for name, err := range results {
+		if err != nil {
+			status = http.StatusServiceUnavailable
+			resp.Status = "unavailable"
+			resp.Checks[name] = "unavailable"
+			loggerFrom(r.Context()).Warn("Readiness check failed", "check", name, "err", err)
+			continue
+		}
+		resp.Checks[name] = "ok"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
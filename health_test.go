@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthz(t *testing.T) {
+	s := &Server{Config: DefaultConfig()}
+	rec := httptest.NewRecorder()
+	s.healthzHandler(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200", rec.Code)
+	}
+}
+
+// getReadyz 呼叫 /readyz，回傳狀態碼與每個檢查的結果。
+func getReadyz(t *testing.T, s *Server) (int, map[string]string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.readyzHandler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var body struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("body = %s: %v", rec.Body, err)
+	}
+	return rec.Code, body.Checks
+}
+
+func TestReadyzProbesLINEAndNotion(t *testing.T) {
+	h := newWebhookHarness(t)
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	h.Server.Ready.now = func() time.Time { return now }
+
+	status, checks := getReadyz(t, h.Server)
+	if status != http.StatusOK || checks["line"] != "ok" || checks["notion"] != "ok" {
+		t.Fatalf("readyz = %d %v, want 200 with line and notion ok", status, checks)
+	}
+	if calls := h.Calls(); len(calls) != 1 || calls[0].Path != "/v2/bot/info" {
+		t.Errorf("LINE calls = %s, want one bot info request", formatCalls(calls))
+	}
+	if n := h.Notion.Queries(); n != 1 {
+		t.Errorf("notion queries = %d, want 1", n)
+	}
+
+	// Notion token 失效後，快取過期前仍回傳上次的結果
+	h.Server.Config.NotionToken = "secret_revoked"
+	if status, _ := getReadyz(t, h.Server); status != http.StatusOK {
+		t.Errorf("cached readyz = %d, want 200", status)
+	}
+	if calls := h.Calls(); len(calls) != 1 {
+		t.Errorf("LINE calls = %s, want the cached result", formatCalls(calls))
+	}
+
+	now = now.Add(ReadinessCacheTTL)
+	status, checks = getReadyz(t, h.Server)
+	if status != http.StatusServiceUnavailable || checks["line"] != "ok" || checks["notion"] != "unavailable" {
+		t.Errorf("readyz = %d %v, want 503 with notion unavailable and no error details", status, checks)
+	}
+}
+
+func TestReadinessCheckRunsEveryProbe(t *testing.T) {
+	r := &Readiness{TTL: time.Minute, Probes: []Probe{
+		{Name: "ok", Check: func(context.Context) error { return nil }},
+		{Name: "down", Check: func(context.Context) error { return errors.New("connection refused") }},
+	}}
+	results := r.Check(context.Background())
+	if len(results) != 2 || results["ok"] != nil || results["down"] == nil {
+		t.Errorf("results = %v", results)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// IdempotencyStore 記錄已經處理過的 webhook 事件 (webhookEventId)。LINE 在 webhook 逾時後會重送同一個事件，
+// 處理前先 Claim，已經處理過（或正在處理）的事件不會再新增一次名片。
+type IdempotencyStore interface {
+	// Claim 在 key 尚未記錄或已經過期時將 key 記錄為處理中並回傳 true，已經記錄過時回傳 false。
+	Claim(key string, now time.Time) (bool, error)
+	// Complete 將處理中的 key 記錄為已處理。
+	Complete(key string, now time.Time) error
+	// Release 移除處理失敗的 key，重送的事件會再處理一次。
+	Release(key string) error
+}
+
+// idempotencyEntry 是一個事件的處理狀態，Done 為 false 時表示正在處理。
+type idempotencyEntry struct {
+	Expires time.Time `json:"expires"`
+	Done    bool      `json:"done,omitempty"`
+}
+
+// UnmarshalJSON 也接受舊版只記錄過期時間的格式，舊的紀錄都是已處理的事件。
+func (e *idempotencyEntry) UnmarshalJSON(data []byte) error {
+	var expires time.Time
+	if err := json.Unmarshal(data, &expires); err == nil {
+		*e = idempotencyEntry{Expires: expires, Done: true}
+		return nil
+	}
+	type entry idempotencyEntry
+	return json.Unmarshal(data, (*entry)(e))
+}
+
+// MemoryIdempotencyStore 是存在記憶體的 IdempotencyStore，重新啟動後會清空。
+type MemoryIdempotencyStore struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	items map[string]idempotencyEntry
+}
+
+// NewMemoryIdempotencyStore 建立記憶體中的 IdempotencyStore，每個事件在 ttl 之後過期。
+func NewMemoryIdempotencyStore(ttl time.Duration) *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{ttl: ttl, items: make(map[string]idempotencyEntry)}
+}
+
+// Claim 記錄事件，同時清除已過期的紀錄。
+func (s *MemoryIdempotencyStore) Claim(key string, now time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.claim(key, now), nil
+}
+
+// Complete 將事件記錄為已處理，從完成的時間起保留 ttl。
+func (s *MemoryIdempotencyStore) Complete(key string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.complete(key, now)
+	return nil
+}
+
+// Release 移除事件的紀錄。
+func (s *MemoryIdempotencyStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.items, key)
+	return nil
+}
+
+// claim 是 Claim 的實作，呼叫前需持有鎖。
+func (s *MemoryIdempotencyStore) claim(key string, now time.Time) bool {
+	for k, e := range s.items {
+		if !now.Before(e.Expires) {
+			delete(s.items, k)
+		}
+	}
+	if _, ok := s.items[key]; ok {
+		return false
+	}
+	s.items[key] = idempotencyEntry{Expires: now.Add(s.ttl)}
+	return true
+}
+
+// complete 是 Complete 的實作，呼叫前需持有鎖。
+func (s *MemoryIdempotencyStore) complete(key string, now time.Time) {
+	s.items[key] = idempotencyEntry{Expires: now.Add(s.ttl), Done: true}
+}
+
+// FileIdempotencyStore 是存放於本地檔案的 IdempotencyStore，重新啟動後仍能辨識重送的事件。
+type FileIdempotencyStore struct {
+	MemoryIdempotencyStore
+	path string
+}
+
+// NewFileIdempotencyStore 建立存放在 path 的 IdempotencyStore，並從檔案載入尚未過期的紀錄。
+// 上次執行時還在處理中的事件沒有完成，不會載入，LINE 重送時會再處理一次。
+func NewFileIdempotencyStore(path string, ttl time.Duration) (*FileIdempotencyStore, error) {
+	s := &FileIdempotencyStore{
+		MemoryIdempotencyStore: MemoryIdempotencyStore{ttl: ttl, items: make(map[string]idempotencyEntry)},
+		path:                   path,
+	}
+	if err := loadJSONFile(path, &s.items); err != nil {
+		return nil, fmt.Errorf("error loading webhook events: %w", err)
+	}
+	for k, e := range s.items {
+		if !e.Done {
+			delete(s.items, k)
+		}
+	}
+	return s, nil
+}
+
+// Claim 記錄事件並寫入檔案。寫入失敗時仍回傳是否為新的事件，讓 webhook 照常處理。
+func (s *FileIdempotencyStore) Claim(key string, now time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.claim(key, now) {
+		return false, nil
+	}
+	return true, saveJSONFile(s.path, s.items)
+}
+
+// Complete 將事件記錄為已處理並寫入檔案。
+func (s *FileIdempotencyStore) Complete(key string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.complete(key, now)
+	return saveJSONFile(s.path, s.items)
+}
+
+// Release 移除事件的紀錄並寫入檔案。
+func (s *FileIdempotencyStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.items, key)
+	return saveJSONFile(s.path, s.items)
+}
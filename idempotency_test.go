@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	s := NewMemoryIdempotencyStore(time.Hour)
+	now := time.Date(2026, 5, 1, 9, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		key  string
+		at   time.Time
+		want bool
+	}{
+		{"e1", now, true},
+		{"e1", now.Add(time.Minute), false},
+		{"e2", now.Add(time.Minute), true},
+		{"e1", now.Add(59 * time.Minute), false},
+		// 過期之後同一個 ID 視為新的事件
+		{"e1", now.Add(time.Hour), true},
+	} {
+		got, err := s.Claim(tc.key, tc.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("Claim(%q, +%v) = %v, want %v", tc.key, tc.at.Sub(now), got, tc.want)
+		}
+	}
+}
+
+func TestFileIdempotencyStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.json")
+	now := time.Date(2026, 5, 1, 9, 0, 0, 0, time.UTC)
+
+	s, err := NewFileIdempotencyStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := s.Claim("e1", now); !ok || err != nil {
+		t.Fatalf("Claim = %v, %v", ok, err)
+	}
+	if err := s.Complete("e1", now); err != nil {
+		t.Fatal(err)
+	}
+	// e2 在重新啟動前還沒處理完
+	if ok, err := s.Claim("e2", now); !ok || err != nil {
+		t.Fatalf("Claim = %v, %v", ok, err)
+	}
+
+	// 重新啟動後仍記得已處理的事件，沒處理完的事件可以再處理一次
+	s, err = NewFileIdempotencyStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := s.Claim("e1", now.Add(time.Minute)); ok {
+		t.Error("event claimed again after reopening the store")
+	}
+	if ok, _ := s.Claim("e2", now.Add(time.Minute)); !ok {
+		t.Error("unfinished event was not claimed after reopening the store")
+	}
+	if ok, _ := s.Claim("e1", now.Add(2*time.Hour)); !ok {
+		t.Error("expired event was not claimed")
+	}
+}
+
+func TestFileIdempotencyStoreLoadsLegacyFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.json")
+	if err := os.WriteFile(path, []byte(`{"e1":"2026-05-01T10:00:00Z"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewFileIdempotencyStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 5, 1, 9, 0, 0, 0, time.UTC)
+	if ok, _ := s.Claim("e1", now); ok {
+		t.Error("event from the old format was claimed again")
+	}
+}
+
+func TestMemoryIdempotencyStoreRelease(t *testing.T) {
+	s := NewMemoryIdempotencyStore(time.Hour)
+	now := time.Date(2026, 5, 1, 9, 0, 0, 0, time.UTC)
+
+	if ok, _ := s.Claim("e1", now); !ok {
+		t.Fatal("Claim = false")
+	}
+	// 處理失敗時移除紀錄，重送的事件可以再處理
+	if err := s.Release("e1"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := s.Claim("e1", now.Add(time.Minute)); !ok {
+		t.Error("released event was not claimed")
+	}
+	// 處理完成後從完成的時間起保留 ttl
+	if err := s.Complete("e1", now.Add(30*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := s.Claim("e1", now.Add(80*time.Minute)); ok {
+		t.Error("completed event was claimed before its ttl")
+	}
+}
+
+func TestWebhookSkipsRedeliveredEvent(t *testing.T) {
+	h := newWebhookHarness(t)
+	h.SetCard("m1", "```json\n{\"name\":\"王小明\",\"email\":\"ming@bank.example\"}\n```")
+
+	event := h.Image(userSource("U1"), "m1")
+	if code := h.Post(event); code != 200 {
+		t.Fatalf("status = %d", code)
+	}
+	// LINE 沒收到 200 時會以同一個 webhookEventId 重送，仍回應 200 但不再處理
+	if code := h.Post(h.Redeliver(event)); code != 200 {
+		t.Fatalf("redelivery status = %d", code)
+	}
+
+	downloads := 0
+	for _, c := range h.Calls() {
+		if strings.HasSuffix(c.Path, "/content") {
+			downloads++
+		}
+	}
+	if downloads != 1 {
+		t.Errorf("image downloaded %d times, want 1", downloads)
+	}
+	if replies := h.Replies(); len(replies) != 1 {
+		t.Errorf("replies = %d, want 1: %v", len(replies), replies)
+	}
+	people, err := h.Notion.NotionDB("db", "U1").QueryDatabaseByUID(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(people) != 1 {
+		t.Errorf("contacts = %d, want 1", len(people))
+	}
+}
+
+// panicCardReader 第一次辨識時 panic，之後交給 CardReader 處理。
+type panicCardReader struct {
+	CardReader
+	panicked bool
+}
+
+func (p *panicCardReader) ReadCard(ctx context.Context, imgData []byte, prompt string) (string, error) {
+	if !p.panicked {
+		p.panicked = true
+		panic("card reader crashed")
+	}
+	return p.CardReader.ReadCard(ctx, imgData, prompt)
+}
+
+func TestWebhookReleasesFailedEvent(t *testing.T) {
+	h := newWebhookHarness(t)
+	h.SetCard("m1", "```json\n{\"name\":\"王小明\",\"email\":\"ming@bank.example\"}\n```")
+	h.Server.Cards = &panicCardReader{CardReader: h.Server.Cards}
+
+	event := h.Image(userSource("U1"), "m1")
+	h.Post(event)
+	// 處理失敗的事件在重送時會再處理一次
+	h.Post(h.Redeliver(event))
+
+	people, err := h.Notion.NotionDB("db", "U1").QueryDatabaseByUID(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(people) != 1 {
+		t.Errorf("contacts = %d, want 1", len(people))
+	}
+}
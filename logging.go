@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/webhook"
+)
+
+// Redactor 遮蔽 log 中的個資。Emails、Phones、Names 分別控制 email、電話與姓名是否遮蔽。
+// key 為 email、phone、name 的欄位整個遮蔽，其他文字中的 email、電話與 JSON 的 "name" 欄位也會遮蔽。
+type Redactor struct {
+	Emails bool
+	Phones bool
+	Names  bool
+}
+
+// ParseRedactor 解析 LOG_REDACT，例如 "email,phone,name"，"none" 表示不遮蔽。
+func ParseRedactor(spec string) (Redactor, error) {
+	var r Redactor
+	for _, field := range strings.Split(spec, ",") {
+		switch strings.TrimSpace(strings.ToLower(field)) {
+		case "", "none":
+		case "email":
+			r.Emails = true
+		case "phone":
+			r.Phones = true
+		case "name":
+			r.Names = true
+		default:
+			return r, fmt.Errorf("unknown redaction %q, want email, phone, name or none", field)
+		}
+	}
+	return r, nil
+}
+
+var (
+	emailPattern    = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	phonePattern    = regexp.MustCompile(`[+#]?\(?\d[\d\-\s().]{6,}\d`)
+	datePattern     = regexp.MustCompile(`^\d{4}[-/]\d{1,2}[-/]\d{1,2}`)
+	jsonNamePattern = regexp.MustCompile(`(?i)("name"\s*:\s*")([^"]*)(")`)
+)
+
+// ReplaceAttr 是 slog.HandlerOptions 的 ReplaceAttr，遮蔽每個欄位與訊息中的個資。
+func (r Redactor) ReplaceAttr(_ []string, a slog.Attr) slog.Attr {
+	if a.Value.Kind() != slog.KindString && a.Value.Kind() != slog.KindAny {
+		return a
+	}
+	var s string
+	if a.Value.Kind() == slog.KindString {
+		s = a.Value.String()
+	} else if err, ok := a.Value.Any().(error); ok {
+		s = err.Error()
+	} else {
+		return a
+	}
+
+	switch strings.ToLower(a.Key) {
+	case "email":
+		if r.Emails {
+			return slog.String(a.Key, maskEmail(s))
+		}
+	case "phone":
+		if r.Phones {
+			return slog.String(a.Key, maskPhone(s))
+		}
+	case "name":
+		if r.Names {
+			return slog.String(a.Key, maskName(s))
+		}
+	}
+	if redacted := r.String(s); redacted != s || a.Value.Kind() != slog.KindString {
+		return slog.String(a.Key, redacted)
+	}
+	return a
+}
+
+// String 遮蔽一段文字中的 email、電話與 JSON 的姓名欄位。
+func (r Redactor) String(s string) string {
+	if r.Emails {
+		s = emailPattern.ReplaceAllStringFunc(s, maskEmail)
+	}
+	if r.Phones {
+		s = phonePattern.ReplaceAllStringFunc(s, func(m string) string {
+			digits := countDigits(m)
+			// 日期、時間戳記與 ID 不是電話
+			if digits < 8 || digits > 15 || datePattern.MatchString(m) {
+				return m
+			}
+			return maskPhone(m)
+		})
+	}
+	if r.Names {
+		s = jsonNamePattern.ReplaceAllStringFunc(s, func(m string) string {
+			parts := jsonNamePattern.FindStringSubmatch(m)
+			return parts[1] + maskName(parts[2]) + parts[3]
+		})
+	}
+	return s
+}
+
+// maskEmail 只保留 email 帳號的第一個字與網域，例如 m***@bank.example。
+func maskEmail(email string) string {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok || local == "" {
+		return maskName(email)
+	}
+	r, _ := utf8.DecodeRuneInString(local)
+	return string(r) + "***@" + domain
+}
+
+// maskPhone 只保留電話的最後三碼，例如 ***789。
+func maskPhone(phone string) string {
+	var digits []rune
+	for _, r := range phone {
+		if r >= '0' && r <= '9' {
+			digits = append(digits, r)
+		}
+	}
+	if len(digits) <= 3 {
+		return "***"
+	}
+	return "***" + string(digits[len(digits)-3:])
+}
+
+// maskName 只保留姓名的第一個字，例如 王**。沒有資料的 N/A 不遮蔽。
+func maskName(name string) string {
+	if name == "" || name == "N/A" {
+		return name
+	}
+	r, _ := utf8.DecodeRuneInString(name)
+	return string(r) + "**"
+}
+
+func countDigits(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			n++
+		}
+	}
+	return n
+}
+
+// LogValue 讓 slog 以個別欄位記錄名片，姓名、email 與電話會依照 LOG_REDACT 遮蔽。
+func (p Person) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("page_id", p.PageID),
+		slog.String("name", p.Name),
+		slog.String("company", p.Company),
+		slog.String("email", p.Email),
+		slog.String("phone", p.Phone),
+	)
+}
+
+// NewLogger 依照 LOG_LEVEL、LOG_FORMAT 與 LOG_REDACT 建立寫到 w 的 logger。
+func (c *Config) NewLogger(w io.Writer) (*slog.Logger, error) {
+	var errs []error
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		errs = append(errs, fmt.Errorf("LOG_LEVEL: %w", err))
+	}
+	redactor, err := ParseRedactor(c.LogRedact)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("LOG_REDACT: %w", err))
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		errs = append(errs, fmt.Errorf("LOG_FORMAT must be text or json, got %q", c.LogFormat))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: redactor.ReplaceAttr}
+	if c.LogFormat == "json" {
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return slog.New(slog.NewTextHandler(w, opts)), nil
+}
+
+type loggerKey struct{}
+
+// withLogger 回傳帶有 logger 的 ctx，之後的處理可以用 loggerFrom 取得同一個事件的 logger。
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// loggerFrom 回傳 ctx 中的 logger，沒有時回傳預設的 logger。
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// webhookEvent 是事件共用的欄位。
+type webhookEvent struct {
+	ID         string // webhookEventId
+	ReplyToken string // 沒有 reply token 的事件為空字串
+	Redelivery bool   // LINE 在 webhook 逾時或失敗後重送的事件
+	UserID     string // 傳送事件的用戶，群組中為個別成員
+	Session    string // 傳送者的對話狀態 key（見 sessionKey）
+}
+
+// eventInfo 回傳事件的 webhookEventId、reply token、是否為重送的事件與傳送事件的用戶。
+func eventInfo(event webhook.EventInterface) webhookEvent {
+	info := func(id, replyToken string, dc *webhook.DeliveryContext, source webhook.SourceInterface) webhookEvent {
+		return webhookEvent{ID: id, ReplyToken: replyToken, Redelivery: dc != nil && dc.IsRedelivery, UserID: getUserID(source), Session: sessionKey(source)}
+	}
+	switch e := event.(type) {
+	case webhook.MessageEvent:
+		return info(e.WebhookEventId, e.ReplyToken, e.DeliveryContext, e.Source)
+	case webhook.PostbackEvent:
+		return info(e.WebhookEventId, e.ReplyToken, e.DeliveryContext, e.Source)
+	case webhook.FollowEvent:
+		return info(e.WebhookEventId, e.ReplyToken, e.DeliveryContext, e.Source)
+	case webhook.JoinEvent:
+		return info(e.WebhookEventId, e.ReplyToken, e.DeliveryContext, e.Source)
+	case webhook.BeaconEvent:
+		return info(e.WebhookEventId, e.ReplyToken, e.DeliveryContext, e.Source)
+	case webhook.UnfollowEvent:
+		return info(e.WebhookEventId, "", e.DeliveryContext, e.Source)
+	case webhook.LeaveEvent:
+		return info(e.WebhookEventId, "", e.DeliveryContext, e.Source)
+	}
+	return webhookEvent{}
+}
+
+// beginEvent 建立帶有事件 ID (webhookEventId) 的 logger，回傳以 ctx 為基礎、包含 logger 的 ctx。
+// 處理事件的函式都接收這個 ctx，辨識、儲存與回覆的 log 可以串起來。
+func beginEvent(ctx context.Context, event webhook.EventInterface) context.Context {
+	info := eventInfo(event)
+	eventType, messageType := webhookEventType(event)
+	logger := slog.Default().With("event_id", info.ID, "event", eventType)
+	if messageType != "" {
+		logger = logger.With("message", messageType)
+	}
+	if info.Redelivery {
+		logger = logger.With("redelivery", true)
+	}
+	return withLogger(ctx, logger)
+}
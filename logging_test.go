@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRedactorString(t *testing.T) {
+	r := Redactor{Emails: true, Phones: true, Names: true}
+	for _, tt := range []struct{ in, want string }{
+		{"mail ming@bank.example now", "mail m***@bank.example now"},
+		{"call #886-0912-345-678", "call ***678"},
+		{"call 02 2345 6789", "call ***789"},
+		{`{"Name": "王小明", "Title": "經理"}`, `{"Name": "王**", "Title": "經理"}`},
+		// 日期與短數字不是電話
+		{"met on 2026-06-01, 3 cards", "met on 2026-06-01, 3 cards"},
+	} {
+		if got := r.String(tt.in); got != tt.want {
+			t.Errorf("String(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	if got := (Redactor{}).String("ming@bank.example"); got != "ming@bank.example" {
+		t.Errorf("String() without redaction = %q", got)
+	}
+}
+
+func TestParseRedactor(t *testing.T) {
+	r, err := ParseRedactor("email, phone")
+	if err != nil || r != (Redactor{Emails: true, Phones: true}) {
+		t.Errorf("ParseRedactor() = %+v, %v", r, err)
+	}
+	if r, err := ParseRedactor("none"); err != nil || r != (Redactor{}) {
+		t.Errorf("ParseRedactor(none) = %+v, %v", r, err)
+	}
+	if _, err := ParseRedactor("address"); err == nil {
+		t.Error("ParseRedactor(address) = nil error")
+	}
+}
+
+// logLines 解析 JSON 格式的 log。
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var lines []map[string]any
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		var line map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("log line %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestNewLoggerRedactsPII(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.LogFormat = "json"
+	var buf bytes.Buffer
+	logger, err := cfg.NewLogger(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := Person{PageID: "p1", Name: "王小明", Company: "台灣銀行", Email: "ming@bank.example", Phone: "#886-0912-345-678"}
+	logger.Info("Page added successfully", "contact", p)
+	logger.Error("Error creating page", "err", errors.New("duplicate ming@bank.example"))
+	logger.Debug("Got card reader result", "result", `{"name":"王小明"}`)
+
+	out := buf.String()
+	for _, pii := range []string{"王小明", "ming@bank.example", "0912-345-678"} {
+		if strings.Contains(out, pii) {
+			t.Errorf("log leaks %q:\n%s", pii, out)
+		}
+	}
+	lines := logLines(t, &buf)
+	if len(lines) != 2 {
+		t.Fatalf("lines = %v, want the debug line filtered", lines)
+	}
+	contact := lines[0]["contact"].(map[string]any)
+	if contact["name"] != "王**" || contact["email"] != "m***@bank.example" || contact["phone"] != "***678" || contact["company"] != "台灣銀行" {
+		t.Errorf("contact = %v", contact)
+	}
+
+	cfg.LogRedact = "none"
+	buf.Reset()
+	logger, _ = cfg.NewLogger(&buf)
+	logger.Info("Page added successfully", "contact", p)
+	if !strings.Contains(buf.String(), "ming@bank.example") {
+		t.Errorf("LOG_REDACT=none still redacts: %s", buf.String())
+	}
+}
+
+func TestWebhookLogCorrelation(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.LogLevel, cfg.LogFormat = "debug", "json"
+	var buf bytes.Buffer
+	logger, err := cfg.NewLogger(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := slog.Default()
+	slog.SetDefault(logger)
+	defer slog.SetDefault(saved)
+
+	h := newWebhookHarness(t)
+	h.SetCard("m1", "```json\n{\"name\":\"王小明\",\"email\":\"ming@bank.example\"}\n```")
+	h.Post(h.Image(userSource("U1"), "m1"), h.Text(userSource("U1"), "hi"))
+
+	// 辨識、儲存與回覆的 log 都帶有圖片事件的 ID
+	byMsg := make(map[string]map[string]any)
+	for _, line := range logLines(t, &buf) {
+		if _, ok := byMsg[line["msg"].(string)]; !ok {
+			byMsg[line["msg"].(string)] = line
+		}
+	}
+	for _, msg := range []string{"Got image message", "Got card reader result", "Page added successfully", "Replied with cards"} {
+		line, ok := byMsg[msg]
+		if !ok {
+			t.Errorf("missing log %q", msg)
+			continue
+		}
+		if line["event_id"] != "01TESTEVENT0001" {
+			t.Errorf("%q event_id = %v, want the image event", msg, line["event_id"])
+		}
+	}
+	if line := byMsg["Got text message"]; line == nil || line["event_id"] != "01TESTEVENT0002" {
+		t.Errorf("text event log = %v", line)
+	}
+	if strings.Contains(buf.String(), "王小明") || strings.Contains(buf.String(), "ming@bank.example") {
+		t.Errorf("log leaks PII:\n%s", buf.String())
+	}
+}
@@ -13,40 +13,204 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
-	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+	"go.opentelemetry.io/otel"
 )
 
-var bucketName string
-var geminiKey string
-var channelToken string
-var ChannelSecret string
+func main() {
+	// 子指令：richmenu 建立並上傳圖文選單，rotate-keys 以目前的主金鑰重新加密所有名片，沒有子指令時啟動 bot。
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
 
-var bot *messaging_api.MessagingApiAPI
-var blob *messaging_api.MessagingApiBlobAPI
+	// richmenu 的參數由子指令自己解析，設定只從設定檔與環境變數載入
+	configArgs := args
+	if command == "richmenu" {
+		configArgs = nil
+	}
+	cfg, err := LoadConfig(configArgs, os.Getenv)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := cfg.Validate(command); err != nil {
+		log.Fatalf("invalid configuration:\n%v", err)
+	}
 
-func main() {
-	var err error
-	geminiKey = os.Getenv("GOOGLE_GEMINI_API_KEY")
-	channelToken = os.Getenv("ChannelAccessToken")
-	ChannelSecret = os.Getenv("ChannelSecret")
+	// 之後的 log（包含 log 套件）都以 slog 輸出，並依照 LOG_REDACT 遮蔽個資
+	logger, err := cfg.NewLogger(os.Stderr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.SetDefault(logger)
+	for _, line := range strings.Split(strings.TrimSpace(cfg.Summary()), "\n") {
+		slog.Info("Configuration", "setting", line)
+	}
 
-	bot, err = messaging_api.NewMessagingApiAPI(channelToken)
+	s, err := NewServer(cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	blob, err = messaging_api.NewMessagingApiBlobAPI(channelToken)
+	if command == "richmenu" {
+		if err := s.runRichMenuCommand(args); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// 個資欄位加密：PII_ENCRYPTION_KEYS 為 "id:base64" 以逗號分隔的主金鑰，PII_ACTIVE_KEY 是加密新資料的金鑰，
+	// PII_INDEX_KEY 是 blind index 的 HMAC 金鑰 (base64)。
+	s.Cipher, err = cfg.FieldCipher()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	http.HandleFunc("/callback", callbackHandler)
-	port := os.Getenv("PORT")
-	addr := fmt.Sprintf(":%s", port)
-	http.ListenAndServe(addr, nil)
+	// 設定 Gemini API Key 時啟用語意搜尋、語音備註與追蹤信，向量索引存放在本地檔案。
+	if cfg.GeminiAPIKey != "" {
+		s.Semantic, err = NewSemanticIndex(&GeminiEmbedder{APIKey: cfg.GeminiAPIKey, Meter: s.Tokens}, cfg.VectorIndexPath, s.Cipher)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.Semantic.Gemini = s.Gemini
+
+		// 語音備註預設使用 Gemini 轉錄
+		s.Transcriber = &GeminiTranscriber{APIKey: cfg.GeminiAPIKey, Meter: s.Tokens}
+
+		// 追蹤信使用 Gemini 多輪對話撰寫
+		s.Chat = &GeminiChatModel{APIKey: cfg.GeminiAPIKey, Meter: s.Tokens}
+	}
+
+	if command == "rotate-keys" {
+		if err := s.runRotateKeysCommand(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// 收到 SIGTERM 或 Ctrl-C 時停止排程並關閉伺服器
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	mux := http.NewServeMux()
+
+	// 設定 OTEL_EXPORTER_OTLP_ENDPOINT 時以 OTLP 匯出名片處理各階段的 trace
+	tp, err := cfg.NewTracerProvider(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if tp != nil {
+		otel.SetTracerProvider(tp)
+		s.Tracer = tp.Tracer(tracerName)
+	}
+
+	// 名片照片儲存：BLOB_STORE=local 存在本地磁碟，BLOB_STORE=s3 存在 S3 相容的物件儲存。
+	switch cfg.BlobStore {
+	case "local":
+		local := &LocalBlobStore{
+			Dir:     cfg.BlobLocalDir,
+			BaseURL: strings.TrimSuffix(cfg.PublicBaseURL, "/") + "/images",
+			Secret:  cfg.BlobSecret(),
+		}
+		mux.Handle("/images/", http.StripPrefix("/images", local))
+		s.Images = local
+	case "s3":
+		s.Images = &S3BlobStore{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+			PublicURL: cfg.S3PublicURL,
+		}
+	}
+
+	// 公司名稱正規化：可以用 COMPANY_ALIASES_PATH 增加別名，COMPANY_REGISTRY 設定公司登記資料來源。
+	var registry CompanyRegistry
+	switch cfg.CompanyRegistry {
+	case "gcis":
+		registry = &GCISCompanyRegistry{}
+	case "fixture":
+		registry, err = NewFixtureCompanyRegistry(cfg.CompanyRegistryFixture)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	s.Companies = NewCompanyDirectory(registry)
+	if cfg.CompanyAliasesPath != "" {
+		if err := s.Companies.LoadAliases(cfg.CompanyAliasesPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// 提醒存放在本地檔案，並由背景的排程器送出到期的提醒。
+	s.Reminders, err = NewReminderStore(cfg.ReminderStorePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s.Go(func() { s.runReminderScheduler(ctx, ReminderCheckInterval) })
+
+	// 新好友的資料保存同意紀錄存放在本地檔案
+	s.Consents, err = NewConsentStore(cfg.ConsentStorePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// 封鎖 bot 或「刪除我的資料」的刪除排程與稽核紀錄存放在本地檔案
+	s.Purges, err = NewPurgeQueue(cfg.PurgeStorePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s.Audit = NewAuditLog(cfg.AuditLogPath)
+	s.Go(func() { s.runPurgeScheduler(ctx, PurgeCheckInterval) })
+
+	// Gemini 或 Notion 暫時無法使用時，名片排入本地的佇列，由背景的排程器稍後重新處理
+	s.CardJobs, err = NewCardQueue(cfg.CardQueuePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s.Go(func() { s.runCardQueue(ctx, CardQueueCheckInterval) })
+
+	// 匯出檔的下載連結只在 ExportLinkTTL 內有效，到期後由背景的排程器刪除檔案
+	if s.Images != nil {
+		s.Exports, err = NewExportStore(cfg.ExportStorePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.Go(func() { s.runExportCleanup(ctx, ExportCheckInterval) })
+	}
+
+	mux.HandleFunc("/callback", s.callbackHandler)
+
+	// /healthz 只確認程序在執行，/readyz 檢查 LINE、Gemini 與 Notion 的連線，/metrics 提供 Prometheus 指標
+	mux.HandleFunc("/healthz", s.healthzHandler)
+	mux.HandleFunc("/readyz", s.readyzHandler)
+	mux.Handle("/metrics", s.Metrics.Handler())
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.Port))
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.Info("Listening", "addr", ln.Addr().String())
+	err = s.Serve(ctx, ln, mux)
+	if tp != nil {
+		// 送出尚未匯出的 span
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		if err := tp.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Error flushing traces", "err", err)
+		}
+		cancel()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.Info("Server stopped")
 }
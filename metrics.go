@@ -0,0 +1,233 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/webhook"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics 是 /metrics 提供給 Prometheus 的指標。nil 的 Metrics 不記錄任何資料，方便測試直接建立 Server。
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	webhookEvents      *prometheus.CounterVec
+	extractionDuration prometheus.Histogram
+	extractionFailures *prometheus.CounterVec
+	notionRequests     *prometheus.CounterVec
+	notionDuration     *prometheus.HistogramVec
+	duplicates         prometheus.Counter
+	duplicateEvents    prometheus.Counter
+	usageLimited       *prometheus.CounterVec
+	geminiTokens       *prometheus.CounterVec
+	geminiCost         *prometheus.CounterVec
+}
+
+// NewMetrics 建立指標並註冊到新的 registry，另外包含 Go runtime 與 process 的指標。
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		webhookEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "namecard_webhook_events_total",
+			Help: "LINE webhook events received, by event type and message type.",
+		}, []string{"type", "message"}),
+		extractionDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "namecard_extraction_duration_seconds",
+			Help:    "Time spent reading a business card image with the vision model.",
+			Buckets: []float64{0.5, 1, 2, 4, 8, 15, 30, 60},
+		}),
+		extractionFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "namecard_extraction_failures_total",
+			Help: "Business card extractions that failed, by stage (download, read, parse).",
+		}, []string{"stage"}),
+		notionRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "namecard_notion_requests_total",
+			Help: "Requests sent to the Notion API, by operation and HTTP status code.",
+		}, []string{"operation", "code"}),
+		notionDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "namecard_notion_request_duration_seconds",
+			Help:    "Latency of Notion API requests, by operation.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"operation"}),
+		duplicates: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "namecard_duplicates_detected_total",
+			Help: "Scanned cards that already existed in the address book.",
+		}),
+		duplicateEvents: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "namecard_webhook_duplicate_events_total",
+			Help: "Webhook events skipped because the same webhookEventId was already processed.",
+		}),
+		usageLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "namecard_usage_limited_total",
+			Help: "Scans and searches rejected by the per-user limiter, by kind and reason (rate, quota).",
+		}, []string{"kind", "reason"}),
+		geminiTokens: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "namecard_gemini_tokens_total",
+			Help: "Tokens used by Gemini generateContent calls, by model and type (prompt, candidates).",
+		}, []string{"model", "type"}),
+		geminiCost: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "namecard_gemini_cost_usd_total",
+			Help: "Estimated cost of Gemini calls in US dollars, by model.",
+		}, []string{"model"}),
+	}
+	m.Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.webhookEvents,
+		m.extractionDuration,
+		m.extractionFailures,
+		m.notionRequests,
+		m.notionDuration,
+		m.duplicates,
+		m.duplicateEvents,
+		m.usageLimited,
+		m.geminiTokens,
+		m.geminiCost,
+	)
+	return m
+}
+
+// Handler 回傳 Prometheus text format 的 /metrics handler。
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{})
+}
+
+// ObserveEvent 記錄收到的 webhook 事件。
+func (m *Metrics) ObserveEvent(event webhook.EventInterface) {
+	if m == nil {
+		return
+	}
+	eventType, messageType := webhookEventType(event)
+	m.webhookEvents.WithLabelValues(eventType, messageType).Inc()
+}
+
+// ObserveExtraction 記錄一次名片辨識花費的時間。
+func (m *Metrics) ObserveExtraction(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.extractionDuration.Observe(d.Seconds())
+}
+
+// ExtractionFailed 記錄在 stage 失敗的名片辨識。
+func (m *Metrics) ExtractionFailed(stage string) {
+	if m == nil {
+		return
+	}
+	m.extractionFailures.WithLabelValues(stage).Inc()
+}
+
+// DuplicateDetected 記錄掃描到已經存在的名片。
+func (m *Metrics) DuplicateDetected() {
+	if m == nil {
+		return
+	}
+	m.duplicates.Inc()
+}
+
+// DuplicateEvent 記錄因為已經處理過而略過的 webhook 事件。
+func (m *Metrics) DuplicateEvent() {
+	if m == nil {
+		return
+	}
+	m.duplicateEvents.Inc()
+}
+
+// UsageLimited 記錄因為超過頻率限制或每日配額而拒絕的操作。
+func (m *Metrics) UsageLimited(kind UsageKind, reason string) {
+	if m == nil {
+		return
+	}
+	m.usageLimited.WithLabelValues(string(kind), reason).Inc()
+}
+
+// GeminiUsage 記錄一次 Gemini 呼叫的 token 數與估計費用。
+func (m *Metrics) GeminiUsage(model string, usage UsageMetadata, cost float64) {
+	if m == nil {
+		return
+	}
+	m.geminiTokens.WithLabelValues(model, "prompt").Add(float64(usage.PromptTokenCount))
+	m.geminiTokens.WithLabelValues(model, "candidates").Add(float64(usage.CandidatesTokenCount))
+	m.geminiCost.WithLabelValues(model).Add(cost)
+}
+
+// NotionTransport 包裝 next，記錄每個 Notion API 請求的操作、狀態碼與延遲。
+func (m *Metrics) NotionTransport(next http.RoundTripper) http.RoundTripper {
+	if m == nil {
+		return next
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		op := notionOperation(req)
+		start := time.Now()
+		resp, err := next.RoundTrip(req)
+		m.notionDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+		code := "error"
+		if err == nil {
+			code = strconv.Itoa(resp.StatusCode)
+		}
+		m.notionRequests.WithLabelValues(op, code).Inc()
+		return resp, err
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// notionOperation 將 Notion API 的路徑轉成固定的操作名稱，避免 page ID 讓指標的 label 無限增加。
+func notionOperation(req *http.Request) string {
+	path := strings.TrimPrefix(req.URL.Path, "/v1/")
+	switch {
+	case strings.HasPrefix(path, "databases/") && strings.HasSuffix(path, "/query"):
+		return "query"
+	case path == "pages" && req.Method == http.MethodPost:
+		return "create"
+	case strings.HasPrefix(path, "pages/") && req.Method == http.MethodPatch:
+		return "update"
+	case strings.HasPrefix(path, "pages/") && req.Method == http.MethodGet:
+		return "get"
+	}
+	return "other"
+}
+
+// webhookEventType 回傳事件的類型，訊息事件另外回傳訊息的類型。
+// SDK 解析後的事件不會填入 Type 欄位，所以依照型別判斷。
+func webhookEventType(event webhook.EventInterface) (string, string) {
+	switch e := event.(type) {
+	case webhook.MessageEvent:
+		switch e.Message.(type) {
+		case webhook.TextMessageContent:
+			return "message", "text"
+		case webhook.ImageMessageContent:
+			return "message", "image"
+		case webhook.AudioMessageContent:
+			return "message", "audio"
+		case webhook.VideoMessageContent:
+			return "message", "video"
+		case webhook.StickerMessageContent:
+			return "message", "sticker"
+		}
+		return "message", "other"
+	case webhook.PostbackEvent:
+		return "postback", ""
+	case webhook.FollowEvent:
+		return "follow", ""
+	case webhook.UnfollowEvent:
+		return "unfollow", ""
+	case webhook.JoinEvent:
+		return "join", ""
+	case webhook.LeaveEvent:
+		return "leave", ""
+	case webhook.BeaconEvent:
+		return "beacon", ""
+	}
+	return "other", ""
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// scrapeMetrics 回傳 /metrics 的內容。
+func scrapeMetrics(t *testing.T, m *Metrics) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	return string(body)
+}
+
+func TestMetricsWebhookEvents(t *testing.T) {
+	h := newWebhookHarness(t)
+	h.AddContact("U1", Person{Name: "王小明", Company: "台灣銀行", Email: "ming@bank.example"})
+	h.SetCard("dup", "```json\n{\"name\":\"王小明\",\"email\":\"ming@bank.example\"}\n```")
+	h.SetCard("bad", "```json\nnot json\n```")
+
+	h.Post(
+		h.Text(userSource("U1"), "王小明"),
+		h.Image(userSource("U1"), "dup"),
+		h.Image(userSource("U1"), "bad"),
+		h.Image(userSource("U1"), "unknown"),
+		h.Follow(userSource("U2")),
+	)
+
+	out := scrapeMetrics(t, h.Server.Metrics)
+	for _, want := range []string{
+		`namecard_webhook_events_total{message="text",type="message"} 1`,
+		`namecard_webhook_events_total{message="image",type="message"} 3`,
+		`namecard_webhook_events_total{message="",type="follow"} 1`,
+		`namecard_extraction_duration_seconds_count 3`,
+		`namecard_extraction_failures_total{stage="read"} 1`,
+		`namecard_extraction_failures_total{stage="parse"} 1`,
+		`namecard_duplicates_detected_total 1`,
+		`namecard_notion_requests_total{code="200",operation="query"}`,
+		`namecard_notion_requests_total{code="200",operation="create"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+}
+
+func TestNotionOperation(t *testing.T) {
+	for _, tt := range []struct {
+		method, path, want string
+	}{
+		{http.MethodPost, "/v1/databases/db/query", "query"},
+		{http.MethodPost, "/v1/pages", "create"},
+		{http.MethodPatch, "/v1/pages/00000000-0000-4000-8000-000000000001", "update"},
+		{http.MethodGet, "/v1/pages/00000000-0000-4000-8000-000000000001", "get"},
+		{http.MethodGet, "/v1/users/me", "other"},
+	} {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if got := notionOperation(req); got != tt.want {
+			t.Errorf("notionOperation(%s %s) = %q, want %q", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	m.ObserveEvent(nil)
+	m.ExtractionFailed("read")
+	m.DuplicateDetected()
+	if rt := m.NotionTransport(nil); rt != nil {
+		t.Errorf("transport = %v, want the next transport unchanged", rt)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// dateRe 比對 2026-06-03、2026/6/3 或 2026.06.03 等日期格式。
+var dateRe = regexp.MustCompile(`\d{4}[-/.]\d{1,2}[-/.]\d{1,2}`)
+
+// dateRangeRe 比對 2026-06-01~2026-06-30 的日期區間，兩側皆可省略其一。
+var dateRangeRe = regexp.MustCompile(`(\d{4}[-/.]\d{1,2}[-/.]\d{1,2})?\s*[~～]\s*(\d{4}[-/.]\d{1,2}[-/.]\d{1,2})?`)
+
+// parseDate 將各種分隔符號的日期轉為 DateLayout 格式，無法解析時回傳空字串。
+func parseDate(s string) string {
+	s = strings.NewReplacer("/", "-", ".", "-").Replace(s)
+	t, err := time.Parse("2006-1-2", s)
+	if err != nil {
+		return ""
+	}
+	return t.Format(DateLayout)
+}
+
+// parseNoteInput 解析用戶輸入的備註文字：
+// #標籤、@活動名稱（或「活動:」開頭的一行）、日期，其餘文字為備註。
+func parseNoteInput(text string) Person {
+	var note Person
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		for _, prefix := range []string{"活動:", "活動：", "event:"} {
+			if strings.HasPrefix(strings.ToLower(trimmed), prefix) {
+				note.Event = strings.TrimSpace(trimmed[len(prefix):])
+				trimmed = ""
+				break
+			}
+		}
+
+		var words []string
+		for _, word := range strings.Fields(trimmed) {
+			switch {
+			case strings.HasPrefix(word, "#") || strings.HasPrefix(word, "＃"):
+				tag := strings.TrimLeft(word, "#＃")
+				if tag != "" {
+					note.Tags = appendUnique(note.Tags, tag)
+				}
+			case strings.HasPrefix(word, "@") && len(word) > 1:
+				note.Event = strings.TrimPrefix(word, "@")
+			case note.MetDate == "" && dateRe.MatchString(word) && parseDate(dateRe.FindString(word)) != "":
+				note.MetDate = parseDate(dateRe.FindString(word))
+			default:
+				words = append(words, word)
+			}
+		}
+		if len(words) > 0 {
+			lines = append(lines, strings.Join(words, " "))
+		}
+	}
+	note.Notes = strings.Join(lines, "\n")
+	return note
+}
+
+// mergeContactContext 將新的備註內容合併到既有名片：備註附加在後、標籤取聯集、活動與日期以新值為準。
+func mergeContactContext(person, note Person) Person {
+	if note.Notes != "" {
+		if person.Notes != "" {
+			person.Notes += "\n"
+		}
+		person.Notes += note.Notes
+	}
+	for _, tag := range note.Tags {
+		person.Tags = appendUnique(person.Tags, tag)
+	}
+	if note.Event != "" {
+		person.Event = note.Event
+	}
+	if note.MetDate != "" {
+		person.MetDate = note.MetDate
+	}
+	return person
+}
+
+// appendUnique 加入不重複的字串。
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+// noteTarget 判斷這則文字訊息是否要補充到某張名片：引用了名片訊息，或是傳送者（key 見 sessionKey）剛按下「新增備註」。
+func (s *Server) noteTarget(key, quotedMessageID string) (string, bool) {
+	if quotedMessageID != "" {
+		if pageID, ok := s.state.sent.Get(quotedMessageID); ok {
+			return pageID, true
+		}
+	}
+	if pageID, ok := s.state.notes.Get(key); ok {
+		s.state.notes.Delete(key)
+		return pageID, true
+	}
+	return "", false
+}
+
+// addContactNote 將用戶輸入的備註加到名片上，並回覆更新後的名片。
+func (s *Server) addContactNote(ctx context.Context, replyToken string, nDB *NotionDB, pageID, text string) {
+	if err := s.updateContactContext(ctx, nDB, pageID, parseNoteInput(text), replyToken); err != nil {
+		loggerFrom(ctx).Error("Error adding note", "err", err)
+	}
+}
+
+// updateContactContext 讀取名片、合併新的情境資訊後寫回 Notion 並更新語意搜尋索引，再回覆更新後的名片。
+func (s *Server) updateContactContext(ctx context.Context, nDB *NotionDB, pageID string, note Person, replyToken string) error {
+	person, err := nDB.GetPage(ctx, pageID)
+	if err != nil {
+		if err := s.replyText(ctx, replyToken, "找不到這張名片，請重新查詢"); err != nil {
+			loggerFrom(ctx).Error("Error replying", "err", err)
+		}
+		return err
+	}
+
+	person = mergeContactContext(person, note)
+	if err := nDB.UpdatePageContext(ctx, person); err != nil {
+		if err := s.replyText(ctx, replyToken, "更新備註失敗，請稍後再試"); err != nil {
+			loggerFrom(ctx).Error("Error replying", "err", err)
+		}
+		return err
+	}
+	s.state.fuzzy.Invalidate(nDB.UID)
+	s.indexContact(ctx, nDB.UID, person)
+
+	return s.SendFlexMsg(ctx, replyToken, []Person{person}, "已更新名片備註")
+}
+
+// searchQuery 是從搜尋文字中解析出的關鍵字、標籤與日期區間。
+type searchQuery struct {
+	Keyword string
+	Tags    []string
+	From    string
+	To      string
+}
+
+// hasContext 回傳查詢是否包含標籤或日期條件。
+func (q searchQuery) hasContext() bool {
+	return len(q.Tags) > 0 || q.From != "" || q.To != ""
+}
+
+// parseSearchQuery 解析搜尋文字，例如「#客戶 2026-06-01~2026-06-30 王」。
+func parseSearchQuery(text string) searchQuery {
+	var q searchQuery
+	if m := dateRangeRe.FindStringSubmatch(text); m != nil && (m[1] != "" || m[2] != "") {
+		q.From, q.To = parseDate(m[1]), parseDate(m[2])
+		text = strings.Replace(text, m[0], " ", 1)
+	} else if d := dateRe.FindString(text); d != "" && parseDate(d) != "" {
+		q.From, q.To = parseDate(d), parseDate(d)
+		text = strings.Replace(text, d, " ", 1)
+	}
+
+	var words []string
+	for _, word := range strings.Fields(text) {
+		if strings.HasPrefix(word, "#") || strings.HasPrefix(word, "＃") {
+			if tag := strings.TrimLeft(word, "#＃"); tag != "" {
+				q.Tags = append(q.Tags, tag)
+			}
+			continue
+		}
+		words = append(words, word)
+	}
+	q.Keyword = strings.Join(words, " ")
+	return q
+}
+
+// filterByKeyword 保留 Name, Title, Company, Email 或備註中包含關鍵字的名片。
+func filterByKeyword(people []Person, keyword string) []Person {
+	if keyword == "" {
+		return people
+	}
+	keyword = strings.ToLower(keyword)
+	var results []Person
+	for _, p := range people {
+		fields := strings.ToLower(strings.Join([]string{p.Name, p.Title, p.Company, p.Email, p.Notes, p.Event}, "\n"))
+		if strings.Contains(fields, keyword) {
+			results = append(results, p)
+		}
+	}
+	return results
+}
+
+// contactContextText 將名片的活動、日期、標籤與備註組成顯示用的文字。
+func contactContextText(p Person) string {
+	var parts []string
+	if p.Event != "" || p.MetDate != "" {
+		parts = append(parts, strings.TrimSpace(fmt.Sprintf("%s %s", p.MetDate, p.Event)))
+	}
+	if len(p.Tags) > 0 {
+		parts = append(parts, "#"+strings.Join(p.Tags, " #"))
+	}
+	if p.Notes != "" {
+		parts = append(parts, p.Notes)
+	}
+	return strings.Join(parts, "\n")
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseNoteInput(t *testing.T) {
+	note := parseNoteInput("在攤位認識 #客戶 #COMPUTEX2026 @COMPUTEX 2026/6/3\n對報價有興趣")
+	want := Person{
+		Notes:   "在攤位認識\n對報價有興趣",
+		Tags:    []string{"客戶", "COMPUTEX2026"},
+		Event:   "COMPUTEX",
+		MetDate: "2026-06-03",
+	}
+	if !reflect.DeepEqual(note, want) {
+		t.Errorf("parseNoteInput() = %+v, want %+v", note, want)
+	}
+
+	note = parseNoteInput("活動: 雲端研討會 台北場")
+	if note.Event != "雲端研討會 台北場" || note.Notes != "" {
+		t.Errorf("parseNoteInput() = %+v, want event only", note)
+	}
+}
+
+func TestMergeContactContext(t *testing.T) {
+	person := Person{Name: "王小明", Notes: "第一次見面", Tags: []string{"客戶"}, MetDate: "2026-01-01"}
+	merged := mergeContactContext(person, Person{Notes: "要報價", Tags: []string{"客戶", "VIP"}, MetDate: "2026-06-03"})
+
+	if merged.Notes != "第一次見面\n要報價" {
+		t.Errorf("Notes = %q", merged.Notes)
+	}
+	if !reflect.DeepEqual(merged.Tags, []string{"客戶", "VIP"}) {
+		t.Errorf("Tags = %v", merged.Tags)
+	}
+	if merged.MetDate != "2026-06-03" {
+		t.Errorf("MetDate = %q", merged.MetDate)
+	}
+}
+
+func TestParseSearchQuery(t *testing.T) {
+	tests := []struct {
+		text string
+		want searchQuery
+	}{
+		{"王小明", searchQuery{Keyword: "王小明"}},
+		{"#客戶 王", searchQuery{Keyword: "王", Tags: []string{"客戶"}}},
+		{"#客戶 2026-06-01~2026-06-30", searchQuery{Tags: []string{"客戶"}, From: "2026-06-01", To: "2026-06-30"}},
+		{"~2026/6/30 業務", searchQuery{Keyword: "業務", To: "2026-06-30"}},
+		{"2026.6.3", searchQuery{From: "2026-06-03", To: "2026-06-03"}},
+	}
+	for _, tt := range tests {
+		if got := parseSearchQuery(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSearchQuery(%q) = %+v, want %+v", tt.text, got, tt.want)
+		}
+	}
+}
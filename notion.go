@@ -2,8 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/jomei/notionapi"
 )
@@ -16,34 +21,175 @@ type Person struct {
 	Email   string `json:"email"`
 	Phone   string `json:"phone"`
 	Company string `json:"company"`
+
+	// 以下為用戶自行補充的認識情境，不由名片辨識產生
+	Notes   string   `json:"notes,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+	Event   string   `json:"event,omitempty"`
+	MetDate string   `json:"met_date,omitempty"` // 格式為 2006-01-02
+
+	// BusinessID 是公司登記資料中的統一編號，設定公司登記查詢時才會有值
+	BusinessID string `json:"business_id,omitempty"`
+
+	// 名片照片：ImageURL 為裁切後用於顯示的圖片，OriginalImageURL 為原始照片
+	ImageURL         string `json:"image_url,omitempty"`
+	OriginalImageURL string `json:"original_image_url,omitempty"`
+
+	// PromptVersion 是辨識這張名片的提示版本，例如 card-ja@v2，設定 PROMPT_DIR 時才會有值
+	PromptVersion string `json:"prompt_version,omitempty"`
+
+	// PageID 是 Notion 頁面 ID，新增或查詢後才會有值
+	PageID string `json:"page_id,omitempty"`
 }
 
+// DateLayout 是會面日期的格式。
+const DateLayout = "2006-01-02"
+
+const (
+	// NotionMaxTextLength 是 Notion 每個 rich text 物件的長度上限（以 UTF-16 計算）。
+	NotionMaxTextLength = 2000
+	// NotionMaxRichTextItems 是 Notion 每個 rich text 屬性最多的物件數。
+	NotionMaxRichTextItems = 100
+)
+
 // DatabaseEntry 定義了 Notion 資料庫條目的結構體。
 type NotionDB struct {
 	DatabaseID string
 	Token      string
 	UID        string
+
+	// BaseURL 與 HTTPClient 可以改連到其他的 Notion API 位址（例如測試用的本地伺服器），空值時使用官方 API
+	BaseURL    string
+	HTTPClient *http.Client
+
+	// Cipher 加密姓名、電話、Email 與地址，nil 時以明文保存
+	Cipher *FieldCipher
+
+	// Log 是處理這個名片簿的事件 logger，空值時使用預設的 logger
+	Log *slog.Logger
+}
+
+// logger 回傳名片簿的 logger。
+func (n *NotionDB) logger() *slog.Logger {
+	if n.Log != nil {
+		return n.Log
+	}
+	return slog.Default()
+}
+
+// client 建立 Notion API client，設定 BaseURL 時將請求轉送到該位址。
+func (n *NotionDB) client() *notionapi.Client {
+	httpClient := n.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	if n.BaseURL != "" {
+		c := *httpClient
+		c.Transport = &baseURLTransport{base: n.BaseURL, next: httpClient.Transport}
+		httpClient = &c
+	}
+	return notionapi.NewClient(notionapi.Token(n.Token), notionapi.WithHTTPClient(httpClient))
+}
+
+// baseURLTransport 將 notionapi 固定送往 api.notion.com 的請求改送到 base，保留原本的路徑與參數。
+type baseURLTransport struct {
+	base string
+	next http.RoundTripper
+}
+
+func (t *baseURLTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base, err := url.Parse(t.base)
+	if err != nil {
+		return nil, fmt.Errorf("invalid notion base url: %w", err)
+	}
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	req = req.Clone(req.Context())
+	req.URL.Scheme = base.Scheme
+	req.URL.Host = base.Host
+	req.URL.Path = strings.TrimSuffix(base.Path, "/") + req.URL.Path
+	req.Host = ""
+	return next.RoundTrip(req)
 }
 
 // QueryDatabaseWithFilter 根據提供的過濾器查詢 Notion 資料庫。
-func (n *NotionDB) queryDatabaseWithFilter(filter *notionapi.DatabaseQueryRequest) ([]Person, error) {
-	client := notionapi.NewClient(notionapi.Token(n.Token))
+func (n *NotionDB) queryDatabaseWithFilter(ctx context.Context, filter *notionapi.DatabaseQueryRequest) ([]Person, error) {
+	client := n.client()
+
+	var entries []Person
+	for {
+		result, err := client.Database.Query(ctx, notionapi.DatabaseID(n.DatabaseID), filter)
+		if err != nil {
+			return nil, fmt.Errorf("error querying database: %w", err)
+		}
+
+		for _, page := range result.Results {
+			entry := n.createEntryFromPage(&page)
+			entries = append(entries, entry)
+		}
+
+		// 依照 next_cursor 繼續讀取下一頁結果
+		if !result.HasMore || result.NextCursor == "" {
+			break
+		}
+		filter.StartCursor = result.NextCursor
+	}
+	return entries, nil
+}
+
+// Ping 查詢資料庫的第一筆資料，確認 token 與資料庫可以使用。
+func (n *NotionDB) Ping(ctx context.Context) error {
+	_, err := n.client().Database.Query(ctx, notionapi.DatabaseID(n.DatabaseID), &notionapi.DatabaseQueryRequest{PageSize: 1})
+	if err != nil {
+		return fmt.Errorf("error querying database: %w", err)
+	}
+	return nil
+}
 
-	result, err := client.Database.Query(context.Background(), notionapi.DatabaseID(n.DatabaseID), filter)
+// QueryDatabaseByUID 取得此用戶在 Notion 資料庫中的所有名片。
+func (n *NotionDB) QueryDatabaseByUID(ctx context.Context) ([]Person, error) {
+	filter := &notionapi.DatabaseQueryRequest{
+		Filter: notionapi.PropertyFilter{
+			Property: "UID",
+			RichText: &notionapi.TextFilterCondition{
+				Equals: n.UID,
+			},
+		},
+	}
+	return n.queryDatabaseWithFilter(ctx, filter)
+}
+
+// QueryDatabaseRecent 取得此用戶最近新增的 limit 張名片，依建立時間由新到舊排序。
+func (n *NotionDB) QueryDatabaseRecent(ctx context.Context, limit int) ([]Person, error) {
+	client := n.client()
+	result, err := client.Database.Query(ctx, notionapi.DatabaseID(n.DatabaseID), &notionapi.DatabaseQueryRequest{
+		Filter: notionapi.PropertyFilter{
+			Property: "UID",
+			RichText: &notionapi.TextFilterCondition{
+				Equals: n.UID,
+			},
+		},
+		Sorts: []notionapi.SortObject{
+			{Timestamp: notionapi.TimestampCreated, Direction: notionapi.SortOrderDESC},
+		},
+		PageSize: limit,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("error querying database: %w", err)
 	}
 
 	var entries []Person
 	for _, page := range result.Results {
-		entry := n.createEntryFromPage(&page)
-		entries = append(entries, entry)
+		entries = append(entries, n.createEntryFromPage(&page))
 	}
 	return entries, nil
 }
 
 // QueryDatabase 根據提供的屬性和值查詢 Notion 資料庫。
-func (n *NotionDB) QueryDatabase(property, value string) ([]Person, error) {
+func (n *NotionDB) QueryDatabase(ctx context.Context, property, value string) ([]Person, error) {
 	filter := &notionapi.DatabaseQueryRequest{
 		Filter: notionapi.AndCompoundFilter{
 			notionapi.PropertyFilter{
@@ -60,11 +206,11 @@ func (n *NotionDB) QueryDatabase(property, value string) ([]Person, error) {
 			},
 		},
 	}
-	return n.queryDatabaseWithFilter(filter)
+	return n.queryDatabaseWithFilter(ctx, filter)
 }
 
 // QueryDatabaseContains 根據提供的屬性和值查詢 Notion 資料庫。
-func (n *NotionDB) QueryContainsDatabase(property, value string) ([]Person, error) {
+func (n *NotionDB) QueryContainsDatabase(ctx context.Context, property, value string) ([]Person, error) {
 	filter := &notionapi.DatabaseQueryRequest{
 		Filter: notionapi.AndCompoundFilter{
 			notionapi.PropertyFilter{
@@ -81,21 +227,55 @@ func (n *NotionDB) QueryContainsDatabase(property, value string) ([]Person, erro
 			},
 		},
 	}
-	return n.queryDatabaseWithFilter(filter)
+	return n.queryDatabaseWithFilter(ctx, filter)
 }
 
-func (n *NotionDB) QueryDatabaseContainsByEmail(email string) ([]Person, error) {
-	return n.QueryContainsDatabase("Email", email)
+func (n *NotionDB) QueryDatabaseContainsByEmail(ctx context.Context, email string) ([]Person, error) {
+	return n.QueryContainsDatabase(ctx, "Email", email)
+}
+
+// QueryDatabaseByEmail 根據提供的電子郵件地址查詢 Notion 資料庫，欄位加密時以 blind index 比對。
+func (n *NotionDB) QueryDatabaseByEmail(ctx context.Context, email string) ([]Person, error) {
+	if n.Cipher != nil {
+		return n.queryBlindIndex(ctx, "EmailIndex", n.Cipher.BlindIndex("email", email))
+	}
+	return n.QueryDatabase(ctx, "Email", email)
+}
+
+// QueryDatabaseByPhone 根據提供的電話查詢 Notion 資料庫，欄位加密時以 blind index 比對。
+func (n *NotionDB) QueryDatabaseByPhone(ctx context.Context, phone string) ([]Person, error) {
+	if normalizeBlindValue("phone", phone) == "" {
+		return nil, nil
+	}
+	if n.Cipher != nil {
+		return n.queryBlindIndex(ctx, "PhoneIndex", n.Cipher.BlindIndex("phone", phone))
+	}
+	return n.QueryDatabase(ctx, "Phone", phone)
 }
 
-// QueryDatabaseByEmail 根據提供的電子郵件地址查詢 Notion 資料庫。
-func (n *NotionDB) QueryDatabaseByEmail(email string) ([]Person, error) {
-	return n.QueryDatabase("Email", email)
+// queryBlindIndex 以 blind index 查詢加密的欄位，空的 index 不會有結果。
+func (n *NotionDB) queryBlindIndex(ctx context.Context, property, index string) ([]Person, error) {
+	if index == "" {
+		return nil, nil
+	}
+	return n.QueryDatabase(ctx, property, index)
 }
 
 // AddPageToDatabase adds a new page with the provided field values to the specified Notion database.
-func (n *NotionDB) AddPageToDatabase(person Person) error {
-	client := notionapi.NewClient(notionapi.Token(n.Token))
+func (n *NotionDB) AddPageToDatabase(ctx context.Context, person Person) error {
+	_, err := n.CreatePage(ctx, person)
+	return err
+}
+
+// CreatePage adds a new page to the Notion database and returns the person with its PageID set.
+func (n *NotionDB) CreatePage(ctx context.Context, person Person) (Person, error) {
+	client := n.client()
+
+	// 設定欄位加密時，姓名、電話、Email 與地址以密文寫入
+	stored, err := n.Cipher.EncryptPerson(person)
+	if err != nil {
+		return person, err
+	}
 
 	// 建立 Properties 物件來設置頁面屬性
 	properties := notionapi.Properties{
@@ -110,8 +290,8 @@ func (n *NotionDB) AddPageToDatabase(person Person) error {
 		"Name": notionapi.RichTextProperty{
 			RichText: []notionapi.RichText{
 				{
-					PlainText: person.Name,
-					Text:      &notionapi.Text{Content: person.Name},
+					PlainText: stored.Name,
+					Text:      &notionapi.Text{Content: stored.Name},
 				},
 			},
 		},
@@ -126,24 +306,24 @@ func (n *NotionDB) AddPageToDatabase(person Person) error {
 		"Address": notionapi.RichTextProperty{
 			RichText: []notionapi.RichText{
 				{
-					PlainText: person.Address,
-					Text:      &notionapi.Text{Content: person.Address},
+					PlainText: stored.Address,
+					Text:      &notionapi.Text{Content: stored.Address},
 				},
 			},
 		},
 		"Email": notionapi.RichTextProperty{
 			RichText: []notionapi.RichText{
 				{
-					PlainText: person.Email,
-					Text:      &notionapi.Text{Content: person.Email},
+					PlainText: stored.Email,
+					Text:      &notionapi.Text{Content: stored.Email},
 				},
 			},
 		},
 		"Phone": notionapi.RichTextProperty{
 			RichText: []notionapi.RichText{
 				{
-					PlainText: person.Phone,
-					Text:      &notionapi.Text{Content: person.Phone},
+					PlainText: stored.Phone,
+					Text:      &notionapi.Text{Content: stored.Phone},
 				},
 			},
 		},
@@ -156,6 +336,40 @@ func (n *NotionDB) AddPageToDatabase(person Person) error {
 			},
 		},
 	}
+	for key, prop := range contextProperties(person) {
+		properties[key] = prop
+	}
+	for key, prop := range n.blindIndexProperties(person) {
+		properties[key] = prop
+	}
+	if person.BusinessID != "" {
+		properties["BusinessID"] = notionapi.RichTextProperty{
+			RichText: []notionapi.RichText{
+				{
+					PlainText: person.BusinessID,
+					Text:      &notionapi.Text{Content: person.BusinessID},
+				},
+			},
+		}
+	}
+	if person.PromptVersion != "" {
+		properties["PromptVersion"] = notionapi.RichTextProperty{
+			RichText: []notionapi.RichText{
+				{
+					PlainText: person.PromptVersion,
+					Text:      &notionapi.Text{Content: person.PromptVersion},
+				},
+			},
+		}
+	}
+	if person.ImageURL != "" {
+		properties["Image"] = notionapi.FilesProperty{
+			Files: []notionapi.File{
+				{Name: "cropped", Type: notionapi.FileTypeExternal, External: &notionapi.FileObject{URL: person.ImageURL}},
+				{Name: "original", Type: notionapi.FileTypeExternal, External: &notionapi.FileObject{URL: person.OriginalImageURL}},
+			},
+		}
+	}
 
 	// 創建一個新頁面的請求
 	pageRequest := &notionapi.PageCreateRequest{
@@ -164,18 +378,242 @@ func (n *NotionDB) AddPageToDatabase(person Person) error {
 		},
 		Properties: properties,
 	}
+	if person.ImageURL != "" {
+		pageRequest.Cover = &notionapi.Image{
+			Type:     notionapi.FileTypeExternal,
+			External: &notionapi.FileObject{URL: person.ImageURL},
+		}
+	}
 
 	// 調用 Notion API 來創建新頁面
-	_, err := client.Page.Create(context.Background(), pageRequest)
+	page, err := client.Page.Create(ctx, pageRequest)
+	if err != nil {
+		n.logger().Error("Error creating page", "err", err)
+		return person, err
+	}
+	person.PageID = page.ID.String()
+
+	n.logger().Info("Page added successfully", "book", n.UID, "contact", person)
+	return person, nil
+}
+
+// RotateEncryptedFields 以目前的主金鑰重新加密資料庫中所有名片的個資欄位（明文的舊資料會被加密），
+// 並補上缺少的 blind index，回傳更新的頁面數。
+func (n *NotionDB) RotateEncryptedFields(ctx context.Context) (int, error) {
+	if n.Cipher == nil {
+		return 0, errors.New("field encryption is not configured")
+	}
+	client := n.client()
+
+	updated := 0
+	req := &notionapi.DatabaseQueryRequest{}
+	for {
+		result, err := client.Database.Query(ctx, notionapi.DatabaseID(n.DatabaseID), req)
+		if err != nil {
+			return updated, fmt.Errorf("error querying database: %w", err)
+		}
+
+		for _, page := range result.Results {
+			properties := notionapi.Properties{}
+			for _, field := range []string{"Name", "Phone", "Email", "Address"} {
+				rotated, changed, err := n.Cipher.Rotate(n.getPropertyValue(&page, field))
+				if err != nil {
+					return updated, fmt.Errorf("error rotating %s of page %s: %w", field, page.ID, err)
+				}
+				if changed {
+					properties[field] = notionapi.RichTextProperty{
+						RichText: []notionapi.RichText{
+							{
+								PlainText: rotated,
+								Text:      &notionapi.Text{Content: rotated},
+							},
+						},
+					}
+				}
+			}
+			for key, prop := range n.blindIndexProperties(n.createEntryFromPage(&page)) {
+				if n.getPropertyValue(&page, key) != prop.(notionapi.RichTextProperty).RichText[0].PlainText {
+					properties[key] = prop
+				}
+			}
+			if len(properties) == 0 {
+				continue
+			}
+
+			if _, err := client.Page.Update(ctx, notionapi.PageID(page.ID), &notionapi.PageUpdateRequest{Properties: properties}); err != nil {
+				return updated, fmt.Errorf("error updating page: %w", err)
+			}
+			updated++
+		}
+
+		if !result.HasMore || result.NextCursor == "" {
+			return updated, nil
+		}
+		req.StartCursor = result.NextCursor
+	}
+}
+
+// blindIndexProperties 建立 Email 與電話的 blind index 屬性，只在設定欄位加密時使用。
+func (n *NotionDB) blindIndexProperties(person Person) notionapi.Properties {
+	properties := notionapi.Properties{}
+	for property, index := range map[string]string{
+		"EmailIndex": n.Cipher.BlindIndex("email", person.Email),
+		"PhoneIndex": n.Cipher.BlindIndex("phone", person.Phone),
+	} {
+		if index == "" {
+			continue
+		}
+		properties[property] = notionapi.RichTextProperty{
+			RichText: []notionapi.RichText{
+				{
+					PlainText: index,
+					Text:      &notionapi.Text{Content: index},
+				},
+			},
+		}
+	}
+	return properties
+}
+
+// contextProperties 建立備註、標籤、活動與會面日期的 Notion 屬性，只包含有值的欄位。
+func contextProperties(person Person) notionapi.Properties {
+	properties := notionapi.Properties{}
+	if person.Notes != "" {
+		// 備註會一直附加，超過 Notion 的長度上限時分成多段
+		properties["Notes"] = notionapi.RichTextProperty{RichText: richTextChunks(person.Notes)}
+	}
+	if len(person.Tags) > 0 {
+		var options []notionapi.Option
+		for _, tag := range person.Tags {
+			options = append(options, notionapi.Option{Name: tag})
+		}
+		properties["Tags"] = notionapi.MultiSelectProperty{MultiSelect: options}
+	}
+	if person.Event != "" {
+		properties["Event"] = notionapi.RichTextProperty{RichText: richTextChunks(person.Event)}
+	}
+	if t, err := time.Parse(DateLayout, person.MetDate); err == nil {
+		date := notionapi.Date(t)
+		properties["MetDate"] = notionapi.DateProperty{
+			Date: &notionapi.DateObject{Start: &date},
+		}
+	}
+	return properties
+}
+
+// richTextChunks 將 s 切成長度不超過 NotionMaxTextLength 的 rich text 物件。
+// 超過 NotionMaxRichTextItems 段時捨棄最前面的內容，保留最新附加的備註。
+func richTextChunks(s string) []notionapi.RichText {
+	var chunks []notionapi.RichText
+	var b strings.Builder
+	n := 0
+	flush := func() {
+		chunks = append(chunks, notionapi.RichText{PlainText: b.String(), Text: &notionapi.Text{Content: b.String()}})
+		b.Reset()
+		n = 0
+	}
+	for _, r := range s {
+		size := 1
+		if r > 0xFFFF {
+			size = 2
+		}
+		if n+size > NotionMaxTextLength {
+			flush()
+		}
+		b.WriteRune(r)
+		n += size
+	}
+	if n > 0 || len(chunks) == 0 {
+		flush()
+	}
+	if len(chunks) > NotionMaxRichTextItems {
+		chunks = chunks[len(chunks)-NotionMaxRichTextItems:]
+	}
+	return chunks
+}
+
+// GetPage 依照 Notion 頁面 ID 取得名片。
+func (n *NotionDB) GetPage(ctx context.Context, pageID string) (Person, error) {
+	client := n.client()
+
+	page, err := client.Page.Get(ctx, notionapi.PageID(pageID))
 	if err != nil {
-		log.Println("Error creating page:", err)
-		return err
+		return Person{}, fmt.Errorf("error getting page: %w", err)
 	}
 
-	log.Println("Page added successfully:", n.UID, person)
+	// 只能讀取自己的名片
+	if prop, ok := page.Properties["UID"].(*notionapi.TitleProperty); !ok || len(prop.Title) == 0 || prop.Title[0].PlainText != n.UID {
+		return Person{}, fmt.Errorf("page %s does not belong to user", pageID)
+	}
+	return n.createEntryFromPage(page), nil
+}
+
+// UpdatePageContext 更新名片的備註、標籤、活動與會面日期。
+func (n *NotionDB) UpdatePageContext(ctx context.Context, person Person) error {
+	client := n.client()
+
+	properties := contextProperties(person)
+	if len(properties) == 0 {
+		return nil
+	}
+
+	_, err := client.Page.Update(ctx, notionapi.PageID(person.PageID), &notionapi.PageUpdateRequest{
+		Properties: properties,
+	})
+	if err != nil {
+		return fmt.Errorf("error updating page: %w", err)
+	}
 	return nil
 }
 
+// ArchivePage 封存（刪除）名片頁面，Notion 會將頁面移到垃圾桶。
+func (n *NotionDB) ArchivePage(ctx context.Context, pageID string) error {
+	client := n.client()
+	_, err := client.Page.Update(ctx, notionapi.PageID(pageID), &notionapi.PageUpdateRequest{
+		Properties: notionapi.Properties{},
+		Archived:   true,
+	})
+	if err != nil {
+		return fmt.Errorf("error archiving page: %w", err)
+	}
+	return nil
+}
+
+// QueryDatabaseByContext 依照標籤與會面日期區間查詢此用戶的名片，from 與 to 可為空字串。
+func (n *NotionDB) QueryDatabaseByContext(ctx context.Context, tags []string, from, to string) ([]Person, error) {
+	filters := notionapi.AndCompoundFilter{
+		notionapi.PropertyFilter{
+			Property: "UID",
+			RichText: &notionapi.TextFilterCondition{
+				Equals: n.UID,
+			},
+		},
+	}
+	for _, tag := range tags {
+		filters = append(filters, notionapi.PropertyFilter{
+			Property: "Tags",
+			MultiSelect: &notionapi.MultiSelectFilterCondition{
+				Contains: tag,
+			},
+		})
+	}
+	if t, err := time.Parse(DateLayout, from); err == nil {
+		date := notionapi.Date(t)
+		filters = append(filters, notionapi.PropertyFilter{
+			Property: "MetDate",
+			Date:     &notionapi.DateFilterCondition{OnOrAfter: &date},
+		})
+	}
+	if t, err := time.Parse(DateLayout, to); err == nil {
+		date := notionapi.Date(t)
+		filters = append(filters, notionapi.PropertyFilter{
+			Property: "MetDate",
+			Date:     &notionapi.DateFilterCondition{OnOrBefore: &date},
+		})
+	}
+	return n.queryDatabaseWithFilter(ctx, &notionapi.DatabaseQueryRequest{Filter: filters})
+}
+
 // createEntryFromPage creates a Person from a page.
 func (n *NotionDB) createEntryFromPage(page *notionapi.Page) Person {
 	entry := Person{}
@@ -186,58 +624,98 @@ func (n *NotionDB) createEntryFromPage(page *notionapi.Page) Person {
 	entry.Email = n.getPropertyValue(page, "Email")
 	entry.Phone = n.getPropertyValue(page, "Phone")
 	entry.Company = n.getPropertyValue(page, "Company")
+	entry.Notes = n.getPropertyValue(page, "Notes")
+	entry.Event = n.getPropertyValue(page, "Event")
+	entry.BusinessID = n.getPropertyValue(page, "BusinessID")
+	entry.PromptVersion = n.getPropertyValue(page, "PromptVersion")
+	entry.PageID = page.ID.String()
+
+	if prop, ok := page.Properties["Tags"].(*notionapi.MultiSelectProperty); ok {
+		for _, option := range prop.MultiSelect {
+			entry.Tags = append(entry.Tags, option.Name)
+		}
+	}
+	if prop, ok := page.Properties["Image"].(*notionapi.FilesProperty); ok {
+		for _, file := range prop.Files {
+			url := file.External
+			if url == nil {
+				url = file.File
+			}
+			if url == nil {
+				continue
+			}
+			switch file.Name {
+			case "cropped":
+				entry.ImageURL = url.URL
+			case "original":
+				entry.OriginalImageURL = url.URL
+			}
+		}
+	}
+	if prop, ok := page.Properties["MetDate"].(*notionapi.DateProperty); ok && prop.Date != nil && prop.Date.Start != nil {
+		entry.MetDate = time.Time(*prop.Date.Start).Format(DateLayout)
+	}
 
-	return entry
+	return n.Cipher.DecryptPerson(entry)
 }
 
-// getPropertyValue gets the plain text value of a property from a page.
+// getPropertyValue gets the plain text value of a property from a page. Long text is split into several rich text objects.
 func (n *NotionDB) getPropertyValue(page *notionapi.Page, property string) string {
-	if prop, ok := page.Properties[property].(*notionapi.RichTextProperty); ok && len(prop.RichText) > 0 {
-		return prop.RichText[0].PlainText
+	prop, ok := page.Properties[property].(*notionapi.RichTextProperty)
+	if !ok {
+		return ""
 	}
-
-	return ""
+	var b strings.Builder
+	for _, text := range prop.RichText {
+		b.WriteString(text.PlainText)
+	}
+	return b.String()
 }
 
 // QueryDatabaseByName 根據提供的名稱查詢 Notion 資料庫。
-func (n *NotionDB) QueryDatabaseByName(name string) ([]Person, error) {
-	return n.QueryDatabase("Name", name)
+func (n *NotionDB) QueryDatabaseByName(ctx context.Context, name string) ([]Person, error) {
+	return n.QueryDatabase(ctx, "Name", name)
 }
 
 // QueryDatabaseContainsByName 根據提供的名稱查詢 Notion 資料庫。
-func (n *NotionDB) QueryDatabaseContainsByName(name string) ([]Person, error) {
-	return n.QueryContainsDatabase("Name", name)
+func (n *NotionDB) QueryDatabaseContainsByName(ctx context.Context, name string) ([]Person, error) {
+	return n.QueryContainsDatabase(ctx, "Name", name)
 }
 
 // QueryDatabaseContainsByName 根據提供的名稱查詢 Notion 資料庫。
-func (n *NotionDB) QueryDatabaseContainsByTitle(name string) ([]Person, error) {
-	return n.QueryContainsDatabase("Title", name)
+func (n *NotionDB) QueryDatabaseContainsByTitle(ctx context.Context, name string) ([]Person, error) {
+	return n.QueryContainsDatabase(ctx, "Title", name)
 }
 
 // QueryDatabaseContains 根據提供的名稱查詢 Notion 資料庫。
-func (n *NotionDB) QueryDatabaseContains(query string) ([]Person, error) {
+func (n *NotionDB) QueryDatabaseContains(ctx context.Context, query string) ([]Person, error) {
+	// 姓名與 Email 加密後無法部分比對，改以 blind index 完全比對 Email 與電話，姓名交給本地的模糊搜尋
+	if n.Cipher != nil {
+		return n.queryEncryptedContains(ctx, query)
+	}
+
 	// 初始化一個空的結果集
 	var combinedResult []Person
 
 	// 進行名稱查詢
-	nameResult, err := n.QueryDatabaseContainsByName(query)
-	log.Println("QueryDatabaseContainsByName", nameResult, err)
+	nameResult, err := n.QueryDatabaseContainsByName(ctx, query)
+	n.logger().Debug("QueryDatabaseContainsByName", "count", len(nameResult), "err", err)
 	if err != nil {
 		return nil, err
 	}
 	combinedResult = append(combinedResult, nameResult...)
 
 	// 進行電子郵件查詢
-	emailResult, err := n.QueryDatabaseContainsByEmail(query)
-	log.Println("QueryDatabaseContainsByEmail", emailResult, err)
+	emailResult, err := n.QueryDatabaseContainsByEmail(ctx, query)
+	n.logger().Debug("QueryDatabaseContainsByEmail", "count", len(emailResult), "err", err)
 	if err != nil {
 		return nil, err
 	}
 	combinedResult = append(combinedResult, emailResult...)
 
 	// 進行標題查詢
-	titleResult, err := n.QueryDatabaseContainsByTitle(query)
-	log.Println("QueryDatabaseContainsByTitle", titleResult, err)
+	titleResult, err := n.QueryDatabaseContainsByTitle(ctx, query)
+	n.logger().Debug("QueryDatabaseContainsByTitle", "count", len(titleResult), "err", err)
 	if err != nil {
 		return nil, err
 	}
@@ -246,3 +724,16 @@ func (n *NotionDB) QueryDatabaseContains(query string) ([]Person, error) {
 	// 返回結合的結果
 	return combinedResult, nil
 }
+
+// queryEncryptedContains 是欄位加密時的 QueryDatabaseContains：Email 與電話以 blind index 完全比對，職稱以部分比對。
+func (n *NotionDB) queryEncryptedContains(ctx context.Context, query string) ([]Person, error) {
+	var combinedResult []Person
+	for _, q := range []func(context.Context, string) ([]Person, error){n.QueryDatabaseByEmail, n.QueryDatabaseByPhone, n.QueryDatabaseContainsByTitle} {
+		result, err := q(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		combinedResult = append(combinedResult, result...)
+	}
+	return combinedResult, nil
+}
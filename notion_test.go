@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestQueryNotionDB(t *testing.T) {
@@ -21,13 +25,13 @@ func TestQueryNotionDB(t *testing.T) {
 		UID:        "uid",
 	}
 
-	entries, err := db.QueryDatabaseByName("name")
+	entries, err := db.QueryDatabaseByName(context.Background(), "name")
 	if err != nil {
 		t.Fatal(err)
 	}
 	fmt.Printf("%+v\n", entries)
 
-	entries, err = db.QueryDatabaseByEmail("email@email.com")
+	entries, err = db.QueryDatabaseByEmail(context.Background(), "email@email.com")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -50,7 +54,7 @@ func TestAddNotionDB(t *testing.T) {
 		UID:        "uid",
 	}
 
-	err := db.AddPageToDatabase(Person{Name: "test", Title: "test", Address: "test", Email: "test", Phone: "test", Company: "test"})
+	err := db.AddPageToDatabase(context.Background(), Person{Name: "test", Title: "test", Address: "test", Email: "test", Phone: "test", Company: "test"})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -71,13 +75,13 @@ func TestQueryContainNotionDB(t *testing.T) {
 		UID:        "uid",
 	}
 
-	entries, err := db.QueryDatabaseContainsByName("name")
+	entries, err := db.QueryDatabaseContainsByName(context.Background(), "name")
 	if err != nil {
 		t.Fatal(err)
 	}
 	fmt.Printf("%+v\n", entries)
 
-	entries, err = db.QueryDatabaseContainsByEmail("email")
+	entries, err = db.QueryDatabaseContainsByEmail(context.Background(), "email")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -85,7 +89,7 @@ func TestQueryContainNotionDB(t *testing.T) {
 	fmt.Printf("%+v\n", entries)
 
 	//test contains all columns (name, title, email)
-	entries, err = db.QueryDatabaseContains("keyword")
+	entries, err = db.QueryDatabaseContains(context.Background(), "keyword")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -93,3 +97,275 @@ func TestQueryContainNotionDB(t *testing.T) {
 	fmt.Printf("%+v\n", entries)
 
 }
+
+func TestFakeNotionCreateAndQuery(t *testing.T) {
+	f := newFakeNotion(t)
+	db := f.NotionDB("db", "U1")
+
+	for _, p := range []Person{
+		{Name: "王小明", Title: "資安經理", Email: "ming@bank.example", Phone: "+886-2-1234-5678", Company: "台灣銀行"},
+		{Name: "陳大文", Title: "工程師", Email: "david@example.com", Company: "Example"},
+		{Name: "林美華", Title: "Product Manager", Email: "mei@example.com", Company: "Example"},
+	} {
+		if _, err := db.CreatePage(context.Background(), p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := f.NotionDB("db", "U2").CreatePage(context.Background(), Person{Name: "王小明", Email: "other@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := db.QueryDatabaseByUID(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 {
+		t.Errorf("QueryDatabaseByUID() = %d pages, want 3", len(all))
+	}
+
+	byName, err := db.QueryDatabaseByName(context.Background(), "王小明")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(byName) != 1 || byName[0].Email != "ming@bank.example" {
+		t.Errorf("QueryDatabaseByName() = %+v, want only U1's card", byName)
+	}
+
+	// 姓名、Email 與職稱的部分比對不分大小寫
+	contains, err := db.QueryDatabaseContains(context.Background(), "EXAMPLE.COM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contains) != 2 {
+		t.Errorf("QueryDatabaseContains(EXAMPLE.COM) = %d pages, want 2", len(contains))
+	}
+	if byPhone, err := db.QueryDatabaseByPhone(context.Background(), "+886-2-1234-5678"); err != nil || len(byPhone) != 1 {
+		t.Errorf("QueryDatabaseByPhone() = %v, %v, want 1 page", byPhone, err)
+	}
+
+	// 不能讀取其他用戶的名片
+	if _, err := f.NotionDB("db", "U2").GetPage(context.Background(), byName[0].PageID); err == nil {
+		t.Error("GetPage() of another user's page succeeded")
+	}
+	got, err := db.GetPage(context.Background(), byName[0].PageID)
+	if err != nil || got.Company != "台灣銀行" {
+		t.Errorf("GetPage() = %+v, %v", got, err)
+	}
+
+	// 錯誤的 token 會回傳 Notion 的錯誤
+	bad := f.NotionDB("db", "U1")
+	bad.Token = "wrong"
+	if _, err := bad.QueryDatabaseByUID(context.Background()); err == nil {
+		t.Error("QueryDatabaseByUID() with a wrong token succeeded")
+	}
+}
+
+func TestFakeNotionPagination(t *testing.T) {
+	f := newFakeNotion(t)
+	f.MaxPageSize = 2
+	db := f.NotionDB("db", "U1")
+	for i := 0; i < 5; i++ {
+		if _, err := db.CreatePage(context.Background(), Person{Name: fmt.Sprintf("name%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	all, err := db.QueryDatabaseByUID(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 5 {
+		t.Errorf("QueryDatabaseByUID() = %d pages, want 5", len(all))
+	}
+	if f.Queries() != 3 {
+		t.Errorf("queries = %d, want 3 pages of results", f.Queries())
+	}
+
+	// 最近新增只讀取一頁，由新到舊
+	recent, err := db.QueryDatabaseRecent(context.Background(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recent) != 2 || recent[0].Name != "name4" || recent[1].Name != "name3" {
+		t.Errorf("QueryDatabaseRecent(3) = %+v, want name4, name3", recent)
+	}
+}
+
+func TestNotionDBUsesCallerContext(t *testing.T) {
+	f := newFakeNotion(t)
+	db := f.NotionDB("db", "U1")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// 呼叫端取消後不再送出請求
+	if _, err := db.QueryDatabaseByUID(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("QueryDatabaseByUID() = %v, want context.Canceled", err)
+	}
+	if _, err := db.CreatePage(ctx, Person{Name: "王小明"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("CreatePage() = %v, want context.Canceled", err)
+	}
+	if f.Queries() != 0 {
+		t.Errorf("queries = %d, want none", f.Queries())
+	}
+}
+
+func TestRichTextChunks(t *testing.T) {
+	// emoji 以 UTF-16 計算佔 2 個字
+	long := strings.Repeat("備", NotionMaxTextLength-1) + "😀" + "註"
+	chunks := richTextChunks(long)
+	if len(chunks) != 2 || chunks[0].Text.Content != strings.Repeat("備", NotionMaxTextLength-1) || chunks[1].Text.Content != "😀註" {
+		t.Errorf("chunks = %d, first = %d runes", len(chunks), len([]rune(chunks[0].Text.Content)))
+	}
+
+	// 超過段數上限時保留最後的內容
+	huge := strings.Repeat("a", NotionMaxTextLength*NotionMaxRichTextItems) + "最新"
+	chunks = richTextChunks(huge)
+	if len(chunks) != NotionMaxRichTextItems || chunks[len(chunks)-1].Text.Content != "最新" {
+		t.Errorf("chunks = %d, last = %q", len(chunks), chunks[len(chunks)-1].Text.Content)
+	}
+}
+
+func TestLongNotesRoundTrip(t *testing.T) {
+	f := newFakeNotion(t)
+	db := f.NotionDB("db", "U1")
+	p, err := db.CreatePage(context.Background(), Person{Name: "A"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 超過 Notion 單段 2000 字的備註分段保存，讀回時是完整的內容
+	p.Notes = strings.Repeat("在攤位聊了很久。", 600)
+	if err := db.UpdatePageContext(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+	got, err := db.GetPage(context.Background(), p.PageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Notes != p.Notes {
+		t.Errorf("Notes = %d runes, want %d", len([]rune(got.Notes)), len([]rune(p.Notes)))
+	}
+}
+
+func TestFakeNotionContextAndArchive(t *testing.T) {
+	f := newFakeNotion(t)
+	db := f.NotionDB("db", "U1")
+
+	a, err := db.CreatePage(context.Background(), Person{Name: "A", Tags: []string{"客戶"}, MetDate: "2026-05-20"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := db.CreatePage(context.Background(), Person{Name: "B"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Tags, b.Event, b.MetDate = []string{"客戶", "投資人"}, "COMPUTEX", "2026-06-03"
+	if err := db.UpdatePageContext(context.Background(), b); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.Page(b.PageID).Text("Event"); got != "COMPUTEX" {
+		t.Errorf("Event = %q, want COMPUTEX", got)
+	}
+
+	results, err := db.QueryDatabaseByContext(context.Background(), []string{"客戶"}, "2026-06-01", "2026-06-30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Name != "B" || results[0].MetDate != "2026-06-03" || len(results[0].Tags) != 2 {
+		t.Errorf("QueryDatabaseByContext() = %+v, want B", results)
+	}
+
+	if err := db.ArchivePage(context.Background(), a.PageID); err != nil {
+		t.Fatal(err)
+	}
+	if !f.Page(a.PageID).Archived {
+		t.Error("ArchivePage() did not archive the page")
+	}
+	if results, _ := db.QueryDatabaseByContext(context.Background(), []string{"客戶"}, "", ""); len(results) != 1 {
+		t.Errorf("QueryDatabaseByContext() after archive = %+v, want only B", results)
+	}
+}
+
+func TestFakeNotionEncryptedFields(t *testing.T) {
+	f := newFakeNotion(t)
+	db := f.NotionDB("db", "U1")
+	db.Cipher = testFieldCipher(t, "k1", "k1")
+	created, err := db.CreatePage(context.Background(), Person{Name: "王小明", Email: "Ming@Bank.example", Phone: "02-1234-5678"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Notion 中只保存密文與 blind index
+	page := f.Page(created.PageID)
+	if name := page.Text("Name"); !strings.HasPrefix(name, encryptedPrefix) {
+		t.Errorf("stored Name = %q, want ciphertext", name)
+	}
+	if page.Text("EmailIndex") == "" {
+		t.Error("EmailIndex was not stored")
+	}
+
+	found, err := db.QueryDatabaseByEmail(context.Background(), "ming@bank.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].Name != "王小明" {
+		t.Errorf("QueryDatabaseByEmail() = %+v, want the decrypted card", found)
+	}
+}
+
+func TestPurgeUserDataArchivesPages(t *testing.T) {
+	f := newFakeNotion(t)
+	db := f.NotionDB("db", "U1")
+	for _, name := range []string{"A", "B"} {
+		if _, err := db.CreatePage(context.Background(), Person{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	other, err := f.NotionDB("db", "U2").CreatePage(context.Background(), Person{Name: "C"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queue, _ := NewCardQueue("")
+	queue.Schedule(CardJob{MessageID: "m1", BookID: "U1"})
+	queue.Schedule(CardJob{MessageID: "m2", BookID: "U2"})
+
+	prompts, err := LoadPromptRegistry(writePrompts(t, map[string]string{"card/v1.tmpl": "名片"}), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prompts.Assign("U1", "card")
+
+	images := &LocalBlobStore{Dir: t.TempDir(), BaseURL: "https://bot.example.com/images/"}
+	s := &Server{Config: DefaultConfig(), Images: images, CardJobs: queue, Prompts: prompts, state: newLocalState()}
+	s.state.languages.Set("U1", "ja")
+	// 新版的圖片放在 HMAC 目錄，舊版的放在 LINE ID 目錄
+	for _, key := range []string{"cards/" + s.blobPrefix("U1") + "/a.jpg", "exports/" + s.blobPrefix("U1") + "/b.csv", "cards/U1/c.jpg", "cards/" + s.blobPrefix("U2") + "/d.jpg"} {
+		if _, err := images.Put(context.Background(), key, []byte("data"), "image/jpeg"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err := s.purgeUserData(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Pages != 2 || result.Files != 3 {
+		t.Errorf("purged %d pages and %d files, want 2 and 3", result.Pages, result.Files)
+	}
+	if left, _ := db.QueryDatabaseByUID(context.Background()); len(left) != 0 {
+		t.Errorf("pages left after purge = %+v", left)
+	}
+	if f.Page(other.PageID).Archived {
+		t.Error("purge archived another user's page")
+	}
+	if prompts.Assignment("U1") != "" {
+		t.Error("prompt assignment left after purge")
+	}
+	if _, ok := s.state.languages.Get("U1"); ok {
+		t.Error("cached language left after purge")
+	}
+	// 佇列中尚未處理的名片也一併刪除
+	if jobs := queue.Due(time.Now()); len(jobs) != 1 || jobs[0].BookID != "U2" {
+		t.Errorf("queued cards after purge = %+v, want only U2", jobs)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+// tutorialImageURL 是新手教學使用的圖片，與 LogoImageUrl 放在同一個 repo。
+const tutorialImageURL = "https://raw.githubusercontent.com/kkdai/linebot-smart-namecard/main/img/"
+
+// groupSearchPrefixes 是群組中搜尋名片需要加上的前綴，避免一般聊天都被當成搜尋。
+var groupSearchPrefixes = []string{"找", "搜尋"}
+
+// Consent 是用戶對保存名片資料的同意紀錄。
+type Consent struct {
+	Agreed bool      `json:"agreed"`
+	At     time.Time `json:"at"`
+}
+
+// ConsentStore 是存放於本地檔案的同意紀錄，path 為空時只存在記憶體。
+type ConsentStore struct {
+	mu    sync.Mutex
+	path  string
+	items map[string]Consent
+}
+
+// NewConsentStore 建立同意紀錄，若 path 不為空則從檔案載入既有的紀錄。
+func NewConsentStore(path string) (*ConsentStore, error) {
+	s := &ConsentStore{path: path, items: make(map[string]Consent)}
+	if path != "" {
+		if err := loadJSONFile(path, &s.items); err != nil {
+			return nil, fmt.Errorf("error loading consents: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// Set 記錄用戶同意或拒絕保存名片資料。
+func (s *ConsentStore) Set(uid string, agreed bool, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[uid] = Consent{Agreed: agreed, At: now}
+	return s.save()
+}
+
+// Get 回傳用戶的同意紀錄，沒有回答過時 ok 為 false。
+func (s *ConsentStore) Get(uid string) (Consent, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.items[uid]
+	return c, ok
+}
+
+// Delete 刪除用戶的同意紀錄。
+func (s *ConsentStore) Delete(uid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.items, uid)
+	return s.save()
+}
+
+// Declined 回傳用戶是否明確拒絕保存名片資料。尚未回答的舊用戶視為同意，以免影響既有的使用方式。
+func (s *ConsentStore) Declined(uid string) bool {
+	c, ok := s.Get(uid)
+	return ok && !c.Agreed
+}
+
+// save 將同意紀錄寫入檔案，呼叫前需持有鎖。
+func (s *ConsentStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	return saveJSONFile(s.path, s.items)
+}
+
+// handleFollow 回覆新好友的歡迎訊息、新手教學與資料保存同意。
+func (s *Server) handleFollow(ctx context.Context, replyToken string) {
+	messages := []messaging_api.MessageInterface{
+		&messaging_api.TextMessage{
+			Text: "歡迎使用智慧名片小幫手！傳送名片照片，我會自動辨識並整理到你的名片簿，之後可以用關鍵字、標籤或描述找到聯絡人。",
+		},
+		&messaging_api.FlexMessage{
+			AltText:  "新手教學",
+			Contents: tutorialFlex(),
+		},
+	}
+	if s.Consents != nil {
+		messages = append(messages, consentMessage())
+	}
+
+	if _, err := s.Bot.ReplyMessage(
+		&messaging_api.ReplyMessageRequest{
+			ReplyToken: replyToken,
+			Messages:   messages,
+		},
+	); err != nil {
+		loggerFrom(ctx).Error("Error replying", "err", err)
+	}
+}
+
+// handleJoin 說明群組共用名片簿的使用方式與群組中可以使用的指令。
+func (s *Server) handleJoin(ctx context.Context, replyToken string) {
+	text := strings.Join([]string{
+		"大家好！我是智慧名片小幫手。",
+		"在這個群組傳送的名片會存到群組共用的名片簿，所有成員都可以查詢，與各自的個人名片簿分開。",
+		"",
+		"群組中可以使用：",
+		"・傳送名片照片：新增到群組名片簿",
+		"・找 王小明：搜尋群組名片簿（需要加上「找」或「搜尋」，一般聊天不會觸發搜尋）",
+		"・最近新增、公司、匯出、說明",
+		"・引用名片訊息回覆：新增備註",
+	}, "\n")
+	if err := s.replyText(ctx, replyToken, text); err != nil {
+		loggerFrom(ctx).Error("Error replying", "err", err)
+	}
+}
+
+// handleConsentPostback 記錄用戶對保存名片資料的回答。
+func (s *Server) handleConsentPostback(ctx context.Context, replyToken, uid string, data url.Values) {
+	if s.Consents == nil {
+		return
+	}
+
+	agreed := data.Get("answer") == "yes"
+	if err := s.Consents.Set(uid, agreed, time.Now()); err != nil {
+		loggerFrom(ctx).Error("Error saving consent", "err", err)
+	}
+
+	ret := "謝謝！現在可以傳送名片照片開始使用。"
+	if !agreed {
+		ret = "了解，我們不會保存你的名片資料，傳送的名片照片也不會被辨識。之後想使用時，輸入「同意」即可。"
+	}
+	if err := s.replyText(ctx, replyToken, ret); err != nil {
+		loggerFrom(ctx).Error("Error replying", "err", err)
+	}
+}
+
+// replyConsent 回覆資料保存同意的詢問。
+func (s *Server) replyConsent(ctx context.Context, replyToken string) {
+	if _, err := s.Bot.ReplyMessage(
+		&messaging_api.ReplyMessageRequest{
+			ReplyToken: replyToken,
+			Messages:   []messaging_api.MessageInterface{consentMessage()},
+		},
+	); err != nil {
+		loggerFrom(ctx).Error("Error replying", "err", err)
+	}
+}
+
+// consentMessage 建立詢問是否同意保存名片資料的訊息。
+func consentMessage() messaging_api.MessageInterface {
+	return &messaging_api.TextMessage{
+		Text: "為了提供名片整理與搜尋，我們會將名片照片交給 Google Gemini 辨識，並把名片資料保存在 Notion 資料庫。你可以隨時輸入「刪除我的資料」刪除。是否同意保存名片資料？",
+		QuickReply: &messaging_api.QuickReply{
+			Items: []messaging_api.QuickReplyItem{
+				{Type: "action", Action: &messaging_api.PostbackAction{Label: "同意", Data: "action=consent&answer=yes", DisplayText: "同意"}},
+				{Type: "action", Action: &messaging_api.PostbackAction{Label: "不同意", Data: "action=consent&answer=no", DisplayText: "不同意"}},
+			},
+		},
+	}
+}
+
+// tutorialFlex 建立新手教學的 carousel，每一頁介紹一個功能並提供試用的按鈕。
+func tutorialFlex() *messaging_api.FlexCarousel {
+	steps := []struct {
+		image, title, text, button string
+	}{
+		{"add_card.jpg", "1. 掃描名片", "傳送名片照片，自動辨識姓名、職稱、公司與聯絡方式。", "掃描"},
+		{"query.jpg", "2. 搜尋名片", "輸入姓名、公司或「在銀行做資安的那位」這類描述就能找到聯絡人。", "搜尋"},
+		{"logo.jpeg", "3. 備註與提醒", "引用名片訊息回覆可以加備註與 #標籤，輸入「3天後提醒我聯絡王小明」設定提醒。", "說明"},
+	}
+
+	var bubbles []messaging_api.FlexBubble
+	for _, s := range steps {
+		bubbles = append(bubbles, messaging_api.FlexBubble{
+			Hero: &messaging_api.FlexImage{
+				Url:         tutorialImageURL + s.image,
+				Size:        "full",
+				AspectRatio: "20:13",
+				AspectMode:  "cover",
+			},
+			Body: &messaging_api.FlexBox{
+				Layout:  messaging_api.FlexBoxLAYOUT_VERTICAL,
+				Spacing: "sm",
+				Contents: []messaging_api.FlexComponentInterface{
+					&messaging_api.FlexText{
+						Text:   s.title,
+						Size:   "lg",
+						Weight: "bold",
+					},
+					&messaging_api.FlexText{
+						Text: s.text,
+						Size: "sm",
+						Wrap: true,
+					},
+				},
+			},
+			Footer: &messaging_api.FlexBox{
+				Layout: messaging_api.FlexBoxLAYOUT_VERTICAL,
+				Contents: []messaging_api.FlexComponentInterface{
+					&messaging_api.FlexButton{
+						Style: messaging_api.FlexButtonSTYLE_PRIMARY,
+						Action: &messaging_api.MessageAction{
+							Label: s.button,
+							Text:  s.button,
+						},
+					},
+				},
+			},
+		})
+	}
+	return &messaging_api.FlexCarousel{Contents: bubbles}
+}
+
+// groupSearchKeyword 取出群組訊息中「找 王小明」的關鍵字，前綴後面需要空白，沒有搜尋前綴時 ok 為 false。
+func groupSearchKeyword(text string) (string, bool) {
+	prefix, keyword, ok := strings.Cut(strings.TrimSpace(text), " ")
+	if !ok {
+		prefix, keyword, ok = strings.Cut(strings.TrimSpace(text), "　")
+	}
+	keyword = strings.TrimSpace(keyword)
+	if !ok || keyword == "" {
+		return "", false
+	}
+	for _, p := range groupSearchPrefixes {
+		if prefix == p {
+			return keyword, true
+		}
+	}
+	return "", false
+}
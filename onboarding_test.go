@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConsentStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "consents.json")
+	s, err := NewConsentStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Declined("U1") {
+		t.Error("Declined() = true for a user who never answered")
+	}
+	if err := s.Set("U1", false, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set("U2", true, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重新載入後紀錄仍然存在
+	s, err = NewConsentStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Declined("U1") {
+		t.Error("Declined(U1) = false, want true")
+	}
+	if s.Declined("U2") {
+		t.Error("Declined(U2) = true, want false")
+	}
+}
+
+func TestGroupSearchKeyword(t *testing.T) {
+	tests := []struct {
+		text, want string
+		ok         bool
+	}{
+		{"找 王小明", "王小明", true},
+		{"搜尋　#客戶", "#客戶", true},
+		{"找時間吃飯", "", false},
+		{"找", "", false},
+		{"明天見", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := groupSearchKeyword(tt.text)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("groupSearchKeyword(%q) = %q, %v, want %q, %v", tt.text, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestTutorialFlex(t *testing.T) {
+	for _, b := range tutorialFlex().Contents {
+		if b.Hero == nil || b.Footer == nil {
+			t.Errorf("tutorial bubble is missing hero or footer: %+v", b)
+		}
+	}
+}
+
+func TestWebhookGroupNoteTargetIsSender(t *testing.T) {
+	h := newWebhookHarness(t)
+	p := h.AddContact("G1", Person{Name: "王小明"})
+
+	// U1 按下「新增備註」後，其他成員的一般聊天不會被當作備註
+	h.Post(h.Postback(groupSource("G1", "U1"), "action=note&page="+p.PageID))
+	h.Post(h.Text(groupSource("G1", "U2"), "晚上一起吃飯"))
+	h.Post(h.Text(groupSource("G1", "U1"), "對報價有興趣"))
+	person, err := h.Notion.NotionDB("db", "G1").GetPage(context.Background(), p.PageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(person.Notes, "對報價有興趣") || strings.Contains(person.Notes, "吃飯") {
+		t.Errorf("notes = %q, want only the sender's note", person.Notes)
+	}
+}
+
+func TestWebhookGroupSearchFallsBackToSenderBook(t *testing.T) {
+	h := newWebhookHarness(t)
+	// 群組名片簿推出前，群組中傳送的名片存在傳送者的個人名片簿
+	h.AddContact("U1", Person{Name: "王小明", Company: "台積電"})
+
+	h.Post(h.Text(groupSource("G1", "U1"), "找 王小明"))
+	replies := h.Replies()
+	if len(replies) != 1 || !strings.Contains(replies[0], "個人名片簿") || !strings.Contains(replies[0], "王小明") {
+		t.Fatalf("replies = %s, want the sender's own contact", replies)
+	}
+
+	// 其他成員查不到 U1 個人名片簿中的名片
+	h.Post(h.Text(groupSource("G1", "U2"), "找 王小明"))
+	replies = h.Replies()
+	if len(replies) != 2 || strings.Contains(replies[1], "王小明") {
+		t.Errorf("reply to another member = %s", replies[1])
+	}
+}
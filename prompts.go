@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/webhook"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// CardPromptName 是名片辨識提示的名稱，語言版本為 card-<語言>，例如 card-ja、card-en。
+const CardPromptName = "card"
+
+// cardPromptFields 是名片辨識要輸出的 JSON 欄位，對應 Person 的欄位。
+var cardPromptFields = []string{"Name", "Title", "Address", "Email", "Phone", "Company"}
+
+// PromptData 是提示模板可以使用的資料。
+type PromptData struct {
+	Language string   // 用戶在 LINE 設定的語言，例如 ja、en、zh-Hant，無法取得時為空字串
+	Fields   []string // 名片要輸出的 JSON 欄位
+}
+
+// Prompt 是選定的名片辨識提示。Version 為「名稱@版本」，新增名片時寫入 PromptVersion 欄位，方便追查辨識結果變差是否因為提示改變。
+type Prompt struct {
+	Version string
+	Text    string
+}
+
+// promptFuncs 是提示模板可以使用的函式。
+var promptFuncs = template.FuncMap{"join": strings.Join}
+
+// promptFilePattern 是提示模板的檔名：v<版本>.tmpl。
+var promptFilePattern = regexp.MustCompile(`^v(\d+)\.tmpl$`)
+
+// PromptRegistry 是存放在目錄中的版本化提示模板，每個提示一個子目錄，每個版本一個檔案：<dir>/<名稱>/v<版本>.tmpl。
+// 管理員可以指定用戶或群組使用的提示，沒有指定版本時使用最新版。
+type PromptRegistry struct {
+	// Fallback 是沒有符合的模板時使用的提示（CARD_PROMPT）
+	Fallback string
+
+	templates map[string]map[int]*template.Template // 名稱 -> 版本 -> 模板
+
+	mu          sync.Mutex
+	path        string
+	assignments map[string]string // 用戶或群組 ID -> 「名稱」或「名稱@v版本」
+}
+
+// LoadPromptRegistry 載入 dir 中的所有提示模板，以及存放在 assignmentsPath 的用戶與群組指定。
+// 模板有語法錯誤或無法以範例資料執行時回傳錯誤，避免錯誤的提示上線後才發現。
+func LoadPromptRegistry(dir, assignmentsPath, fallback string) (*PromptRegistry, error) {
+	r := &PromptRegistry{
+		Fallback:    fallback,
+		templates:   make(map[string]map[int]*template.Template),
+		path:        assignmentsPath,
+		assignments: make(map[string]string),
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading prompts: %w", err)
+	}
+	sample := PromptData{Language: "zh-TW", Fields: cardPromptFields}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		files, err := os.ReadDir(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("error reading prompts: %w", err)
+		}
+		for _, file := range files {
+			m := promptFilePattern.FindStringSubmatch(file.Name())
+			if m == nil {
+				continue
+			}
+			version, _ := strconv.Atoi(m[1])
+			data, err := os.ReadFile(filepath.Join(dir, name, file.Name()))
+			if err != nil {
+				return nil, fmt.Errorf("error reading prompts: %w", err)
+			}
+			tmpl, err := template.New(name).Funcs(promptFuncs).Parse(string(data))
+			if err != nil {
+				return nil, fmt.Errorf("prompt %s@v%d: %w", name, version, err)
+			}
+			if err := tmpl.Execute(&bytes.Buffer{}, sample); err != nil {
+				return nil, fmt.Errorf("prompt %s@v%d: %w", name, version, err)
+			}
+			if r.templates[name] == nil {
+				r.templates[name] = make(map[int]*template.Template)
+			}
+			r.templates[name][version] = tmpl
+		}
+	}
+	if assignmentsPath != "" {
+		if err := loadJSONFile(assignmentsPath, &r.assignments); err != nil {
+			return nil, fmt.Errorf("error loading prompt assignments: %w", err)
+		}
+	}
+	return r, nil
+}
+
+// Render 以 data 執行 ref 指定的模板，ref 為「名稱」（最新版）或「名稱@v版本」。
+func (r *PromptRegistry) Render(ref string, data PromptData) (Prompt, error) {
+	name, version, err := r.resolve(ref)
+	if err != nil {
+		return Prompt{}, err
+	}
+	var b strings.Builder
+	if err := r.templates[name][version].Execute(&b, data); err != nil {
+		return Prompt{}, fmt.Errorf("prompt %s@v%d: %w", name, version, err)
+	}
+	return Prompt{Version: fmt.Sprintf("%s@v%d", name, version), Text: strings.TrimSpace(b.String())}, nil
+}
+
+// resolve 回傳 ref 指定的模板名稱與版本。
+func (r *PromptRegistry) resolve(ref string) (string, int, error) {
+	name, v, pinned := strings.Cut(ref, "@")
+	versions := r.templates[name]
+	if len(versions) == 0 {
+		return "", 0, fmt.Errorf("unknown prompt %q", name)
+	}
+	if pinned {
+		version, err := strconv.Atoi(strings.TrimPrefix(v, "v"))
+		if err != nil || versions[version] == nil {
+			return "", 0, fmt.Errorf("unknown prompt version %q", ref)
+		}
+		return name, version, nil
+	}
+	latest := 0
+	for version := range versions {
+		if version > latest {
+			latest = version
+		}
+	}
+	return name, latest, nil
+}
+
+// Select 選擇名片辨識提示：依序為 keys（群組、用戶）中第一個有指定的提示、用戶語言的 card-<語言>、card，
+// 都沒有時使用 Fallback，版本為內容的雜湊。
+func (r *PromptRegistry) Select(language string, keys ...string) (Prompt, error) {
+	data := PromptData{Language: language, Fields: cardPromptFields}
+	var err error
+	if ref := r.Assignment(keys...); ref != "" {
+		prompt, assignErr := r.Render(ref, data)
+		if assignErr == nil {
+			return prompt, nil
+		}
+		// 指定的提示已經被刪除時改用預設的選擇方式，並回傳錯誤讓呼叫端記錄
+		err = assignErr
+	}
+
+	var refs []string
+	if lang := strings.ToLower(language); lang != "" {
+		refs = append(refs, CardPromptName+"-"+lang)
+		if primary, _, ok := strings.Cut(lang, "-"); ok {
+			refs = append(refs, CardPromptName+"-"+primary)
+		}
+	}
+	for _, ref := range append(refs, CardPromptName) {
+		if _, _, resolveErr := r.resolve(ref); resolveErr != nil {
+			continue
+		}
+		prompt, renderErr := r.Render(ref, data)
+		if renderErr != nil {
+			err = renderErr
+			continue
+		}
+		return prompt, err
+	}
+	return configPrompt(r.Fallback), err
+}
+
+// configPrompt 回傳設定檔中的提示 text，版本為內容的雜湊。
+func configPrompt(text string) Prompt {
+	sum := sha256.Sum256([]byte(text))
+	return Prompt{Version: "config@" + hex.EncodeToString(sum[:4]), Text: text}
+}
+
+// Assignment 回傳 keys 中第一個有指定提示的 ID 的指定，沒有時為空字串。
+func (r *PromptRegistry) Assignment(keys ...string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, key := range keys {
+		if ref, ok := r.assignments[key]; ok && key != "" {
+			return ref
+		}
+	}
+	return ""
+}
+
+// Assign 指定 key（用戶或群組 ID）使用的提示，ref 為空字串時恢復預設的選擇方式。
+func (r *PromptRegistry) Assign(key, ref string) error {
+	if ref != "" {
+		if _, _, err := r.resolve(ref); err != nil {
+			return err
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ref == "" {
+		delete(r.assignments, key)
+	} else {
+		r.assignments[key] = ref
+	}
+	if r.path == "" {
+		return nil
+	}
+	return saveJSONFile(r.path, r.assignments)
+}
+
+// Versions 回傳每個提示的所有版本，由舊到新排序。
+func (r *PromptRegistry) Versions() map[string][]int {
+	versions := make(map[string][]int)
+	for name, byVersion := range r.templates {
+		for version := range byVersion {
+			versions[name] = append(versions[name], version)
+		}
+		sort.Ints(versions[name])
+	}
+	return versions
+}
+
+// cardPrompt 選擇 source 的名片辨識提示：群組中先看群組的指定，再看傳送者的指定與 LINE 語言設定。
+// 沒有設定 PROMPT_DIR 時使用 CARD_PROMPT，版本和 Select 的預設提示一樣是內容的雜湊。
+func (s *Server) cardPrompt(ctx context.Context, source webhook.SourceInterface) Prompt {
+	if s.Prompts == nil {
+		return configPrompt(s.Config.CardPrompt)
+	}
+	uid := getUserID(source)
+	prompt, err := s.Prompts.Select(s.userLanguage(ctx, uid), getBookID(source), uid)
+	if err != nil {
+		loggerFrom(ctx).Warn("Error rendering assigned prompt", "err", err)
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("card.prompt", prompt.Version))
+	return prompt
+}
+
+// userLanguage 回傳用戶在 LINE 設定的語言，查詢失敗時回傳空字串。
+// 查詢失敗也會快取，避免 LINE API 有問題時每張名片都再查詢一次。
+func (s *Server) userLanguage(ctx context.Context, uid string) string {
+	if uid == "" {
+		return ""
+	}
+	if lang, ok := s.state.languages.Get(uid); ok {
+		return lang
+	}
+	profile, err := s.Bot.GetProfile(uid)
+	if err != nil {
+		loggerFrom(ctx).Warn("Error getting profile language", "err", err)
+		s.state.languages.Set(uid, "")
+		return ""
+	}
+	s.state.languages.Set(uid, profile.Language)
+	return profile.Language
+}
+
+// promptCommandPattern 是管理員的提示指令：「提示詞」列出所有提示，「提示詞 C123 card-ja」或「提示詞 C123 card-ja@v2」
+// 指定用戶或群組使用的提示，「提示詞 C123 預設」恢復預設的選擇方式。
+var promptCommandPattern = regexp.MustCompile(`^提示詞(?:\s+([A-Za-z0-9]+)\s+(\S+))?$`)
+
+// handlePromptText 處理管理員的提示指令，回傳是否已處理。不是管理員時不處理，訊息會當作一般的搜尋。
+func (s *Server) handlePromptText(ctx context.Context, replyToken, uid, text string) bool {
+	m := promptCommandPattern.FindStringSubmatch(strings.TrimSpace(text))
+	if m == nil || s.Prompts == nil || !s.Config.IsAdmin(uid) {
+		return false
+	}
+
+	var lines []string
+	if key := m[1]; key != "" {
+		ref := m[2]
+		if ref == "預設" {
+			ref = ""
+		}
+		if err := s.Prompts.Assign(key, ref); err != nil {
+			loggerFrom(ctx).Warn("Error assigning prompt", "key", key, "prompt", ref, "err", err)
+			lines = append(lines, "找不到提示 "+ref)
+		} else {
+			loggerFrom(ctx).Info("Prompt assigned", "key", key, "prompt", ref)
+			if ref == "" {
+				ref = "預設"
+			}
+			lines = append(lines, fmt.Sprintf("%s 的名片辨識提示：%s", key, ref))
+		}
+	} else {
+		lines = append(lines, "名片辨識提示：")
+		versions := s.Prompts.Versions()
+		names := make([]string, 0, len(versions))
+		for name := range versions {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			var vs []string
+			for _, v := range versions[name] {
+				vs = append(vs, "v"+strconv.Itoa(v))
+			}
+			lines = append(lines, fmt.Sprintf("・%s：%s", name, strings.Join(vs, "、")))
+		}
+		if len(names) == 0 {
+			lines = append(lines, "・沒有提示模板，使用 CARD_PROMPT")
+		}
+	}
+	if err := s.replyText(ctx, replyToken, strings.Join(lines, "\n")); err != nil {
+		loggerFrom(ctx).Error("Error replying", "err", err)
+	}
+	return true
+}
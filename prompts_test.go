@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+	"github.com/line/line-bot-sdk-go/v8/linebot/webhook"
+)
+
+// writePrompts 在暫存目錄建立提示模板，files 的 key 為「名稱/v版本.tmpl」。
+func writePrompts(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestBundledPrompts(t *testing.T) {
+	r, err := LoadPromptRegistry("prompts", "", ImagePrompt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 預設的 card 提示與原本的 ImagePrompt 相同
+	prompt, err := r.Select("zh-Hant")
+	if err != nil || prompt.Version != "card@v1" || prompt.Text != ImagePrompt {
+		t.Fatalf("Select(zh-Hant) = %+v, %v", prompt, err)
+	}
+	for lang, want := range map[string]string{"ja": "card-ja@v1", "en-US": "card-en@v1", "": "card@v1"} {
+		if prompt, _ := r.Select(lang); prompt.Version != want {
+			t.Errorf("Select(%q) = %s, want %s", lang, prompt.Version, want)
+		}
+	}
+}
+
+func TestPromptRegistry(t *testing.T) {
+	dir := writePrompts(t, map[string]string{
+		"card/v1.tmpl":    "v1: {{join .Fields \",\"}}",
+		"card/v2.tmpl":    "v2 {{.Language}}",
+		"card-ja/v1.tmpl": "日本語",
+		"card/notes.txt":  "ignored",
+	})
+	path := filepath.Join(t.TempDir(), "assignments.json")
+	r, err := LoadPromptRegistry(dir, path, "fallback")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 沒有指定版本時使用最新版
+	if prompt, _ := r.Select("ko"); prompt.Version != "card@v2" || prompt.Text != "v2 ko" {
+		t.Errorf("Select(ko) = %+v", prompt)
+	}
+	if prompt, _ := r.Render("card@v1", PromptData{Fields: cardPromptFields}); prompt.Text != "v1: Name,Title,Address,Email,Phone,Company" {
+		t.Errorf("Render(card@v1) = %+v", prompt)
+	}
+	if _, err := r.Render("card@v3", PromptData{}); err == nil {
+		t.Error("Render of a missing version succeeded")
+	}
+
+	// 群組的指定優先於用戶的指定與語言
+	if err := r.Assign("U1", "card-ja"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Assign("G1", "card@v1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Assign("G2", "card-ko"); err == nil {
+		t.Error("assigned a missing prompt")
+	}
+	if prompt, _ := r.Select("en", "G1", "U1"); prompt.Version != "card@v1" {
+		t.Errorf("group prompt = %s", prompt.Version)
+	}
+	if prompt, _ := r.Select("en", "U1", "U1"); prompt.Version != "card-ja@v1" {
+		t.Errorf("user prompt = %s", prompt.Version)
+	}
+
+	// 指定在重新載入後保留，恢復預設後依語言選擇
+	r, err = LoadPromptRegistry(dir, path, "fallback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Assignment("G1"); got != "card@v1" {
+		t.Errorf("assignment after reloading = %q", got)
+	}
+	r.Assign("G1", "")
+	if prompt, _ := r.Select("ja", "G1"); prompt.Version != "card-ja@v1" {
+		t.Errorf("prompt after reset = %s", prompt.Version)
+	}
+}
+
+func TestPromptRegistryFallback(t *testing.T) {
+	r, err := LoadPromptRegistry(writePrompts(t, map[string]string{"card-ja/v1.tmpl": "日本語"}), "", "請辨識名片")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 沒有 card 提示時使用 CARD_PROMPT，版本為內容的雜湊
+	prompt, _ := r.Select("en")
+	if prompt.Text != "請辨識名片" || !strings.HasPrefix(prompt.Version, "config@") {
+		t.Errorf("fallback = %+v", prompt)
+	}
+	r.Fallback = "請辨識這張名片"
+	if changed, _ := r.Select("en"); changed.Version == prompt.Version {
+		t.Error("fallback version did not change with CARD_PROMPT")
+	}
+}
+
+func TestLoadPromptRegistryRejectsBrokenTemplates(t *testing.T) {
+	for name, content := range map[string]string{
+		"syntax":  "{{join .Fields",
+		"execute": "{{.Company}}",
+	} {
+		dir := writePrompts(t, map[string]string{"card/v1.tmpl": content})
+		if _, err := LoadPromptRegistry(dir, "", ImagePrompt); err == nil {
+			t.Errorf("%s error was not reported", name)
+		}
+	}
+}
+
+// promptRecorder 記錄每次辨識使用的提示，再交給 next。
+type promptRecorder struct {
+	mu      sync.Mutex
+	prompts []string
+	next    CardReader
+}
+
+func (r *promptRecorder) ReadCard(ctx context.Context, imgData []byte, prompt string) (string, error) {
+	r.mu.Lock()
+	r.prompts = append(r.prompts, prompt)
+	r.mu.Unlock()
+	return r.next.ReadCard(ctx, imgData, prompt)
+}
+
+func TestWebhookPromptVersion(t *testing.T) {
+	h := newWebhookHarness(t)
+	h.Server.Config.AdminUserIDs = "Uadmin"
+	prompts, err := LoadPromptRegistry(writePrompts(t, map[string]string{
+		"card/v1.tmpl":    "中文名片",
+		"card-ja/v1.tmpl": "日本語の名刺",
+	}), "", ImagePrompt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Server.Prompts = prompts
+	reader := &promptRecorder{next: h}
+	h.Server.Cards = reader
+	h.SetCard("m1", "```json\n{\"name\":\"王小明\",\"email\":\"ming@bank.example\"}\n```")
+	h.SetCard("m2", "```json\n{\"name\":\"山田太郎\",\"email\":\"yamada@example.jp\"}\n```")
+
+	h.Post(h.Image(groupSource("G1", "U1"), "m1"))
+	// 管理員指定群組使用日文名片的提示，一般用戶的指令當作搜尋
+	h.Post(h.Text(groupSource("G1", "U1"), "提示詞 G1 card-ja"))
+	h.Post(h.Text(userSource("Uadmin"), "提示詞 G1 card-ja"))
+	h.Post(h.Image(groupSource("G1", "U1"), "m2"))
+
+	if len(reader.prompts) != 2 || reader.prompts[0] != "中文名片" || reader.prompts[1] != "日本語の名刺" {
+		t.Fatalf("prompts = %q", reader.prompts)
+	}
+	replies := h.Replies()
+	if !strings.Contains(replies[len(replies)-2], "G1 的名片辨識提示：card-ja") {
+		t.Errorf("assign reply = %s", replies[len(replies)-2])
+	}
+
+	people, err := h.Notion.NotionDB("db", "G1").QueryDatabaseByUID(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions := make(map[string]string)
+	for _, p := range people {
+		versions[p.Name] = p.PromptVersion
+	}
+	if versions["王小明"] != "card@v1" || versions["山田太郎"] != "card-ja@v1" {
+		t.Errorf("prompt versions = %v", versions)
+	}
+}
+
+func TestCardPromptWithoutRegistry(t *testing.T) {
+	s := &Server{Config: DefaultConfig(), state: newLocalState()}
+	// 沒有設定 PROMPT_DIR 時，版本和 Select 的預設提示一樣是內容的雜湊
+	prompt := s.cardPrompt(context.Background(), webhook.UserSource{UserId: "U1"})
+	if want := configPrompt(s.Config.CardPrompt); prompt != want || !strings.HasPrefix(prompt.Version, "config@") {
+		t.Errorf("cardPrompt() = %+v, want %+v", prompt, want)
+	}
+}
+
+func TestUserLanguageCachesFailures(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(srv.Close)
+	api, err := messaging_api.NewMessagingApiAPI("test-token", messaging_api.WithEndpoint(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{Bot: api, state: newLocalState()}
+	for i := 0; i < 3; i++ {
+		if lang := s.userLanguage(context.Background(), "U1"); lang != "" {
+			t.Errorf("language = %q, want empty", lang)
+		}
+	}
+	// 查詢失敗也會快取，不會每張名片都再查詢一次
+	if calls != 1 {
+		t.Errorf("GetProfile called %d times, want 1", calls)
+	}
+}
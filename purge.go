@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+const (
+	// UnfollowPurgeDelay 是封鎖 bot 後到刪除資料的緩衝期，期間重新加入好友會取消刪除。
+	UnfollowPurgeDelay = 7 * 24 * time.Hour
+	// PurgeCheckInterval 是排程器檢查到期刪除工作的間隔。
+	PurgeCheckInterval = time.Hour
+)
+
+// PurgeJob 是一項刪除用戶資料的工作。
+type PurgeJob struct {
+	UID         string    `json:"uid"`
+	Trigger     string    `json:"trigger"` // unfollow 或 command
+	RequestedAt time.Time `json:"requested_at"`
+	RunAt       time.Time `json:"run_at"`
+}
+
+// PurgeQueue 是存放於本地檔案的刪除排程，每位用戶最多一項工作，path 為空時只存在記憶體。
+type PurgeQueue struct {
+	mu    sync.Mutex
+	path  string
+	items map[string]PurgeJob
+}
+
+// NewPurgeQueue 建立刪除排程，若 path 不為空則從檔案載入尚未執行的工作。
+func NewPurgeQueue(path string) (*PurgeQueue, error) {
+	q := &PurgeQueue{path: path, items: make(map[string]PurgeJob)}
+	if path != "" {
+		if err := loadJSONFile(path, &q.items); err != nil {
+			return nil, fmt.Errorf("error loading purge jobs: %w", err)
+		}
+	}
+	return q, nil
+}
+
+// Schedule 新增或取代用戶的刪除工作。
+func (q *PurgeQueue) Schedule(job PurgeJob) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.items[job.UID] = job
+	return q.save()
+}
+
+// Cancel 取消用戶尚未執行的刪除工作，回傳是否有工作被取消。
+func (q *PurgeQueue) Cancel(uid string) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.items[uid]; !ok {
+		return false, nil
+	}
+	delete(q.items, uid)
+	return true, q.save()
+}
+
+// Due 回傳所有已到期的刪除工作。
+func (q *PurgeQueue) Due(now time.Time) []PurgeJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var jobs []PurgeJob
+	for _, job := range q.items {
+		if !job.RunAt.After(now) {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs
+}
+
+// save 將刪除排程寫入檔案，呼叫前需持有鎖。
+func (q *PurgeQueue) save() error {
+	if q.path == "" {
+		return nil
+	}
+	return saveJSONFile(q.path, q.items)
+}
+
+// AuditRecord 是一筆個資處理的稽核紀錄。用戶 ID 只保存雜湊值，刪除後無法再對應回用戶。
+type AuditRecord struct {
+	Time    time.Time `json:"time"`
+	Action  string    `json:"action"` // purge_requested、purge_scheduled、purge_canceled、purged
+	Subject string    `json:"subject"`
+	Trigger string    `json:"trigger"`
+	Pages   int       `json:"pages,omitempty"`
+	Files   int       `json:"files,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// AuditLog 將稽核紀錄以 JSON Lines 格式附加到檔案。
+type AuditLog struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewAuditLog 建立稽核紀錄，檔案會在第一次寫入時建立。
+func NewAuditLog(path string) *AuditLog {
+	return &AuditLog{path: path}
+}
+
+// Append 附加一筆稽核紀錄。
+func (a *AuditLog) Append(r AuditRecord) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(a.path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// audit 寫入稽核紀錄，未設定稽核紀錄時略過。
+func (s *Server) audit(action, uid, trigger string, result purgeResult, err error) {
+	if s.Audit == nil {
+		return
+	}
+	r := AuditRecord{
+		Time:    time.Now().UTC(),
+		Action:  action,
+		Subject: auditSubject(uid),
+		Trigger: trigger,
+		Pages:   result.Pages,
+		Files:   result.Files,
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	if err := s.Audit.Append(r); err != nil {
+		slog.Error("Error writing audit record", "err", err)
+	}
+}
+
+// auditSubject 回傳用戶 ID 的 SHA-256 雜湊。
+func auditSubject(uid string) string {
+	sum := sha256.Sum256([]byte(uid))
+	return hex.EncodeToString(sum[:])
+}
+
+// purgeResult 是刪除用戶資料的結果。
+type purgeResult struct {
+	Pages int
+	Files int
+}
+
+// purgeUserData 封存用戶在 Notion 中的所有名片、刪除保存的圖片與匯出檔，並清除本地的索引、提醒、待處理的名片、使用次數、Gemini 用量的歸屬、指定的提示與暫存狀態。
+// 部分步驟失敗時仍會繼續執行其他步驟，並回傳所有錯誤。
+func (s *Server) purgeUserData(ctx context.Context, nDB *NotionDB) (purgeResult, error) {
+	var result purgeResult
+	var errs []error
+
+	people, err := nDB.QueryDatabaseByUID(ctx)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, p := range people {
+		if p.PageID == "" {
+			continue
+		}
+		if err := nDB.ArchivePage(ctx, p.PageID); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		result.Pages++
+	}
+
+	if s.Images != nil {
+		// 舊版以 LINE ID 作為目錄名稱，一併刪除
+		for _, dir := range []string{s.blobPrefix(nDB.UID), nDB.UID} {
+			for _, prefix := range []string{"cards/", "exports/"} {
+				n, err := s.Images.DeletePrefix(ctx, prefix+dir+"/")
+				result.Files += n
+				if err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+	}
+
+	uid := nDB.UID
+	if s.Reminders != nil {
+		if _, err := s.Reminders.DeleteUser(uid); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if s.Semantic != nil {
+		if err := s.Semantic.DeleteUser(uid); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if s.Consents != nil {
+		if err := s.Consents.Delete(uid); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if s.Limiter != nil {
+		if err := s.Limiter.DeleteKey(uid); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if s.Tokens != nil {
+		if err := s.Tokens.Forget(uid); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if s.Prompts != nil {
+		if err := s.Prompts.Assign(uid, ""); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if s.CardJobs != nil {
+		if _, err := s.CardJobs.DeleteBook(uid); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	s.state.fuzzy.Invalidate(uid)
+	s.state.notes.DeleteUser(uid)
+	s.state.emails.DeleteUser(uid)
+	s.state.recent.DeleteUser(uid)
+	s.state.languages.Delete(uid)
+
+	return result, errors.Join(errs...)
+}
+
+// runPurgeJob 執行刪除工作並寫入稽核紀錄。
+func (s *Server) runPurgeJob(job PurgeJob) (purgeResult, error) {
+	// 刪除後無法再對應回用戶，log 和稽核紀錄一樣只記錄用戶 ID 的雜湊
+	ctx := withLogger(context.Background(), slog.With("job", "purge", "subject", auditSubject(job.UID)))
+	nDB := s.newNotionDB(ctx, job.UID)
+	result, err := s.purgeUserData(ctx, nDB)
+	loggerFrom(ctx).Info("Purged user data", "trigger", job.Trigger, "pages", result.Pages, "files", result.Files, "failed", err != nil)
+	s.audit("purged", job.UID, job.Trigger, result, err)
+	return result, err
+}
+
+// runPurgeScheduler 定期執行到期的刪除工作，直到 ctx 結束。失敗的工作會保留到下次重試。
+func (s *Server) runPurgeScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, job := range s.Purges.Due(now) {
+				if _, err := s.runPurgeJob(job); err != nil {
+					slog.Error("Error purging user data", "subject", auditSubject(job.UID), "err", err)
+					continue
+				}
+				if _, err := s.Purges.Cancel(job.UID); err != nil {
+					slog.Error("Error saving purge jobs", "err", err)
+				}
+			}
+		}
+	}
+}
+
+// handleUnfollow 在用戶封鎖 bot 時排定刪除資料，緩衝期內重新加入好友會取消。
+func (s *Server) handleUnfollow(uid string) {
+	if s.Purges == nil || uid == "" {
+		return
+	}
+	now := time.Now()
+	job := PurgeJob{UID: uid, Trigger: "unfollow", RequestedAt: now, RunAt: now.Add(UnfollowPurgeDelay)}
+	if err := s.Purges.Schedule(job); err != nil {
+		slog.Error("Error scheduling purge", "err", err)
+		return
+	}
+	s.audit("purge_scheduled", uid, job.Trigger, purgeResult{}, nil)
+}
+
+// cancelUnfollowPurge 在用戶重新加入好友時取消排定的刪除。
+func (s *Server) cancelUnfollowPurge(uid string) {
+	if s.Purges == nil || uid == "" {
+		return
+	}
+	canceled, err := s.Purges.Cancel(uid)
+	if err != nil {
+		slog.Error("Error canceling purge", "err", err)
+		return
+	}
+	if canceled {
+		s.audit("purge_canceled", uid, "follow", purgeResult{}, nil)
+	}
+}
+
+// replyPurgeConfirm 處理「刪除我的資料」，先請用戶確認。
+func (s *Server) replyPurgeConfirm(ctx context.Context, replyToken string, _ *NotionDB) {
+	if _, err := s.Bot.ReplyMessage(
+		&messaging_api.ReplyMessageRequest{
+			ReplyToken: replyToken,
+			Messages: []messaging_api.MessageInterface{
+				&messaging_api.TextMessage{
+					Text: "確定要刪除你的所有資料嗎？包含所有名片、備註、保存的名片照片、匯出檔與提醒，刪除後無法復原。",
+					QuickReply: &messaging_api.QuickReply{
+						Items: []messaging_api.QuickReplyItem{
+							{Type: "action", Action: &messaging_api.PostbackAction{Label: "確定刪除", Data: "action=purge&confirm=yes", DisplayText: "確定刪除我的資料"}},
+							{Type: "action", Action: &messaging_api.PostbackAction{Label: "取消", Data: "action=purge&confirm=no", DisplayText: "取消"}},
+						},
+					},
+				},
+			},
+		},
+	); err != nil {
+		loggerFrom(ctx).Error("Error replying", "err", err)
+	}
+}
+
+// handlePurgePostback 處理刪除確認，確認後在背景刪除並以 Push API 通知結果。
+func (s *Server) handlePurgePostback(ctx context.Context, replyToken, uid string, data url.Values) {
+	if data.Get("confirm") != "yes" {
+		if err := s.replyText(ctx, replyToken, "已取消，資料不會被刪除"); err != nil {
+			loggerFrom(ctx).Error("Error replying", "err", err)
+		}
+		return
+	}
+
+	s.audit("purge_requested", uid, "command", purgeResult{}, nil)
+	if err := s.replyText(ctx, replyToken, "正在刪除你的資料，完成後會通知你"); err != nil {
+		loggerFrom(ctx).Error("Error replying", "err", err)
+	}
+
+	// 背景工作在 webhook 回覆後才結束，先取得事件的 logger
+	elog := loggerFrom(ctx)
+	s.Go(func() {
+		now := time.Now()
+		result, err := s.runPurgeJob(PurgeJob{UID: uid, Trigger: "command", RequestedAt: now, RunAt: now})
+		text := fmt.Sprintf("已刪除 %d 張名片與 %d 個檔案", result.Pages, result.Files)
+		if err != nil {
+			elog.Error("Error purging user data", "err", err)
+			// 失敗的部分交給排程器重試
+			if s.Purges != nil {
+				if err := s.Purges.Schedule(PurgeJob{UID: uid, Trigger: "command", RequestedAt: now, RunAt: now}); err != nil {
+					elog.Error("Error scheduling purge", "err", err)
+				}
+			}
+			text += "，部分資料刪除失敗，稍後會自動重試"
+		}
+		if _, err := s.Bot.PushMessage(&messaging_api.PushMessageRequest{
+			To:       uid,
+			Messages: []messaging_api.MessageInterface{&messaging_api.TextMessage{Text: text}},
+		}, ""); err != nil {
+			elog.Error("Error pushing purge result", "err", err)
+		}
+	})
+}
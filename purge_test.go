@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPurgeQueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "purges.json")
+	q, err := NewPurgeQueue(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	if err := q.Schedule(PurgeJob{UID: "U1", Trigger: "unfollow", RequestedAt: now, RunAt: now.Add(UnfollowPurgeDelay)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Schedule(PurgeJob{UID: "U2", Trigger: "unfollow", RequestedAt: now, RunAt: now.Add(UnfollowPurgeDelay)}); err != nil {
+		t.Fatal(err)
+	}
+	if jobs := q.Due(now); len(jobs) != 0 {
+		t.Errorf("Due() before the grace period = %v, want none", jobs)
+	}
+
+	// 重新加入好友取消刪除
+	if canceled, err := q.Cancel("U2"); err != nil || !canceled {
+		t.Errorf("Cancel(U2) = %v, %v, want true", canceled, err)
+	}
+	if canceled, _ := q.Cancel("U3"); canceled {
+		t.Error("Cancel(U3) = true for a user without a job")
+	}
+
+	q, err = NewPurgeQueue(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs := q.Due(now.Add(UnfollowPurgeDelay))
+	if len(jobs) != 1 || jobs[0].UID != "U1" {
+		t.Errorf("Due() after the grace period = %v, want U1", jobs)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit", "audit.jsonl")
+	s := &Server{Audit: NewAuditLog(path)}
+
+	s.audit("purge_requested", "U1234", "command", purgeResult{}, nil)
+	s.audit("purged", "U1234", "command", purgeResult{Pages: 3, Files: 2}, errors.New("notion: timeout"))
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("audit log has %d lines, want 2", len(lines))
+	}
+	if strings.Contains(string(data), "U1234") {
+		t.Error("audit log contains the raw user ID")
+	}
+	if !strings.Contains(lines[1], `"pages":3`) || !strings.Contains(lines[1], `"error":"notion: timeout"`) {
+		t.Errorf("audit record = %s", lines[1])
+	}
+}
+
+func TestLocalBlobStoreDeletePrefix(t *testing.T) {
+	store := &LocalBlobStore{Dir: t.TempDir()}
+	ctx := context.Background()
+	for _, key := range []string{"cards/U1/a.jpg", "cards/U1/a-crop.jpg", "cards/U2/b.jpg"} {
+		if _, err := store.Put(ctx, key, []byte("jpeg"), "image/jpeg"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := store.DeletePrefix(ctx, "cards/U1/")
+	if err != nil || n != 2 {
+		t.Errorf("DeletePrefix() = %d, %v, want 2", n, err)
+	}
+	if _, err := os.Stat(filepath.Join(store.Dir, "cards/U2/b.jpg")); err != nil {
+		t.Error("DeletePrefix() removed another user's file")
+	}
+	if n, err := store.DeletePrefix(ctx, "exports/U1/"); err != nil || n != 0 {
+		t.Errorf("DeletePrefix() on a missing prefix = %d, %v", n, err)
+	}
+}
+
+func TestS3BlobStoreDeletePrefix(t *testing.T) {
+	var deleted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			if r.URL.Path != "/bucket" || r.URL.Query().Get("prefix") != "cards/U1/" {
+				t.Errorf("list request = %s", r.URL)
+			}
+			// 第一頁回傳一個檔案與 continuation token
+			if r.URL.Query().Get("continuation-token") == "" {
+				fmt.Fprint(w, `<ListBucketResult><Contents><Key>cards/U1/a.jpg</Key></Contents><IsTruncated>true</IsTruncated><NextContinuationToken>next</NextContinuationToken></ListBucketResult>`)
+				return
+			}
+			fmt.Fprint(w, `<ListBucketResult><Contents><Key>cards/U1/b.jpg</Key></Contents><IsTruncated>false</IsTruncated></ListBucketResult>`)
+		case http.MethodDelete:
+			deleted = append(deleted, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+
+	store := &S3BlobStore{Endpoint: srv.URL, Region: "auto", Bucket: "bucket", AccessKey: "AKID", SecretKey: "secret"}
+	n, err := store.DeletePrefix(context.Background(), "cards/U1/")
+	if err != nil || n != 2 {
+		t.Fatalf("DeletePrefix() = %d, %v, want 2", n, err)
+	}
+	if strings.Join(deleted, ",") != "/bucket/cards/U1/a.jpg,/bucket/cards/U1/b.jpg" {
+		t.Errorf("deleted = %v", deleted)
+	}
+}
+
+func TestDeleteUserLocalState(t *testing.T) {
+	store, _ := NewReminderStore("")
+	store.Add(Reminder{UID: "U1", Text: "a", DueAt: time.Now()})
+	store.Add(Reminder{UID: "U2", Text: "b", DueAt: time.Now()})
+	if n, err := store.DeleteUser("U1"); err != nil || n != 1 {
+		t.Errorf("ReminderStore.DeleteUser() = %d, %v, want 1", n, err)
+	}
+	if len(store.List("U2")) != 1 {
+		t.Error("ReminderStore.DeleteUser() removed another user's reminder")
+	}
+
+	index, _ := NewSemanticIndex(fakeEmbedder{}, "", nil)
+	index.MinScore = 0
+	ctx := context.Background()
+	index.Index(ctx, "U1", Person{Name: "王小明", Email: "a@example.com", PageID: "p1"})
+	index.Index(ctx, "U2", Person{Name: "李大華", Email: "b@example.com", PageID: "p2"})
+	if err := index.DeleteUser("U1"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := index.Search(ctx, "U1", "王小明", 5); len(got) != 0 {
+		t.Errorf("Search() after DeleteUser() = %v, want none", got)
+	}
+	if got, _ := index.Search(ctx, "U2", "李大華", 5); len(got) != 1 {
+		t.Errorf("Search() for another user = %v, want 1 result", got)
+	}
+}
+
+func TestRunPurgeJobLogsHashedSubject(t *testing.T) {
+	h := newWebhookHarness(t)
+	h.AddContact("U1", Person{Name: "王小明"})
+
+	var buf bytes.Buffer
+	saved := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(saved) })
+
+	if result, err := h.Server.runPurgeJob(PurgeJob{UID: "U1", Trigger: "command"}); err != nil || result.Pages != 1 {
+		t.Fatalf("runPurgeJob() = %+v, %v", result, err)
+	}
+	// 刪除工作的 log 只有用戶 ID 的雜湊
+	if strings.Contains(buf.String(), "U1") || !strings.Contains(buf.String(), auditSubject("U1")) {
+		t.Errorf("log = %s, want only the hashed subject", buf.String())
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/webhook"
+)
+
+// UsageKind 是限制次數的操作種類。
+type UsageKind string
+
+const (
+	// UsageScan 是名片掃描，每次都會呼叫 Gemini 辨識。
+	UsageScan UsageKind = "scan"
+	// UsageSearch 是關鍵字搜尋，沒有結果時會以 Gemini 進行語意搜尋。
+	UsageSearch UsageKind = "search"
+)
+
+// usageKindNames 是指令與回覆中使用的操作名稱。
+var usageKindNames = map[UsageKind]string{UsageScan: "掃描", UsageSearch: "搜尋"}
+
+// Limit 是一種操作的限制：PerMinute 是每分鐘的次數，Daily 是每天的配額，0 表示不限制。
+type Limit struct {
+	PerMinute int
+	Daily     int
+}
+
+// LimitError 是超過頻率限制或每日配額時的錯誤，Error 回傳可以直接回覆用戶的訊息。
+type LimitError struct {
+	Kind       UsageKind
+	Key        string
+	Daily      bool          // true 表示超過每日配額，false 表示太頻繁
+	Limit      int           // 超過的次數上限
+	RetryAfter time.Duration // 可以再使用的等待時間
+}
+
+func (e *LimitError) Error() string {
+	name := usageKindNames[e.Kind]
+	if e.Daily && e.Limit == 0 {
+		return fmt.Sprintf("目前無法使用%s功能，請聯絡管理員。", name)
+	}
+	if e.Daily {
+		return fmt.Sprintf("今天的%s次數已經用完了（每天 %d 次），明天再來試試吧！", name, e.Limit)
+	}
+	seconds := int(e.RetryAfter.Round(time.Second) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return fmt.Sprintf("%s太頻繁了（每分鐘最多 %d 次），請 %d 秒後再試一次。", name, e.Limit, seconds)
+}
+
+// Limiter 以用戶 ID 與群組 ID 限制掃描與搜尋的次數。
+type Limiter interface {
+	// Allow 在每個 key 都沒有超過限制時記錄一次 kind 的使用，否則回傳 *LimitError 且不記錄。
+	Allow(kind UsageKind, now time.Time, keys ...string) error
+	// SetQuota 設定 key 的每日配額，取代預設的 Daily。quota 為 0 時停用，小於 0 時恢復預設值。
+	SetQuota(key string, kind UsageKind, quota int) error
+	// Usage 回傳 key 今天使用的次數與每日配額，配額小於 0 表示不限制。
+	Usage(key string, kind UsageKind, now time.Time) (used, quota int)
+	// DeleteKey 刪除 key 的使用次數與管理員設定的配額。
+	DeleteKey(key string) error
+}
+
+// usageCounter 是一個 key 的一種操作的使用次數。
+type usageCounter struct {
+	WindowStart time.Time `json:"window_start"`
+	Window      int       `json:"window"`
+	Day         string    `json:"day"`
+	Daily       int       `json:"daily"`
+}
+
+// usageState 是 Limiter 需要保存的狀態。
+type usageState struct {
+	Counters map[string]*usageCounter     `json:"counters"` // "key/kind" -> 使用次數
+	Quotas   map[string]map[UsageKind]int `json:"quotas"`   // 管理員設定的每日配額
+}
+
+// MemoryLimiter 是存在記憶體的 Limiter，重新啟動後次數會歸零。每日配額依照台北時間換日。
+type MemoryLimiter struct {
+	mu     sync.Mutex
+	limits map[UsageKind]Limit
+	state  usageState
+	pruned string // 最後一次清除舊使用次數的日期
+}
+
+// NewMemoryLimiter 建立記憶體中的 Limiter。
+func NewMemoryLimiter(limits map[UsageKind]Limit) *MemoryLimiter {
+	return &MemoryLimiter{limits: limits, state: usageState{
+		Counters: make(map[string]*usageCounter),
+		Quotas:   make(map[string]map[UsageKind]int),
+	}}
+}
+
+// Allow 記錄一次使用。
+func (l *MemoryLimiter) Allow(kind UsageKind, now time.Time, keys ...string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.allow(kind, now, keys)
+}
+
+// allow 是 Allow 的實作，呼叫前需持有鎖。
+func (l *MemoryLimiter) allow(kind UsageKind, now time.Time, keys []string) error {
+	limit := l.limits[kind]
+	day := now.In(reminderLocation()).Format("2006-01-02")
+	if l.pruned != day {
+		l.prune(day)
+	}
+
+	var counters []*usageCounter
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		c := l.counter(key, kind)
+		if now.Sub(c.WindowStart) >= time.Minute {
+			c.WindowStart, c.Window = now, 0
+		}
+		if c.Day != day {
+			c.Day, c.Daily = day, 0
+		}
+		if quota := l.quota(key, kind); quota >= 0 && c.Daily >= quota {
+			return &LimitError{Kind: kind, Key: key, Daily: true, Limit: quota, RetryAfter: untilTomorrow(now)}
+		}
+		if limit.PerMinute > 0 && c.Window >= limit.PerMinute {
+			return &LimitError{Kind: kind, Key: key, Limit: limit.PerMinute, RetryAfter: c.WindowStart.Add(time.Minute).Sub(now)}
+		}
+		counters = append(counters, c)
+	}
+	for _, c := range counters {
+		c.Window++
+		c.Daily++
+	}
+	return nil
+}
+
+// prune 刪除 day 之前的使用次數，每分鐘的次數在換日後也已經過期，呼叫前需持有鎖。
+func (l *MemoryLimiter) prune(day string) {
+	for id, c := range l.state.Counters {
+		if c.Day < day {
+			delete(l.state.Counters, id)
+		}
+	}
+	l.pruned = day
+}
+
+// SetQuota 設定每日配額。
+func (l *MemoryLimiter) SetQuota(key string, kind UsageKind, quota int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.setQuota(key, kind, quota)
+	return nil
+}
+
+// setQuota 是 SetQuota 的實作，呼叫前需持有鎖。
+func (l *MemoryLimiter) setQuota(key string, kind UsageKind, quota int) {
+	if quota < 0 {
+		delete(l.state.Quotas[key], kind)
+		if len(l.state.Quotas[key]) == 0 {
+			delete(l.state.Quotas, key)
+		}
+		return
+	}
+	if l.state.Quotas[key] == nil {
+		l.state.Quotas[key] = make(map[UsageKind]int)
+	}
+	l.state.Quotas[key][kind] = quota
+}
+
+// Usage 回傳今天的使用次數與每日配額。
+func (l *MemoryLimiter) Usage(key string, kind UsageKind, now time.Time) (int, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	used := 0
+	if c, ok := l.state.Counters[key+"/"+string(kind)]; ok && c.Day == now.In(reminderLocation()).Format("2006-01-02") {
+		used = c.Daily
+	}
+	return used, l.quota(key, kind)
+}
+
+// DeleteKey 刪除 key 的使用次數與配額。
+func (l *MemoryLimiter) DeleteKey(key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.deleteKey(key)
+	return nil
+}
+
+// deleteKey 是 DeleteKey 的實作，呼叫前需持有鎖。
+func (l *MemoryLimiter) deleteKey(key string) {
+	for kind := range usageKindNames {
+		delete(l.state.Counters, key+"/"+string(kind))
+	}
+	delete(l.state.Quotas, key)
+}
+
+// counter 回傳 key 的使用次數，沒有時建立，呼叫前需持有鎖。
+func (l *MemoryLimiter) counter(key string, kind UsageKind) *usageCounter {
+	id := key + "/" + string(kind)
+	c, ok := l.state.Counters[id]
+	if !ok {
+		c = &usageCounter{}
+		l.state.Counters[id] = c
+	}
+	return c
+}
+
+// quota 回傳 key 的每日配額，管理員沒有設定時為預設值，-1 表示不限制，呼叫前需持有鎖。
+// 管理員設定的 0 表示停用，預設值的 0 表示不限制。
+func (l *MemoryLimiter) quota(key string, kind UsageKind) int {
+	if quota, ok := l.state.Quotas[key][kind]; ok {
+		return quota
+	}
+	if daily := l.limits[kind].Daily; daily > 0 {
+		return daily
+	}
+	return -1
+}
+
+// untilTomorrow 回傳到台北時間隔天零點的時間。
+func untilTomorrow(now time.Time) time.Duration {
+	t := now.In(reminderLocation())
+	return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location()).Sub(now)
+}
+
+// FileLimiter 是存放於本地檔案的 Limiter，重新啟動後仍保留今天的使用次數與管理員設定的配額。
+type FileLimiter struct {
+	MemoryLimiter
+	path string
+}
+
+// NewFileLimiter 建立存放在 path 的 Limiter，並從檔案載入使用次數與配額。
+func NewFileLimiter(path string, limits map[UsageKind]Limit) (*FileLimiter, error) {
+	l := &FileLimiter{MemoryLimiter: MemoryLimiter{limits: limits}, path: path}
+	if err := loadJSONFile(path, &l.state); err != nil {
+		return nil, fmt.Errorf("error loading usage: %w", err)
+	}
+	if l.state.Counters == nil {
+		l.state.Counters = make(map[string]*usageCounter)
+	}
+	if l.state.Quotas == nil {
+		l.state.Quotas = make(map[string]map[UsageKind]int)
+	}
+	return l, nil
+}
+
+// Allow 記錄一次使用並寫入檔案。
+func (l *FileLimiter) Allow(kind UsageKind, now time.Time, keys ...string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.allow(kind, now, keys); err != nil {
+		return err
+	}
+	return saveJSONFile(l.path, l.state)
+}
+
+// SetQuota 設定每日配額並寫入檔案。
+func (l *FileLimiter) SetQuota(key string, kind UsageKind, quota int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.setQuota(key, kind, quota)
+	return saveJSONFile(l.path, l.state)
+}
+
+// DeleteKey 刪除使用次數與配額並寫入檔案。
+func (l *FileLimiter) DeleteKey(key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.deleteKey(key)
+	return saveJSONFile(l.path, l.state)
+}
+
+// Limits 回傳設定的掃描與搜尋限制。
+func (c *Config) Limits() map[UsageKind]Limit {
+	return map[UsageKind]Limit{
+		UsageScan:   {PerMinute: c.ScanRateLimit, Daily: c.ScanDailyQuota},
+		UsageSearch: {PerMinute: c.SearchRateLimit, Daily: c.SearchDailyQuota},
+	}
+}
+
+// IsAdmin 回傳 uid 是否在 ADMIN_USER_IDS 中。
+func (c *Config) IsAdmin(uid string) bool {
+	if uid == "" {
+		return false
+	}
+	for _, id := range c.Admins() {
+		if id == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// Admins 回傳 ADMIN_USER_IDS 中的管理員 ID。
+func (c *Config) Admins() []string {
+	var ids []string
+	for _, id := range strings.Split(c.AdminUserIDs, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// allowUsage 以用戶 ID 與群組 ID 檢查 kind 的使用次數，超過限制時回覆用戶並回傳 false。
+// 沒有設定 Limiter 時不限制，記錄失敗時照常處理。
+func (s *Server) allowUsage(ctx context.Context, replyToken string, kind UsageKind, source webhook.SourceInterface) bool {
+	if s.Limiter == nil {
+		return true
+	}
+	keys := []string{getUserID(source)}
+	if isGroupSource(source) {
+		keys = append(keys, getBookID(source))
+	}
+	err := s.Limiter.Allow(kind, time.Now(), keys...)
+	if err == nil {
+		return true
+	}
+	limitErr, ok := err.(*LimitError)
+	if !ok {
+		loggerFrom(ctx).Error("Error recording usage", "err", err)
+		return true
+	}
+	reason := "rate"
+	if limitErr.Daily {
+		reason = "quota"
+	}
+	loggerFrom(ctx).Info("Usage limited", "kind", kind, "key", limitErr.Key, "reason", reason)
+	s.Metrics.UsageLimited(kind, reason)
+	if err := s.replyText(ctx, replyToken, limitErr.Error()); err != nil {
+		loggerFrom(ctx).Error("Error replying", "err", err)
+	}
+	return false
+}
+
+// quotaCommandPattern 是管理員的配額指令：「配額 U123」查詢，「配額 U123 掃描 100」設定（0 為停用），「配額 U123 掃描 預設」恢復預設值。
+var quotaCommandPattern = regexp.MustCompile(`^配額\s+([A-Za-z0-9]+)(?:\s+(掃描|搜尋)\s+(\d+|預設))?$`)
+
+// handleQuotaText 處理管理員的配額指令，回傳是否已處理。不是管理員時不處理，訊息會當作一般的搜尋。
+func (s *Server) handleQuotaText(ctx context.Context, replyToken, uid, text string) bool {
+	m := quotaCommandPattern.FindStringSubmatch(strings.TrimSpace(text))
+	if m == nil || s.Limiter == nil || !s.Config.IsAdmin(uid) {
+		return false
+	}
+	key := m[1]
+	if m[2] != "" {
+		kind := UsageScan
+		if m[2] == "搜尋" {
+			kind = UsageSearch
+		}
+		quota := -1
+		if m[3] != "預設" {
+			quota, _ = strconv.Atoi(m[3])
+		}
+		if err := s.Limiter.SetQuota(key, kind, quota); err != nil {
+			loggerFrom(ctx).Error("Error saving quota", "err", err)
+		}
+		loggerFrom(ctx).Info("Quota changed", "key", key, "kind", kind, "quota", quota)
+	}
+
+	lines := []string{key + " 今天的使用量："}
+	now := time.Now()
+	for _, kind := range []UsageKind{UsageScan, UsageSearch} {
+		used, quota := s.Limiter.Usage(key, kind, now)
+		limit := "不限"
+		switch {
+		case quota == 0:
+			limit = "0（停用）"
+		case quota > 0:
+			limit = strconv.Itoa(quota)
+		}
+		lines = append(lines, fmt.Sprintf("・%s：%d / %s", usageKindNames[kind], used, limit))
+	}
+	if err := s.replyText(ctx, replyToken, strings.Join(lines, "\n")); err != nil {
+		loggerFrom(ctx).Error("Error replying", "err", err)
+	}
+	return true
+}
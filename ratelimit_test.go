@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryLimiter(t *testing.T) {
+	l := NewMemoryLimiter(map[UsageKind]Limit{
+		UsageScan:   {PerMinute: 2, Daily: 3},
+		UsageSearch: {PerMinute: 1},
+	})
+	// 台北時間 2026-05-01 23:58
+	now := time.Date(2026, 5, 1, 15, 58, 0, 0, time.UTC)
+
+	for i := 0; i < 2; i++ {
+		if err := l.Allow(UsageScan, now, "U1"); err != nil {
+			t.Fatalf("scan %d: %v", i+1, err)
+		}
+	}
+	var limitErr *LimitError
+	if err := l.Allow(UsageScan, now.Add(10*time.Second), "U1"); !errors.As(err, &limitErr) || limitErr.Daily || limitErr.RetryAfter != 50*time.Second {
+		t.Fatalf("third scan in a minute = %#v, want rate limit for 50s", err)
+	}
+	// 掃描與搜尋、不同用戶分開計算
+	if err := l.Allow(UsageSearch, now, "U1"); err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if err := l.Allow(UsageScan, now, "U2"); err != nil {
+		t.Fatalf("other user: %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	if err := l.Allow(UsageScan, now, "U1"); err != nil {
+		t.Fatalf("scan after a minute: %v", err)
+	}
+	if err := l.Allow(UsageScan, now.Add(30*time.Second), "U1"); !errors.As(err, &limitErr) || !limitErr.Daily || limitErr.Limit != 3 {
+		t.Fatalf("fourth scan = %#v, want daily quota", err)
+	}
+	if used, quota := l.Usage("U1", UsageScan, now); used != 3 || quota != 3 {
+		t.Errorf("Usage = %d/%d, want 3/3", used, quota)
+	}
+
+	// 台北時間換日後配額重新計算
+	if err := l.Allow(UsageScan, now.Add(5*time.Minute), "U1"); err != nil {
+		t.Fatalf("scan on the next day: %v", err)
+	}
+}
+
+func TestMemoryLimiterGroup(t *testing.T) {
+	l := NewMemoryLimiter(map[UsageKind]Limit{UsageScan: {Daily: 2}})
+	now := time.Date(2026, 5, 1, 9, 0, 0, 0, time.UTC)
+
+	// 群組的配額由所有成員共用
+	if err := l.Allow(UsageScan, now, "U1", "G1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Allow(UsageScan, now, "U2", "G1"); err != nil {
+		t.Fatal(err)
+	}
+	var limitErr *LimitError
+	if err := l.Allow(UsageScan, now, "U3", "G1"); !errors.As(err, &limitErr) || limitErr.Key != "G1" {
+		t.Fatalf("third scan in group = %v, want the group quota", err)
+	}
+	// 被拒絕的使用不計入用戶的次數
+	if used, _ := l.Usage("U3", UsageScan, now); used != 0 {
+		t.Errorf("rejected scan was counted: %d", used)
+	}
+
+	// 管理員提高群組的配額
+	l.SetQuota("G1", UsageScan, 10)
+	if err := l.Allow(UsageScan, now, "U3", "G1"); err != nil {
+		t.Fatalf("scan after raising the quota: %v", err)
+	}
+	l.SetQuota("G1", UsageScan, -1)
+	if _, quota := l.Usage("G1", UsageScan, now); quota != 2 {
+		t.Errorf("quota after reset = %d, want the default 2", quota)
+	}
+}
+
+func TestMemoryLimiterBlockedQuota(t *testing.T) {
+	// 預設的每日配額 0 表示不限制
+	l := NewMemoryLimiter(map[UsageKind]Limit{UsageScan: {}})
+	now := time.Date(2026, 5, 1, 9, 0, 0, 0, time.UTC)
+	if _, quota := l.Usage("U1", UsageScan, now); quota >= 0 {
+		t.Errorf("default quota = %d, want unlimited", quota)
+	}
+
+	// 管理員設定的 0 表示停用
+	l.SetQuota("U1", UsageScan, 0)
+	var limitErr *LimitError
+	if err := l.Allow(UsageScan, now, "U1"); !errors.As(err, &limitErr) || !limitErr.Daily || limitErr.Limit != 0 {
+		t.Fatalf("scan with quota 0 = %v, want blocked", err)
+	}
+	if !strings.Contains(limitErr.Error(), "無法使用") {
+		t.Errorf("message = %q", limitErr.Error())
+	}
+
+	// 恢復預設值後不再限制
+	l.SetQuota("U1", UsageScan, -1)
+	if err := l.Allow(UsageScan, now, "U1"); err != nil {
+		t.Errorf("scan after reset: %v", err)
+	}
+}
+
+func TestMemoryLimiterPrunesAndDeletes(t *testing.T) {
+	l := NewMemoryLimiter(map[UsageKind]Limit{UsageScan: {Daily: 5}})
+	now := time.Date(2026, 5, 1, 9, 0, 0, 0, time.UTC)
+	l.Allow(UsageScan, now, "U1")
+	l.Allow(UsageScan, now, "U2")
+	l.SetQuota("U2", UsageScan, 10)
+
+	// 換日後第一次使用時刪除前一天的次數
+	l.Allow(UsageScan, now.Add(24*time.Hour), "U2")
+	if _, ok := l.state.Counters["U1/scan"]; ok || len(l.state.Counters) != 1 {
+		t.Errorf("counters = %v, want only today's", l.state.Counters)
+	}
+
+	// 刪除用戶的資料時一併刪除次數與配額
+	if err := l.DeleteKey("U2"); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.state.Counters) != 0 || len(l.state.Quotas) != 0 {
+		t.Errorf("state after DeleteKey = %+v", l.state)
+	}
+}
+
+func TestFileLimiterPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	limits := map[UsageKind]Limit{UsageScan: {Daily: 5}}
+	now := time.Date(2026, 5, 1, 9, 0, 0, 0, time.UTC)
+
+	l, err := NewFileLimiter(path, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Allow(UsageScan, now, "U1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.SetQuota("U1", UsageScan, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err = NewFileLimiter(path, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used, quota := l.Usage("U1", UsageScan, now); used != 1 || quota != 1 {
+		t.Errorf("Usage after reopening = %d/%d, want 1/1", used, quota)
+	}
+	if err := l.Allow(UsageScan, now, "U1"); err == nil {
+		t.Error("quota was not kept after reopening")
+	}
+}
+
+func TestWebhookUsageLimits(t *testing.T) {
+	h := newWebhookHarness(t)
+	h.Server.Limiter = NewMemoryLimiter(map[UsageKind]Limit{UsageScan: {Daily: 1}, UsageSearch: {PerMinute: 1}})
+	h.Server.Config.AdminUserIDs = "Uadmin"
+	h.SetCard("m1", "```json\n{\"name\":\"王小明\",\"email\":\"ming@bank.example\"}\n```")
+	h.SetCard("m2", "```json\n{\"name\":\"陳大文\",\"email\":\"david@bank.example\"}\n```")
+
+	h.Post(h.Image(userSource("U1"), "m1"))
+	h.Post(h.Image(userSource("U1"), "m2"))
+	h.Post(h.Text(userSource("U1"), "王小明"))
+	h.Post(h.Text(userSource("U1"), "陳大文"))
+
+	replies := h.Replies()
+	if len(replies) != 4 {
+		t.Fatalf("replies = %d, want 4", len(replies))
+	}
+	if !strings.Contains(replies[1], "今天的掃描次數已經用完了") {
+		t.Errorf("second scan reply = %s", replies[1])
+	}
+	if !strings.Contains(replies[3], "搜尋太頻繁了") {
+		t.Errorf("second search reply = %s", replies[3])
+	}
+
+	// 管理員提高配額後可以繼續掃描，一般用戶的配額指令當作搜尋
+	h.Post(h.Text(userSource("U1"), "配額 U1 掃描 5"))
+	if _, quota := h.Server.Limiter.Usage("U1", UsageScan, time.Now()); quota != 1 {
+		t.Fatalf("non-admin changed the quota to %d", quota)
+	}
+	h.Post(h.Text(userSource("Uadmin"), "配額 U1 掃描 5"))
+	if replies := h.Replies(); !strings.Contains(replies[len(replies)-1], "掃描：1 / 5") {
+		t.Errorf("quota reply = %s", replies[len(replies)-1])
+	}
+	h.Post(h.Image(userSource("U1"), "m2"))
+	if replies := h.Replies(); !strings.Contains(replies[len(replies)-1], "新增到資料庫") {
+		t.Errorf("scan after raising the quota = %s", replies[len(replies)-1])
+	}
+}
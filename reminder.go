@@ -0,0 +1,530 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+const (
+	// ReminderCheckInterval 是排程器檢查到期提醒的間隔。
+	ReminderCheckInterval = time.Minute
+	// ReminderMaxAttempts 是提醒最多嘗試送出的次數，之後放棄這則提醒。
+	ReminderMaxAttempts = 8
+)
+
+// Reminder 是一則提醒，可以選擇性地對應到一張名片。
+type Reminder struct {
+	ID          string    `json:"id"`
+	UID         string    `json:"uid"`
+	PageID      string    `json:"page_id,omitempty"`
+	Text        string    `json:"text"`
+	DueAt       time.Time `json:"due_at"`
+	DeliveredAt time.Time `json:"delivered_at,omitempty"`
+	// Owner 是新增提醒的用戶（見 sessionKey），只有他可以查看、延後與取消；舊的紀錄沒有時為 UID
+	Owner string `json:"owner,omitempty"`
+
+	// 送出失敗的次數與下一次嘗試的時間，延後提醒時重新計算
+	Attempts int       `json:"attempts,omitempty"`
+	RetryAt  time.Time `json:"retry_at,omitempty"`
+}
+
+// owner 回傳可以操作這則提醒的用戶。
+func (r Reminder) owner() string {
+	if r.Owner != "" {
+		return r.Owner
+	}
+	return r.UID
+}
+
+// recipient 回傳提醒要推播給的用戶 ID。群組中新增的提醒只推播給新增提醒的成員，不推播到群組。
+func (r Reminder) recipient() string {
+	owner := r.owner()
+	if i := strings.LastIndex(owner, "/"); i >= 0 {
+		return owner[i+1:]
+	}
+	return owner
+}
+
+// managedBy 回傳 owner 是否可以延後或取消這則提醒。推播給個人的群組提醒也可以在一對一聊天中操作。
+func (r Reminder) managedBy(owner string) bool {
+	return owner == r.owner() || owner == r.recipient()
+}
+
+// ReminderStore 是存放於本地檔案的提醒儲存，path 為空時只存在記憶體。
+type ReminderStore struct {
+	mu    sync.Mutex
+	path  string
+	items map[string]Reminder
+}
+
+// NewReminderStore 建立提醒儲存，若 path 不為空則從檔案載入既有的提醒。
+func NewReminderStore(path string) (*ReminderStore, error) {
+	s := &ReminderStore{path: path, items: make(map[string]Reminder)}
+	if path != "" {
+		if err := loadJSONFile(path, &s.items); err != nil {
+			return nil, fmt.Errorf("error loading reminders: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// Add 新增一則提醒並回傳含有 ID 的提醒。
+func (s *ReminderStore) Add(r Reminder) (Reminder, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return r, err
+	}
+	r.ID = hex.EncodeToString(id)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[r.ID] = r
+	return r, s.save()
+}
+
+// List 回傳用戶 owner 尚未送出的提醒，依到期時間排序。
+func (s *ReminderStore) List(owner string) []Reminder {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var list []Reminder
+	for _, r := range s.items {
+		if r.owner() == owner && r.DeliveredAt.IsZero() {
+			list = append(list, r)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].DueAt.Before(list[j].DueAt) })
+	return list
+}
+
+// Due 回傳所有已到期但尚未送出的提醒。
+func (s *ReminderStore) Due(now time.Time) []Reminder {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var list []Reminder
+	for _, r := range s.items {
+		if r.DeliveredAt.IsZero() && !r.DueAt.After(now) && !r.RetryAt.After(now) {
+			list = append(list, r)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].DueAt.Before(list[j].DueAt) })
+	return list
+}
+
+// MarkDelivered 標記提醒已送出。送出超過一週的提醒會被清除。
+func (s *ReminderStore) MarkDelivered(id string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.items[id]; ok {
+		r.DeliveredAt = now
+		s.items[id] = r
+	}
+	for k, r := range s.items {
+		if !r.DeliveredAt.IsZero() && now.Sub(r.DeliveredAt) > 7*24*time.Hour {
+			delete(s.items, k)
+		}
+	}
+	return s.save()
+}
+
+// Retry 記錄提醒送出失敗，並在 at 再次嘗試。
+func (s *ReminderStore) Retry(id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.items[id]
+	if !ok {
+		return nil
+	}
+	r.Attempts++
+	r.RetryAt = at
+	s.items[id] = r
+	return s.save()
+}
+
+// Remove 移除無法送出的提醒。
+func (s *ReminderStore) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.items, id)
+	return s.save()
+}
+
+// Snooze 將用戶的提醒延後到指定時間，已送出的提醒也可以再次延後。
+func (s *ReminderStore) Snooze(owner, id string, dueAt time.Time) (Reminder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.items[id]
+	if !ok || !r.managedBy(owner) {
+		return r, fmt.Errorf("reminder %s not found", id)
+	}
+	r.DueAt = dueAt
+	r.DeliveredAt = time.Time{}
+	r.Attempts, r.RetryAt = 0, time.Time{}
+	s.items[id] = r
+	return r, s.save()
+}
+
+// Cancel 取消用戶的提醒。
+func (s *ReminderStore) Cancel(owner, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.items[id]
+	if !ok || !r.managedBy(owner) {
+		return fmt.Errorf("reminder %s not found", id)
+	}
+	delete(s.items, id)
+	return s.save()
+}
+
+// DeleteUser 刪除用戶名片簿的提醒，以及用戶在群組中新增的提醒，回傳刪除的數量。
+func (s *ReminderStore) DeleteUser(uid string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for id, r := range s.items {
+		if r.UID == uid || r.Owner == uid || strings.HasSuffix(r.Owner, "/"+uid) {
+			delete(s.items, id)
+			n++
+		}
+	}
+	return n, s.save()
+}
+
+// save 將提醒寫入檔案，呼叫前需持有鎖。
+func (s *ReminderStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	return saveJSONFile(s.path, s.items)
+}
+
+// reminderRe 比對「3天後提醒我聯絡王小明」、「明天提醒我回信」等提醒指令。
+var reminderRe = regexp.MustCompile(`^(?:([0-9一二兩三四五六七八九十]+)\s*(分鐘|小時|天|週|周|星期|個月)後|(明天|後天|下週|下星期|下個月))\s*提醒我\s*(.*)$`)
+
+// reminderContactPrefixes 是提醒內容中聯絡人名字前常見的動詞。
+var reminderContactPrefixes = []string{"聯絡", "聯繫", "連絡", "打給", "回覆", "回信給", "寄信給", "約"}
+
+// parseReminder 解析提醒指令，回傳提醒時間、提醒內容與可能的聯絡人名字。
+func parseReminder(text string, now time.Time) (time.Time, string, string, bool) {
+	m := reminderRe.FindStringSubmatch(strings.TrimSpace(text))
+	if m == nil {
+		return time.Time{}, "", "", false
+	}
+
+	var due time.Time
+	if m[3] != "" {
+		switch m[3] {
+		case "明天":
+			due = now.AddDate(0, 0, 1)
+		case "後天":
+			due = now.AddDate(0, 0, 2)
+		case "下週", "下星期":
+			due = now.AddDate(0, 0, 7)
+		case "下個月":
+			due = now.AddDate(0, 1, 0)
+		}
+	} else {
+		n := parseChineseNumber(m[1])
+		if n <= 0 {
+			return time.Time{}, "", "", false
+		}
+		switch m[2] {
+		case "分鐘":
+			due = now.Add(time.Duration(n) * time.Minute)
+		case "小時":
+			due = now.Add(time.Duration(n) * time.Hour)
+		case "天":
+			due = now.AddDate(0, 0, n)
+		case "週", "周", "星期":
+			due = now.AddDate(0, 0, 7*n)
+		case "個月":
+			due = now.AddDate(0, n, 0)
+		}
+	}
+
+	content := strings.TrimSpace(m[4])
+	var name string
+	for _, prefix := range reminderContactPrefixes {
+		if strings.HasPrefix(content, prefix) {
+			name = strings.TrimSpace(strings.TrimPrefix(content, prefix))
+			break
+		}
+	}
+	return due, content, name, true
+}
+
+// parseChineseNumber 解析阿拉伯數字或一到九十九的中文數字。
+func parseChineseNumber(s string) int {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n
+	}
+	digits := map[rune]int{'一': 1, '二': 2, '兩': 2, '三': 3, '四': 4, '五': 5, '六': 6, '七': 7, '八': 8, '九': 9}
+	n, cur := 0, 0
+	for _, r := range s {
+		if r == '十' {
+			if cur == 0 {
+				cur = 1
+			}
+			n += cur * 10
+			cur = 0
+			continue
+		}
+		d, ok := digits[r]
+		if !ok {
+			return 0
+		}
+		cur = d
+	}
+	return n + cur
+}
+
+// parseAfter 解析 postback 中的延後時間，例如 30m、1h、1d、1w。
+func parseAfter(s string) (time.Duration, error) {
+	if len(s) > 1 {
+		n, err := strconv.Atoi(s[:len(s)-1])
+		switch s[len(s)-1] {
+		case 'd':
+			return time.Duration(n) * 24 * time.Hour, err
+		case 'w':
+			return time.Duration(n) * 7 * 24 * time.Hour, err
+		}
+	}
+	return time.ParseDuration(s)
+}
+
+// handleReminderText 處理「3天後提醒我聯絡王小明」這類新增提醒的文字，回傳是否已處理。
+func (s *Server) handleReminderText(ctx context.Context, replyToken string, nDB *NotionDB, text string) bool {
+	if s.Reminders == nil {
+		return false
+	}
+
+	due, content, name, ok := parseReminder(text, time.Now())
+	if !ok {
+		return false
+	}
+
+	r := Reminder{UID: nDB.UID, Owner: sessionKeyFrom(ctx, nDB.UID), Text: content, DueAt: due}
+	if name != "" {
+		r.PageID = s.findContactByName(ctx, nDB, name)
+	}
+	s.addReminder(ctx, replyToken, r)
+	return true
+}
+
+// findContactByName 回傳提醒中提到的聯絡人的 PageID，找不到時回傳空字串。
+// 欄位加密時 Notion 無法比對姓名，因此先查 Notion，沒有結果時再查本地的模糊搜尋索引。
+func (s *Server) findContactByName(ctx context.Context, nDB *NotionDB, name string) string {
+	people, err := nDB.QueryDatabaseContains(ctx, name)
+	if err == nil && len(people) == 0 {
+		people, err = s.state.fuzzy.SearchFuzzy(ctx, nDB, name)
+	}
+	if err != nil {
+		loggerFrom(ctx).Warn("Error finding contact for reminder", "err", err)
+		return ""
+	}
+	if len(people) == 0 {
+		return ""
+	}
+	return people[0].PageID
+}
+
+// addReminder 新增提醒並回覆確認訊息。
+func (s *Server) addReminder(ctx context.Context, replyToken string, r Reminder) {
+	r, err := s.Reminders.Add(r)
+	if err != nil {
+		loggerFrom(ctx).Error("Error adding reminder", "err", err)
+		if err := s.replyText(ctx, replyToken, "新增提醒失敗，請稍後再試"); err != nil {
+			loggerFrom(ctx).Error("Error replying", "err", err)
+		}
+		return
+	}
+
+	msg := fmt.Sprintf("好的，%s 會提醒你：%s", r.DueAt.In(reminderLocation()).Format("01/02 15:04"), r.Text)
+	if err := s.replyText(ctx, replyToken, msg); err != nil {
+		loggerFrom(ctx).Error("Error replying", "err", err)
+	}
+}
+
+// replyReminderList 回覆用戶 owner（見 sessionKey）尚未送出的提醒，並提供取消的快速回覆按鈕。
+func (s *Server) replyReminderList(ctx context.Context, replyToken, owner string) {
+	list := s.Reminders.List(owner)
+	if len(list) == 0 {
+		if err := s.replyText(ctx, replyToken, "目前沒有提醒"); err != nil {
+			loggerFrom(ctx).Error("Error replying", "err", err)
+		}
+		return
+	}
+
+	var lines []string
+	var items []messaging_api.QuickReplyItem
+	for i, r := range list {
+		lines = append(lines, fmt.Sprintf("%d. %s %s", i+1, r.DueAt.In(reminderLocation()).Format("01/02 15:04"), r.Text))
+		// 快速回覆最多 13 個按鈕
+		if len(items) < 13 {
+			items = append(items, messaging_api.QuickReplyItem{
+				Type: "action",
+				Action: &messaging_api.PostbackAction{
+					Label:       fmt.Sprintf("取消 %d", i+1),
+					Data:        "action=cancel_reminder&id=" + r.ID,
+					DisplayText: fmt.Sprintf("取消提醒 %d", i+1),
+				},
+			})
+		}
+	}
+
+	if _, err := s.Bot.ReplyMessage(
+		&messaging_api.ReplyMessageRequest{
+			ReplyToken: replyToken,
+			Messages: []messaging_api.MessageInterface{
+				&messaging_api.TextMessage{
+					Text:       strings.Join(lines, "\n"),
+					QuickReply: &messaging_api.QuickReply{Items: items},
+				},
+			},
+		},
+	); err != nil {
+		loggerFrom(ctx).Error("Error replying", "err", err)
+	}
+}
+
+// reminderLocation 是顯示提醒時間的時區，預設為台北時間。
+func reminderLocation() *time.Location {
+	loc, err := time.LoadLocation("Asia/Taipei")
+	if err != nil {
+		return time.FixedZone("CST", 8*60*60)
+	}
+	return loc
+}
+
+// runReminderScheduler 定期檢查到期的提醒並以 Push API 送出，直到 ctx 結束。
+func (s *Server) runReminderScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.deliverDueReminders(now)
+		}
+	}
+}
+
+// deliverDueReminders 送出所有到期的提醒。送出失敗時延後再試，LINE 拒絕（4xx）或超過 ReminderMaxAttempts 次後放棄。
+func (s *Server) deliverDueReminders(now time.Time) {
+	for _, r := range s.Reminders.Due(now) {
+		rlog := slog.With("job", "reminder", "reminder_id", r.ID)
+		err := s.deliverReminder(r)
+		var statusErr *StatusError
+		switch {
+		case err == nil:
+			err = s.Reminders.MarkDelivered(r.ID, now)
+		case errors.As(err, &statusErr) && statusErr.StatusCode >= 400 && statusErr.StatusCode < 500 && statusErr.StatusCode != http.StatusTooManyRequests:
+			// 用戶封鎖 bot 或已離開群組，重試也不會成功
+			rlog.Error("Dropping undeliverable reminder", "err", err)
+			err = s.Reminders.Remove(r.ID)
+		case r.Attempts+1 >= ReminderMaxAttempts:
+			rlog.Error("Giving up reminder", "attempts", r.Attempts+1, "err", err)
+			err = s.Reminders.Remove(r.ID)
+		default:
+			rlog.Error("Error delivering reminder", "attempts", r.Attempts+1, "err", err)
+			err = s.Reminders.Retry(r.ID, now.Add(retryDelay(r.Attempts+1)))
+		}
+		if err != nil {
+			rlog.Error("Error saving reminder", "err", err)
+		}
+	}
+}
+
+// deliverReminder 以 Push API 送出提醒，附上聯絡人的名片與延後、取消按鈕。
+func (s *Server) deliverReminder(r Reminder) error {
+	rlog := slog.With("job", "reminder", "reminder_id", r.ID)
+	items := []messaging_api.QuickReplyItem{
+		{Type: "action", Action: &messaging_api.PostbackAction{Label: "1 小時後", Data: "action=snooze&id=" + r.ID + "&after=1h", DisplayText: "1 小時後再提醒"}},
+		{Type: "action", Action: &messaging_api.PostbackAction{Label: "明天", Data: "action=snooze&id=" + r.ID + "&after=1d", DisplayText: "明天再提醒"}},
+		{Type: "action", Action: &messaging_api.PostbackAction{Label: "下週", Data: "action=snooze&id=" + r.ID + "&after=1w", DisplayText: "下週再提醒"}},
+		{Type: "action", Action: &messaging_api.PostbackAction{Label: "完成", Data: "action=cancel_reminder&id=" + r.ID, DisplayText: "完成"}},
+	}
+	messages := []messaging_api.MessageInterface{
+		&messaging_api.TextMessage{
+			Text:       "⏰ 提醒：" + r.Text,
+			QuickReply: &messaging_api.QuickReply{Items: items},
+		},
+	}
+
+	if r.PageID != "" {
+		ctx := withLogger(context.Background(), rlog)
+		nDB := s.newNotionDB(ctx, r.UID)
+		if person, err := nDB.GetPage(ctx, r.PageID); err == nil {
+			// 快速回覆只會顯示在最後一則訊息，所以名片放在提醒文字之前
+			messages = append([]messaging_api.MessageInterface{
+				&messaging_api.FlexMessage{
+					Contents: &messaging_api.FlexCarousel{Contents: []messaging_api.FlexBubble{s.getCardFlex(person)}},
+					AltText:  "請到手機上查看名片資訊",
+				},
+			}, messages...)
+		} else {
+			rlog.Error("Error getting reminder contact", "err", err)
+		}
+	}
+
+	resp, _, err := s.Bot.PushMessageWithHttpInfo(&messaging_api.PushMessageRequest{To: r.recipient(), Messages: messages}, "")
+	if resp != nil && resp.StatusCode/100 != 2 {
+		return fmt.Errorf("error pushing reminder: %w", &StatusError{StatusCode: resp.StatusCode})
+	}
+	return err
+}
+
+// handleReminderPostback 處理提醒的新增、延後與取消按鈕。提醒屬於名片簿 uid 並推播給新增提醒的用戶，只有他可以延後與取消。
+func (s *Server) handleReminderPostback(ctx context.Context, replyToken, uid string, data url.Values) {
+	if s.Reminders == nil {
+		return
+	}
+	owner := sessionKeyFrom(ctx, uid)
+
+	switch data.Get("action") {
+	case "remind":
+		after, err := parseAfter(data.Get("after"))
+		if err != nil {
+			loggerFrom(ctx).Error("Error parsing remind time", "err", err)
+			return
+		}
+		r := Reminder{UID: uid, Owner: owner, PageID: data.Get("page"), Text: "聯絡這位聯絡人", DueAt: time.Now().Add(after)}
+		s.addReminder(ctx, replyToken, r)
+	case "snooze":
+		after, err := parseAfter(data.Get("after"))
+		if err != nil {
+			loggerFrom(ctx).Error("Error parsing snooze time", "err", err)
+			return
+		}
+		r, err := s.Reminders.Snooze(owner, data.Get("id"), time.Now().Add(after))
+		msg := fmt.Sprintf("好的，%s 再提醒你", r.DueAt.In(reminderLocation()).Format("01/02 15:04"))
+		if err != nil {
+			msg = "找不到這則提醒"
+		}
+		if err := s.replyText(ctx, replyToken, msg); err != nil {
+			loggerFrom(ctx).Error("Error replying", "err", err)
+		}
+	case "cancel_reminder":
+		msg := "已移除提醒"
+		if err := s.Reminders.Cancel(owner, data.Get("id")); err != nil {
+			msg = "找不到這則提醒"
+		}
+		if err := s.replyText(ctx, replyToken, msg); err != nil {
+			loggerFrom(ctx).Error("Error replying", "err", err)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+func TestParseReminder(t *testing.T) {
+	now := time.Date(2026, 6, 3, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		text    string
+		due     time.Time
+		content string
+		name    string
+	}{
+		{"3天後提醒我聯絡王小明", now.AddDate(0, 0, 3), "聯絡王小明", "王小明"},
+		{"兩週後提醒我寄信給 陳大明", now.AddDate(0, 0, 14), "寄信給 陳大明", "陳大明"},
+		{"十二小時後提醒我 報價", now.Add(12 * time.Hour), "報價", ""},
+		{"明天提醒我回覆報價單", now.AddDate(0, 0, 1), "回覆報價單", "報價單"},
+	}
+	for _, tt := range tests {
+		due, content, name, ok := parseReminder(tt.text, now)
+		if !ok {
+			t.Errorf("parseReminder(%q) not matched", tt.text)
+			continue
+		}
+		if !due.Equal(tt.due) || content != tt.content || name != tt.name {
+			t.Errorf("parseReminder(%q) = (%v, %q, %q), want (%v, %q, %q)", tt.text, due, content, name, tt.due, tt.content, tt.name)
+		}
+	}
+
+	if _, _, _, ok := parseReminder("王小明", now); ok {
+		t.Error("parseReminder(王小明) should not match")
+	}
+}
+
+func TestReminderStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reminders.json")
+	store, err := NewReminderStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	r1, err := store.Add(Reminder{UID: "u1", Text: "聯絡王小明", DueAt: now.Add(-time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Add(Reminder{UID: "u1", Text: "報價", DueAt: now.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if due := store.Due(now); len(due) != 1 || due[0].ID != r1.ID {
+		t.Fatalf("Due() = %v, want only %s", due, r1.ID)
+	}
+	if err := store.MarkDelivered(r1.ID, now); err != nil {
+		t.Fatal(err)
+	}
+	if due := store.Due(now); len(due) != 0 {
+		t.Errorf("Due() after delivered = %v", due)
+	}
+
+	// 已送出的提醒可以延後，其他用戶不能操作
+	if _, err := store.Snooze("u2", r1.ID, now.Add(time.Hour)); err == nil {
+		t.Error("Snooze by other user should fail")
+	}
+	if _, err := store.Snooze("u1", r1.ID, now.Add(2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重新載入後仍保有提醒
+	reloaded, err := NewReminderStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := reloaded.List("u1")
+	if len(list) != 2 || list[1].ID != r1.ID {
+		t.Fatalf("List() = %v, want 2 reminders with snoozed last", list)
+	}
+	if err := reloaded.Cancel("u1", r1.ID); err != nil {
+		t.Fatal(err)
+	}
+	if list := reloaded.List("u1"); len(list) != 1 {
+		t.Errorf("List() after cancel = %v", list)
+	}
+}
+
+func TestDeliverDueRemindersBacksOff(t *testing.T) {
+	status := http.StatusInternalServerError
+	pushes := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pushes++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		io.WriteString(w, "{}")
+	}))
+	defer srv.Close()
+	api, err := messaging_api.NewMessagingApiAPI("test-token", messaging_api.WithEndpoint(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, _ := NewReminderStore("")
+	s := &Server{Config: DefaultConfig(), Bot: api, Reminders: store}
+
+	now := time.Now()
+	r, err := store.Add(Reminder{UID: "u1", Text: "聯絡王小明", DueAt: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 5xx 時延後再試，而不是每次檢查都重送
+	s.deliverDueReminders(now)
+	s.deliverDueReminders(now.Add(30 * time.Second))
+	if pushes != 1 {
+		t.Fatalf("pushes = %d, want 1 before the retry delay", pushes)
+	}
+	if list := store.List("u1"); len(list) != 1 || list[0].Attempts != 1 {
+		t.Fatalf("List() = %+v, want one reminder with 1 attempt", list)
+	}
+	s.deliverDueReminders(now.Add(retryDelay(1)))
+	if pushes != 2 {
+		t.Errorf("pushes = %d, want 2 after the retry delay", pushes)
+	}
+
+	// 延後提醒會重新計算嘗試次數
+	if r, err = store.Snooze("u1", r.ID, now); err != nil || r.Attempts != 0 {
+		t.Fatalf("Snooze() = %+v, %v", r, err)
+	}
+
+	// LINE 拒絕（封鎖 bot 等）時直接放棄
+	status = http.StatusBadRequest
+	s.deliverDueReminders(now.Add(time.Hour))
+	if list := store.List("u1"); len(list) != 0 {
+		t.Errorf("List() after 400 = %+v, want dropped", list)
+	}
+}
+
+func TestWebhookGroupRemindersBelongToSender(t *testing.T) {
+	h := newWebhookHarness(t)
+	h.Server.Reminders, _ = NewReminderStore("")
+
+	h.Post(h.Text(groupSource("G1", "U1"), "明天提醒我回信"))
+	list := h.Server.Reminders.List(sessionKeyFor("G1", "U1"))
+	if len(list) != 1 || list[0].UID != "G1" {
+		t.Fatalf("List() = %+v, want one reminder for the group book", list)
+	}
+
+	// 其他成員看不到也不能取消
+	h.Post(h.Text(groupSource("G1", "U2"), "提醒列表"))
+	h.Post(h.Postback(groupSource("G1", "U2"), "action=cancel_reminder&id="+list[0].ID))
+	replies := h.Replies()
+	if !strings.Contains(replies[1], "目前沒有提醒") || !strings.Contains(replies[2], "找不到這則提醒") {
+		t.Errorf("replies to another member = %s", replies[1:])
+	}
+
+	// 到期時只推播給新增提醒的成員，不推播到群組
+	h.Server.deliverDueReminders(time.Now().Add(48 * time.Hour))
+	var pushedTo []string
+	for _, c := range h.Calls() {
+		if c.Path == "/v2/bot/message/push" {
+			var req struct{ To string }
+			json.Unmarshal(c.Body, &req)
+			pushedTo = append(pushedTo, req.To)
+		}
+	}
+	if len(pushedTo) != 1 || pushedTo[0] != "U1" {
+		t.Fatalf("pushed to %v, want only U1", pushedTo)
+	}
+
+	// 推播到一對一聊天的提醒可以在那裡延後
+	h.Post(h.Postback(userSource("U1"), "action=snooze&id="+list[0].ID+"&after=1h"))
+	if list := h.Server.Reminders.List(sessionKeyFor("G1", "U1")); len(list) != 1 || !list[0].DeliveredAt.IsZero() {
+		t.Errorf("List() after snooze = %+v, want the reminder pending again", list)
+	}
+
+	// 刪除 U1 的資料時一併刪除他在群組中新增的提醒
+	if n, err := h.Server.Reminders.DeleteUser("U1"); err != nil || n != 1 {
+		t.Errorf("DeleteUser() = %d, %v, want 1", n, err)
+	}
+}
+
+func TestWebhookReminderFindsEncryptedContact(t *testing.T) {
+	h := newWebhookHarness(t)
+	h.Server.Reminders, _ = NewReminderStore("")
+	h.Server.Cipher = testFieldCipher(t, "k1", "k1")
+	db := h.Notion.NotionDB("db", "U1")
+	db.Cipher = h.Server.Cipher
+	contact, err := db.CreatePage(context.Background(), Person{Name: "王小明", Email: "ming@bank.example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 加密的姓名無法在 Notion 比對，改以本地索引找到聯絡人
+	h.Post(h.Text(userSource("U1"), "3天後提醒我聯絡王小明"))
+	list := h.Server.Reminders.List("U1")
+	if len(list) != 1 || list[0].PageID != contact.PageID {
+		t.Fatalf("List() = %+v, want a reminder linked to %s", list, contact.PageID)
+	}
+}
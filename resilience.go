@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// ErrUnavailable 表示外部服務暫時無法使用：重試後仍失敗，或斷路器開啟中。呼叫端應該請用戶稍後再試。
+var ErrUnavailable = errors.New("service temporarily unavailable")
+
+// ErrCircuitOpen 表示斷路器開啟中，請求沒有送出。
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// UnavailableError 是外部服務暫時無法使用的錯誤，errors.Is(err, ErrUnavailable) 成立。
+type UnavailableError struct {
+	Service string
+	Err     error
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("%s is unavailable: %v", e.Service, e.Err)
+}
+
+func (e *UnavailableError) Unwrap() error { return e.Err }
+
+func (e *UnavailableError) Is(target error) bool { return target == ErrUnavailable }
+
+// StatusError 是外部服務回應的 HTTP 錯誤狀態，RetryAfter 是 Retry-After header 要求的等待時間。
+type StatusError struct {
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("HTTP %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// RetryPolicy 是重試的次數與指數退避的等待時間。
+type RetryPolicy struct {
+	MaxAttempts int           // 包含第一次的嘗試次數
+	BaseDelay   time.Duration // 第一次重試前的等待時間，之後每次加倍
+	MaxDelay    time.Duration // 等待時間的上限
+
+	jitter func() float64 // 測試時可以替換，預設為 rand.Float64
+}
+
+// Backoff 回傳第 attempt 次（從 0 開始）失敗後的等待時間：指數退避後隨機取一半到全部的時間，
+// 服務以 Retry-After 要求更長的等待時間時以 retryAfter 為準。
+func (p RetryPolicy) Backoff(attempt int, retryAfter time.Duration) time.Duration {
+	d := p.BaseDelay
+	for i := 0; i < attempt && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	jitter := rand.Float64
+	if p.jitter != nil {
+		jitter = p.jitter
+	}
+	d = d/2 + time.Duration(jitter()*float64(d/2))
+	if retryAfter > d {
+		return retryAfter
+	}
+	return d
+}
+
+// CircuitBreaker 在連續 Threshold 次失敗後開啟，Cooldown 期間直接拒絕請求，避免服務故障時每個用戶都要等到逾時。
+// Cooldown 結束後只放行一個試探的請求，成功時關閉，失敗時再開啟一段 Cooldown。
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+	now       func() time.Time
+}
+
+// Allow 回傳是否可以送出請求，斷路器開啟中時回傳 ErrCircuitOpen。
+func (b *CircuitBreaker) Allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.Threshold {
+		return nil
+	}
+	if b.clock().Before(b.openUntil) || b.probing {
+		return ErrCircuitOpen
+	}
+	b.probing = true
+	return nil
+}
+
+// Success 記錄成功的請求並關閉斷路器。
+func (b *CircuitBreaker) Success() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.probing = false
+}
+
+// Failure 記錄失敗的請求，連續失敗達到 Threshold 次或試探失敗時開啟斷路器。
+func (b *CircuitBreaker) Failure() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.probing = false
+	if b.failures >= b.Threshold {
+		b.openUntil = b.clock().Add(b.Cooldown)
+	}
+}
+
+// Open 回傳斷路器是否開啟中。
+func (b *CircuitBreaker) Open() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures >= b.Threshold && b.clock().Before(b.openUntil)
+}
+
+func (b *CircuitBreaker) clock() time.Time {
+	if b.now != nil {
+		return b.now()
+	}
+	return time.Now()
+}
+
+// Dependency 是一個外部服務（Gemini、Notion）的重試策略、斷路器與每次呼叫的期限。
+// nil 的 Dependency 直接呼叫，不重試，方便測試直接建立 Server。
+type Dependency struct {
+	Name    string
+	Retry   RetryPolicy
+	Breaker *CircuitBreaker
+	Timeout time.Duration // 每次呼叫（包含重試）的期限，0 表示沿用 ctx 的期限
+}
+
+// NewDependency 依照設定建立名為 name 的外部服務，timeout 是每次呼叫的期限。
+func (c *Config) NewDependency(name string, timeout time.Duration) *Dependency {
+	return &Dependency{
+		Name:    name,
+		Retry:   RetryPolicy{MaxAttempts: c.RetryMaxAttempts, BaseDelay: c.RetryBaseDelay, MaxDelay: c.RetryMaxDelay},
+		Breaker: &CircuitBreaker{Threshold: c.BreakerThreshold, Cooldown: c.BreakerCooldown},
+		Timeout: timeout,
+	}
+}
+
+// Do 執行 fn，暫時性的錯誤（逾時、連線錯誤、429 與 5xx）以指數退避重試。
+// 重試後仍失敗或斷路器開啟中時回傳 UnavailableError，其他錯誤直接回傳。
+func (d *Dependency) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	if d == nil {
+		return fn(ctx)
+	}
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+	return d.do(ctx, fn, isTransient)
+}
+
+// do 是 Do 的重試迴圈，不另外設定期限。暫時性的錯誤都記為斷路器的失敗，但只有 retry 回傳 true 的錯誤才重試，
+// 其他的暫時性錯誤直接回傳。
+func (d *Dependency) do(ctx context.Context, fn func(ctx context.Context) error, retry func(error) bool) error {
+	for attempt := 0; ; attempt++ {
+		if err := d.Breaker.Allow(); err != nil {
+			return &UnavailableError{Service: d.Name, Err: err}
+		}
+		err := fn(ctx)
+		if err == nil || !isTransient(err) {
+			// 服務有正常回應，即使是 4xx 也不算服務故障
+			d.Breaker.Success()
+			return err
+		}
+		d.Breaker.Failure()
+		if !retry(err) {
+			return err
+		}
+		if attempt+1 >= d.Retry.MaxAttempts {
+			return &UnavailableError{Service: d.Name, Err: err}
+		}
+		wait := d.Retry.Backoff(attempt, retryAfter(err))
+		loggerFrom(ctx).Warn("Retrying request", "service", d.Name, "attempt", attempt+1, "wait", wait, "err", err)
+		if err := sleepContext(ctx, wait); err != nil {
+			return &UnavailableError{Service: d.Name, Err: err}
+		}
+	}
+}
+
+// Transport 包裝 next，讓 HTTP client 的每個請求都經過 Do 的重試與斷路器。
+// 429 與 5xx 的回應重試後仍失敗時以 UnavailableError 回傳；沒有 GetBody 無法重送的請求只送一次。
+// 新增、修改名片等非冪等的請求送出後可能已經在服務端完成，只在請求還沒送出就連線失敗或收到 429 時重試，
+// 5xx 與逾時直接回傳錯誤，避免重複新增名片。
+// 回應的 body 在 RoundTrip 之後才讀取，所以這裡不設定期限，期限由 http.Client 的 Timeout 設定。
+func (d *Dependency) Transport(next http.RoundTripper) http.RoundTripper {
+	if d == nil {
+		return next
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		var resp *http.Response
+		first := true
+		idempotent := isIdempotentRequest(req)
+		var sent atomic.Bool // 寫入請求的 goroutine 與 RoundTrip 不同
+		retry := func(err error) bool {
+			if idempotent || !sent.Load() {
+				return true
+			}
+			var statusErr *StatusError
+			return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusTooManyRequests
+		}
+		err := d.do(req.Context(), func(ctx context.Context) error {
+			sent.Store(false)
+			trace := &httptrace.ClientTrace{WroteRequest: func(info httptrace.WroteRequestInfo) {
+				sent.Store(info.Err == nil)
+			}}
+			attempt := req.Clone(httptrace.WithClientTrace(ctx, trace))
+			if !first {
+				if req.GetBody == nil {
+					return errors.New("request body cannot be resent")
+				}
+				body, err := req.GetBody()
+				if err != nil {
+					return err
+				}
+				attempt.Body = body
+			}
+			first = false
+
+			var err error
+			resp, err = next.RoundTrip(attempt)
+			if err != nil {
+				return err
+			}
+			if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+				statusErr := &StatusError{StatusCode: resp.StatusCode, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				resp = nil
+				return statusErr
+			}
+			return nil
+		}, retry)
+		if err != nil {
+			return nil, err
+		}
+		return resp, nil
+	})
+}
+
+// isIdempotentRequest 回傳請求是否可以安全地重送：GET、HEAD，以及 Notion 的資料庫查詢與搜尋（POST 但不修改資料）。
+func isIdempotentRequest(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	case http.MethodPost:
+		return strings.HasSuffix(req.URL.Path, "/query") || strings.HasSuffix(req.URL.Path, "/v1/search")
+	}
+	return false
+}
+
+// isTransient 回傳 err 是否為重試可能成功的錯誤。
+func isTransient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, ErrUnavailable) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= 500
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusTooManyRequests || apiErr.Code >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// retryAfter 回傳錯誤中服務要求的等待時間，沒有時回傳 0。
+func retryAfter(err error) time.Duration {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.RetryAfter
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return parseRetryAfter(apiErr.Header.Get("Retry-After"))
+	}
+	return 0
+}
+
+// parseRetryAfter 解析 Retry-After header，可以是秒數或 HTTP 日期。
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// sleepContext 等待 d，ctx 先結束或期限在 d 之前就會到期時立刻回傳錯誤。
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+		return context.DeadlineExceeded
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
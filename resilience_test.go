@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 5 * time.Second, jitter: func() float64 { return 1 }}
+	for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if got := p.Backoff(attempt, 0); got != want {
+			t.Errorf("Backoff(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+
+	// 隨機取一半到全部的時間
+	p.jitter = func() float64 { return 0 }
+	if got := p.Backoff(1, 0); got != time.Second {
+		t.Errorf("Backoff with zero jitter = %v, want 1s", got)
+	}
+	// Retry-After 比退避時間長時以 Retry-After 為準
+	if got := p.Backoff(0, 30*time.Second); got != 30*time.Second {
+		t.Errorf("Backoff with Retry-After = %v, want 30s", got)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter("3"); got != 3*time.Second {
+		t.Errorf("parseRetryAfter(3) = %v", got)
+	}
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(date); got <= 0 || got > time.Minute {
+		t.Errorf("parseRetryAfter(%q) = %v", date, got)
+	}
+	for _, value := range []string{"", "soon", "-1"} {
+		if got := parseRetryAfter(value); got != 0 {
+			t.Errorf("parseRetryAfter(%q) = %v, want 0", value, got)
+		}
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Date(2026, 5, 1, 9, 0, 0, 0, time.UTC)
+	b := &CircuitBreaker{Threshold: 2, Cooldown: time.Minute, now: func() time.Time { return now }}
+
+	b.Failure()
+	if err := b.Allow(); err != nil {
+		t.Fatalf("breaker opened after one failure: %v", err)
+	}
+	b.Failure()
+	if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Allow after threshold = %v, want ErrCircuitOpen", err)
+	}
+
+	// Cooldown 結束後只放行一個試探的請求
+	now = now.Add(time.Minute)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("probe rejected: %v", err)
+	}
+	if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("second request during probe = %v, want ErrCircuitOpen", err)
+	}
+	// 試探失敗時再開啟一段 Cooldown
+	b.Failure()
+	if !b.Open() {
+		t.Fatal("breaker closed after failed probe")
+	}
+
+	now = now.Add(time.Minute)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("probe rejected: %v", err)
+	}
+	b.Success()
+	if b.Open() || b.Allow() != nil {
+		t.Fatal("breaker still open after successful probe")
+	}
+}
+
+func TestDependencyDo(t *testing.T) {
+	d := &Dependency{Name: "gemini", Retry: RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}, Breaker: &CircuitBreaker{Threshold: 10, Cooldown: time.Minute}}
+	ctx := context.Background()
+
+	// 暫時性的錯誤重試到成功
+	calls := 0
+	err := d.Do(ctx, func(context.Context) error {
+		if calls++; calls < 3 {
+			return &googleapi.Error{Code: http.StatusServiceUnavailable}
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Fatalf("Do = %v after %d calls, want success after 3", err, calls)
+	}
+
+	// 4xx 不重試，也不是 ErrUnavailable
+	calls = 0
+	err = d.Do(ctx, func(context.Context) error {
+		calls++
+		return &googleapi.Error{Code: http.StatusBadRequest}
+	})
+	if calls != 1 || err == nil || errors.Is(err, ErrUnavailable) {
+		t.Fatalf("Do = %v after %d calls, want the 400 error after 1 call", err, calls)
+	}
+
+	// 重試次數用完時回傳 ErrUnavailable
+	calls = 0
+	err = d.Do(ctx, func(context.Context) error {
+		calls++
+		return &StatusError{StatusCode: http.StatusTooManyRequests}
+	})
+	if calls != 3 || !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("Do = %v after %d calls, want ErrUnavailable after 3", err, calls)
+	}
+}
+
+func TestDependencyDoDeadline(t *testing.T) {
+	d := &Dependency{Name: "gemini", Retry: RetryPolicy{MaxAttempts: 5, BaseDelay: time.Hour}, Timeout: 50 * time.Millisecond}
+	start := time.Now()
+	err := d.Do(context.Background(), func(ctx context.Context) error {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("fn called without a deadline")
+		}
+		return &StatusError{StatusCode: http.StatusBadGateway}
+	})
+	// 等待時間超過期限時不等待，直接回傳
+	if !errors.Is(err, ErrUnavailable) || time.Since(start) > time.Second {
+		t.Fatalf("Do = %v after %v", err, time.Since(start))
+	}
+}
+
+func TestDependencyTransport(t *testing.T) {
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if len(bodies) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	t.Cleanup(srv.Close)
+
+	d := &Dependency{Name: "notion", Retry: RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}, Breaker: &CircuitBreaker{Threshold: 2, Cooldown: time.Minute}}
+	client := &http.Client{Transport: d.Transport(nil)}
+	resp, err := client.Post(srv.URL, "application/json", strings.NewReader(`{"page":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "ok" || len(bodies) != 2 || bodies[1] != `{"page":1}` {
+		t.Fatalf("body = %q, requests = %q", body, bodies)
+	}
+
+	// 斷路器開啟時不送出請求
+	d.Breaker.Failure()
+	d.Breaker.Failure()
+	if _, err := client.Get(srv.URL); !errors.Is(err, ErrUnavailable) || !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Get with open breaker = %v", err)
+	}
+	if len(bodies) != 2 {
+		t.Errorf("request sent while the breaker was open")
+	}
+}
+
+// flakyCardReader 在前 failures 次回傳 503，之後交給 next。
+type flakyCardReader struct {
+	failures int
+	next     CardReader
+}
+
+func (r *flakyCardReader) ReadCard(ctx context.Context, imgData []byte, prompt string) (string, error) {
+	if r.failures > 0 {
+		r.failures--
+		return "", &googleapi.Error{Code: http.StatusServiceUnavailable, Message: "model overloaded"}
+	}
+	return r.next.ReadCard(ctx, imgData, prompt)
+}
+
+func TestWebhookQueuesCardWhenGeminiUnavailable(t *testing.T) {
+	h := newWebhookHarness(t)
+	h.SetCard("m1", "```json\n{\"name\":\"王小明\",\"email\":\"ming@bank.example\"}\n```")
+	h.Server.Cards = &flakyCardReader{failures: 2, next: h}
+	h.Server.Gemini = &Dependency{Name: "gemini", Retry: RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}}
+
+	h.Server.CardJobs, _ = NewCardQueue("")
+
+	h.Post(h.Image(userSource("U1"), "m1"))
+	replies := h.Replies()
+	if len(replies) != 1 || !strings.Contains(replies[0], "稍後再試") {
+		t.Fatalf("replies = %v, want a retry-later message", replies)
+	}
+	jobs := h.Server.CardJobs.Due(time.Now().Add(time.Hour))
+	if len(jobs) != 1 || jobs[0].MessageID != "m1" || jobs[0].BookID != "U1" {
+		t.Fatalf("queued jobs = %+v", jobs)
+	}
+
+	// Gemini 恢復後排程器重新處理名片並推播結果
+	h.Server.retryCardJob(jobs[0], time.Now())
+	if h.Server.CardJobs.Len() != 0 {
+		t.Errorf("queue still has %d jobs", h.Server.CardJobs.Len())
+	}
+	var pushes []string
+	for _, c := range h.Calls() {
+		if c.Path == "/v2/bot/message/push" {
+			pushes = append(pushes, string(c.Body))
+		}
+	}
+	if len(pushes) != 1 || !strings.Contains(pushes[0], "新增到資料庫") || !strings.Contains(pushes[0], `"to":"U1"`) {
+		t.Errorf("pushes = %v", pushes)
+	}
+	people, err := h.Notion.NotionDB("db", "U1").QueryDatabaseByUID(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(people) != 1 || people[0].Name != "王小明" {
+		t.Errorf("contacts = %+v", people)
+	}
+}
+
+func TestRetryCardJobGivesUp(t *testing.T) {
+	h := newWebhookHarness(t)
+	h.Server.Cards = &flakyCardReader{failures: 1, next: h}
+	h.Server.Gemini = &Dependency{Name: "gemini", Retry: RetryPolicy{MaxAttempts: 1}}
+
+	h.Server.CardJobs, _ = NewCardQueue("")
+
+	now := time.Now()
+	job := CardJob{MessageID: "m1", BookID: "U1", QueuedAt: now, Attempts: CardJobMaxAttempts - 1, RunAt: now}
+	h.Server.CardJobs.Schedule(job)
+	h.Server.retryCardJob(job, now)
+
+	if h.Server.CardJobs.Len() != 0 {
+		t.Errorf("queue still has %d jobs", h.Server.CardJobs.Len())
+	}
+	calls := h.Calls()
+	if last := calls[len(calls)-1]; last.Path != "/v2/bot/message/push" || !strings.Contains(string(last.Body), "重新上傳") {
+		t.Errorf("last call = %s %s", last.Path, last.Body)
+	}
+}
+
+func TestDependencyTransportDoesNotResendCreates(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	t.Cleanup(srv.Close)
+
+	d := &Dependency{Name: "notion", Retry: RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}, Breaker: &CircuitBreaker{Threshold: 10, Cooldown: time.Minute}}
+	client := &http.Client{Transport: d.Transport(nil)}
+
+	// 新增名片的請求已經送出，Notion 可能已經建立頁面，5xx 時不重送
+	_, err := client.Post(srv.URL+"/v1/pages", "application/json", strings.NewReader(`{}`))
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadGateway || errors.Is(err, ErrUnavailable) {
+		t.Errorf("create err = %v, want the 502 without retrying", err)
+	}
+	if len(requests) != 1 {
+		t.Fatalf("requests = %q, want the create sent once", requests)
+	}
+
+	// 查詢不會修改資料，可以重試
+	if _, err := client.Post(srv.URL+"/v1/databases/db/query", "application/json", strings.NewReader(`{}`)); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("query err = %v, want ErrUnavailable", err)
+	}
+	if len(requests) != 4 {
+		t.Errorf("requests = %q, want the query retried", requests)
+	}
+}
+
+func TestDependencyTransportRetriesUnsentCreates(t *testing.T) {
+	// 連不上服務時請求還沒送出，新增名片也可以重試
+	d := &Dependency{Name: "notion", Retry: RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}, Breaker: &CircuitBreaker{Threshold: 10, Cooldown: time.Minute}}
+	attempts := 0
+	next := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		attempts++
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	})
+	client := &http.Client{Transport: d.Transport(next)}
+	if _, err := client.Post("http://notion.invalid/v1/pages", "application/json", strings.NewReader(`{}`)); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("err = %v, want ErrUnavailable", err)
+	}
+	if attempts != 2 {
+		t.Errorf("attempts = %d, want 2", attempts)
+	}
+}
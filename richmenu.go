@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"reflect"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+// runRichMenuCommand 建立圖文選單、上傳圖片並設為所有用戶的預設選單。
+//
+//	linebot-smart-namecard richmenu [-definition richmenu/richmenu.json] [-image richmenu/richmenu.png] [-replace]
+func (s *Server) runRichMenuCommand(args []string) error {
+	fs := flag.NewFlagSet("richmenu", flag.ContinueOnError)
+	definition := fs.String("definition", "richmenu/richmenu.json", "圖文選單的 JSON 定義")
+	imagePath := fs.String("image", "richmenu/richmenu.png", "圖文選單的圖片 (PNG 或 JPEG)")
+	replace := fs.Bool("replace", true, "刪除同名的舊圖文選單")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	menu, err := loadRichMenu(*definition)
+	if err != nil {
+		return err
+	}
+	image, err := os.ReadFile(*imagePath)
+	if err != nil {
+		return fmt.Errorf("error reading rich menu image: %w", err)
+	}
+
+	if _, err := s.Bot.ValidateRichMenuObject(menu); err != nil {
+		return fmt.Errorf("invalid rich menu: %w", err)
+	}
+
+	// 記下同名的舊選單，新的選單設定完成後再刪除
+	var old []string
+	if *replace {
+		list, err := s.Bot.GetRichMenuList()
+		if err != nil {
+			return fmt.Errorf("error listing rich menus: %w", err)
+		}
+		for _, m := range list.Richmenus {
+			if m.Name == menu.Name {
+				old = append(old, m.RichMenuId)
+			}
+		}
+	}
+
+	created, err := s.Bot.CreateRichMenu(menu)
+	if err != nil {
+		return fmt.Errorf("error creating rich menu: %w", err)
+	}
+	slog.Info("Created rich menu", "rich_menu_id", created.RichMenuId)
+
+	if _, err := s.Blob.SetRichMenuImage(created.RichMenuId, http.DetectContentType(image), bytes.NewReader(image)); err != nil {
+		return fmt.Errorf("error uploading rich menu image: %w", err)
+	}
+	if _, err := s.Bot.SetDefaultRichMenu(created.RichMenuId); err != nil {
+		return fmt.Errorf("error setting default rich menu: %w", err)
+	}
+	slog.Info("Set default rich menu", "rich_menu_id", created.RichMenuId)
+
+	for _, id := range old {
+		if _, err := s.Bot.DeleteRichMenu(id); err != nil {
+			slog.Error("Error deleting old rich menu", "rich_menu_id", id, "err", err)
+			continue
+		}
+		slog.Info("Deleted old rich menu", "rich_menu_id", id)
+	}
+	return nil
+}
+
+// loadRichMenu 從 JSON 檔案載入圖文選單定義。
+func loadRichMenu(path string) (*messaging_api.RichMenuRequest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading rich menu definition: %w", err)
+	}
+	var menu messaging_api.RichMenuRequest
+	if err := json.Unmarshal(data, &menu); err != nil {
+		return nil, fmt.Errorf("error parsing rich menu definition: %w", err)
+	}
+
+	// SDK 解析出來的 action 是值，但 MarshalJSON 定義在指標上，要轉成指標才會帶上 type 欄位
+	for i, area := range menu.Areas {
+		if v := reflect.ValueOf(area.Action); v.Kind() == reflect.Struct {
+			p := reflect.New(v.Type())
+			p.Elem().Set(v)
+			menu.Areas[i].Action = p.Interface().(messaging_api.ActionInterface)
+		}
+	}
+	return &menu, nil
+}
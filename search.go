@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/mozillazg/go-pinyin"
+)
+
+// simpToTrad 將簡體字對應到繁體字，用於搜尋時的繁簡折疊。
+var simpToTrad = func() map[rune]rune {
+	pairs := []rune(simpTradPairs)
+	m := make(map[rune]rune, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		m[pairs[i]] = pairs[i+1]
+	}
+	for from, to := range tradVariants {
+		m[from] = to
+	}
+	return m
+}()
+
+// tradVariants 將轉換表選用的字與台灣常用的異體字折疊在一起，例如姓氏「鍾」在轉換表中是「钟→鐘」。
+var tradVariants = map[rune]rune{'鐘': '鍾', '钟': '鍾', '艷': '豔', '艳': '豔'}
+
+// wadeGilesSyllables 是無法以聲母韻母規則轉換的威妥瑪拼音特例（去除送氣符號）。
+var wadeGilesSyllables = map[string]string{
+	"zhi": "chih", "chi": "chih", "shi": "shih", "ri": "jih",
+	"zi": "tzu", "ci": "tzu", "si": "szu",
+	"you": "yu", "yan": "yen", "yue": "yueh", "er": "erh",
+}
+
+// wadeGilesInitials 依長度排序的聲母對應表，送氣符號一律省略以便比對。
+var wadeGilesInitials = []struct{ pinyin, wg string }{
+	{"zh", "ch"}, {"ch", "ch"}, {"sh", "sh"},
+	{"b", "p"}, {"p", "p"}, {"d", "t"}, {"t", "t"},
+	{"g", "k"}, {"k", "k"}, {"j", "ch"}, {"q", "ch"},
+	{"x", "hs"}, {"z", "ts"}, {"c", "ts"}, {"r", "j"},
+}
+
+// SearchIndex 是以用戶為單位的本地名片索引，支援錯字容忍、繁簡折疊與拼音/威妥瑪拼音比對。
+type SearchIndex struct {
+	mu    sync.RWMutex
+	users map[string][]indexedContact
+}
+
+// indexedContact 保存一張名片以及預先計算好的比對用字串。
+type indexedContact struct {
+	person Person
+	// texts 是正規化後的欄位內容（Name, Title, Company, Email）
+	texts []string
+	// tokens 是欄位中以空白、標點拆開的單字，用於英文錯字比對
+	tokens []string
+	// romans 是 Name 與 Company 每個字的羅馬拼音（漢語拼音與威妥瑪拼音）
+	romans [][]string
+}
+
+// NewSearchIndex 建立一個空的 SearchIndex。
+func NewSearchIndex() *SearchIndex {
+	return &SearchIndex{users: make(map[string][]indexedContact)}
+}
+
+// Has 回傳此用戶是否已經建立索引。
+func (s *SearchIndex) Has(uid string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.users[uid]
+	return ok
+}
+
+// Invalidate 移除此用戶的索引，下次搜尋時會從資料庫重建。
+func (s *SearchIndex) Invalidate(uid string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.users, uid)
+}
+
+// Rebuild 以資料庫中的名片重建此用戶的索引。
+func (s *SearchIndex) Rebuild(uid string, people []Person) {
+	contacts := make([]indexedContact, 0, len(people))
+	for _, p := range people {
+		c := indexedContact{person: p}
+		for _, field := range []string{p.Name, p.Title, p.Company, p.Email} {
+			if t := normalizeText(field); t != "" {
+				c.texts = append(c.texts, t)
+			}
+			for _, word := range strings.FieldsFunc(field, isSeparator) {
+				if t := normalizeText(word); t != "" {
+					c.tokens = append(c.tokens, t)
+				}
+			}
+		}
+		for _, field := range []string{p.Name, p.Company} {
+			c.romans = append(c.romans, romanize(field)...)
+		}
+		contacts = append(contacts, c)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[uid] = contacts
+}
+
+// Search 以模糊比對搜尋此用戶的名片，依相似度由高到低排序。
+func (s *SearchIndex) Search(uid, query string) []Person {
+	q := normalizeText(query)
+	if q == "" {
+		return nil
+	}
+	qRomans := romanize(query)
+
+	s.mu.RLock()
+	contacts := s.users[uid]
+	s.mu.RUnlock()
+
+	type hit struct {
+		person Person
+		score  int
+	}
+	var hits []hit
+	for _, c := range contacts {
+		if score, ok := c.match(q, qRomans); ok {
+			hits = append(hits, hit{person: c.person, score: score})
+		}
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].score < hits[j].score })
+	var results []Person
+	for _, h := range hits {
+		results = append(results, h.person)
+	}
+	return results
+}
+
+// SearchFuzzy 以本地索引搜尋名片，若此用戶尚未建立索引則先從 Notion 資料庫重建。
+func (s *SearchIndex) SearchFuzzy(ctx context.Context, nDB *NotionDB, query string) ([]Person, error) {
+	if !s.Has(nDB.UID) {
+		people, err := nDB.QueryDatabaseByUID(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.Rebuild(nDB.UID, people)
+	}
+	return s.Search(nDB.UID, query), nil
+}
+
+// match 回傳查詢與名片的距離分數，分數越低越相近。
+func (c indexedContact) match(q string, qRomans [][]string) (int, bool) {
+	best, found := 0, false
+	update := func(score int) {
+		if !found || score < best {
+			best, found = score, true
+		}
+	}
+
+	for _, text := range c.texts {
+		if strings.Contains(text, q) {
+			update(0)
+			continue
+		}
+		if d := editDistance(q, text); d <= maxTypos(q) {
+			update(d)
+		}
+	}
+	for _, token := range c.tokens {
+		if d := editDistance(q, token); d <= maxTypos(q) {
+			update(d)
+		}
+	}
+
+	// 將查詢的拼音（或英文字）與名片上連續數個字的拼音比對
+	candidates := [][]string{{q}}
+	if len(qRomans) > 0 {
+		candidates = qRomans
+	}
+	for _, syllables := range c.romans {
+		for _, run := range syllableRuns(syllables) {
+			for _, cand := range candidates {
+				joined := strings.Join(cand, "")
+				if d := editDistance(joined, run); d <= maxTypos(joined) {
+					update(d + 1)
+				}
+			}
+		}
+	}
+	return best, found
+}
+
+// normalizeText 將文字轉為小寫、繁簡折疊並移除空白與標點符號。
+func normalizeText(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsSpace(r) || unicode.IsPunct(r) && r != '@' && r != '.' {
+			continue
+		}
+		if t, ok := simpToTrad[r]; ok {
+			r = t
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// isSeparator 用於將 email 或英文職稱拆成單字。
+func isSeparator(r rune) bool {
+	return unicode.IsSpace(r) || unicode.IsPunct(r)
+}
+
+// romanize 將文字中的漢字轉為漢語拼音與威妥瑪拼音兩組音節，沒有漢字時回傳 nil。
+func romanize(s string) [][]string {
+	args := pinyin.NewArgs()
+	var hanyu, wade []string
+	for _, r := range s {
+		if !unicode.Is(unicode.Han, r) {
+			continue
+		}
+		if t, ok := simpToTrad[r]; ok {
+			r = t
+		}
+		py := pinyin.SinglePinyin(r, args)
+		if len(py) == 0 {
+			continue
+		}
+		syllable := strings.ReplaceAll(py[0], "v", "u")
+		hanyu = append(hanyu, syllable)
+		wade = append(wade, wadeGiles(syllable))
+	}
+	if len(hanyu) == 0 {
+		return nil
+	}
+	return [][]string{hanyu, wade}
+}
+
+// wadeGiles 將一個漢語拼音音節轉為威妥瑪拼音（不含送氣符號與變音符號）。
+func wadeGiles(syllable string) string {
+	if wg, ok := wadeGilesSyllables[syllable]; ok {
+		return wg
+	}
+
+	initial, rest := "", syllable
+	for _, in := range wadeGilesInitials {
+		if strings.HasPrefix(syllable, in.pinyin) {
+			initial, rest = in.wg, strings.TrimPrefix(syllable, in.pinyin)
+			break
+		}
+	}
+
+	switch {
+	case rest == "e" && (initial == "k" || initial == "h"):
+		rest = "o"
+	case strings.HasSuffix(rest, "ong"):
+		rest = strings.TrimSuffix(rest, "ong") + "ung"
+	case strings.HasSuffix(rest, "ian"):
+		rest = strings.TrimSuffix(rest, "ian") + "ien"
+	case strings.HasSuffix(rest, "ie"), strings.HasSuffix(rest, "ue"):
+		rest += "h"
+	}
+	return initial + rest
+}
+
+// syllableRuns 回傳所有連續音節組合，例如 [chen da ming] 會產生 chen, chenda, chendaming, da ...
+func syllableRuns(syllables []string) []string {
+	var runs []string
+	for i := range syllables {
+		for j := i + 1; j <= len(syllables); j++ {
+			runs = append(runs, strings.Join(syllables[i:j], ""))
+		}
+	}
+	return runs
+}
+
+// maxTypos 依查詢長度決定可容忍的錯字數。
+func maxTypos(q string) int {
+	switch n := len([]rune(q)); {
+	case n <= 2:
+		return 0
+	case n <= 5:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// editDistance 計算兩個字串以 rune 為單位的 Levenshtein 距離。
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSearchIndexFuzzy(t *testing.T) {
+	idx := NewSearchIndex()
+	idx.Rebuild("uid", []Person{
+		{Name: "陳 大明", Title: "業務經理", Company: "台灣積體電路", Email: "daming@example.com"},
+		{Name: "張志豪", Title: "Cloud Security Engineer", Company: "國泰世華銀行", Email: "hao@bank.com"},
+		{Name: "Alice Wang", Title: "CEO", Company: "Acme", Email: "alice@acme.com"},
+	})
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"陳大明", "陳 大明"},    // 忽略空白
+		{"陈大明", "陳 大明"},    // 簡體折疊
+		{"chen", "陳 大明"},   // 漢語拼音
+		{"chang", "張志豪"},   // 威妥瑪拼音
+		{"chihhao", "張志豪"}, // 威妥瑪拼音多個字
+		{"zhanzhihao", "張志豪"},
+		{"securty", "張志豪"}, // 錯字
+		{"alise", "Alice Wang"},
+	}
+
+	for _, tt := range tests {
+		results := idx.Search("uid", tt.query)
+		if len(results) == 0 {
+			t.Errorf("Search(%q) got no results, want %q", tt.query, tt.want)
+			continue
+		}
+		if results[0].Name != tt.want {
+			t.Errorf("Search(%q) = %q, want %q", tt.query, results[0].Name, tt.want)
+		}
+	}
+
+	if results := idx.Search("uid", "xyz"); len(results) != 0 {
+		t.Errorf("Search(xyz) = %v, want no results", results)
+	}
+	if results := idx.Search("other", "chen"); len(results) != 0 {
+		t.Errorf("Search for other user = %v, want no results", results)
+	}
+}
+
+func TestNormalizeTextFoldsSimplified(t *testing.T) {
+	tests := map[string]string{
+		"软件开发部":   "軟件開發部",
+		"优化顾问":    "優化顧問",
+		"网络与信息安全": "網絡與信息安全",
+		"钟":       "鍾",
+		"鐘":       "鍾",
+	}
+	for in, want := range tests {
+		if got := normalizeText(in); got != want {
+			t.Errorf("normalizeText(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	idx := NewSearchIndex()
+	idx.Rebuild("uid", []Person{{Name: "蕭婷", Title: "資深顧問", Company: "環球貿易"}})
+	for _, q := range []string{"萧婷", "环球贸易", "资深顾问"} {
+		if results := idx.Search("uid", q); len(results) != 1 {
+			t.Errorf("Search(%q) = %v, want 1 result", q, results)
+		}
+	}
+}
+
+func TestWadeGiles(t *testing.T) {
+	tests := map[string]string{
+		"zhang": "chang",
+		"cai":   "tsai",
+		"xu":    "hsu",
+		"xie":   "hsieh",
+		"guo":   "kuo",
+		"hong":  "hung",
+		"zeng":  "tseng",
+		"zhi":   "chih",
+		"jian":  "chien",
+		"xue":   "hsueh",
+	}
+	for in, want := range tests {
+		if got := wadeGiles(in); got != want {
+			t.Errorf("wadeGiles(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Server 是 bot 的執行環境。設定與 LINE、Gemini 的 client 由 main 建立後注入，webhook 與背景排程都從這裡取得。
+type Server struct {
+	Config *Config
+	Bot    *messaging_api.MessagingApiAPI
+	Blob   *messaging_api.MessagingApiBlobAPI
+	Cards  CardReader
+
+	// Metrics 是 /metrics 的指標，Ready 是 /readyz 的連線檢查，Tracer 記錄名片處理各階段的 span
+	Metrics *Metrics
+	Ready   *Readiness
+	Tracer  trace.Tracer
+
+	// Idempotency 記錄已處理的 webhook 事件，略過 LINE 重送的事件
+	Idempotency IdempotencyStore
+
+	// Gemini 與 Notion 的重試策略與斷路器，nil 時不重試
+	Gemini *Dependency
+	Notion *Dependency
+
+	// Limiter 限制每位用戶與每個群組的掃描與搜尋次數，nil 時不限制
+	Limiter Limiter
+
+	// Prompts 是版本化的名片辨識提示，nil 時使用 CARD_PROMPT
+	Prompts *PromptRegistry
+
+	// Tokens 記錄每位用戶的 Gemini token 用量與估計費用，nil 時不記錄
+	Tokens *TokenMeter
+
+	// Companies 正規化公司名稱，設定公司登記資料來源時補上統一編號
+	Companies *CompanyDirectory
+
+	// 以下為選用的功能，由 main 依照設定建立，nil 時不啟用
+	Cipher      *FieldCipher   // 個資欄位加密，nil 時以明文保存
+	Semantic    *SemanticIndex // 語意搜尋索引
+	Transcriber Transcriber    // 語音備註的語音轉文字
+	Chat        ChatModel      // 撰寫追蹤信的對話模型
+	Images      BlobStore      // 名片照片與匯出檔的儲存
+	Exports     *ExportStore   // 匯出檔的到期時間，到期後從 Images 刪除
+	Reminders   *ReminderStore // 追蹤提醒
+	Consents    *ConsentStore  // 新好友的資料保存同意紀錄
+	Purges      *PurgeQueue    // 封鎖 bot 或「刪除我的資料」的刪除排程
+	Audit       *AuditLog      // 個資處理的稽核紀錄
+	CardJobs    *CardQueue     // Gemini 或 Notion 暫時無法使用時延後處理的名片
+
+	// state 是存在記憶體中的對話狀態與搜尋索引
+	state localState
+
+	// jobs 是 webhook 回覆後仍在背景執行的工作，關閉時會等待完成
+	jobs sync.WaitGroup
+}
+
+// NewServer 依照設定建立 LINE Messaging API 的 client、名片辨識服務、指標、readiness 檢查、Gemini 用量紀錄、使用次數限制、名片辨識提示與已處理事件的紀錄。
+func NewServer(cfg *Config) (*Server, error) {
+	bot, err := messaging_api.NewMessagingApiAPI(cfg.ChannelAccessToken)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := messaging_api.NewMessagingApiBlobAPI(cfg.ChannelAccessToken)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		Config:  cfg,
+		Bot:     bot,
+		Blob:    blob,
+		Metrics: NewMetrics(),
+		Gemini:  cfg.NewDependency("gemini", cfg.GeminiTimeout),
+		Notion:  cfg.NewDependency("notion", cfg.NotionTimeout),
+
+		Companies: NewCompanyDirectory(nil),
+		state:     newLocalState(),
+	}
+	// Gemini 的 token 用量與估計費用存放在本地檔案，當天費用超過 GEMINI_DAILY_BUDGET 時推播給管理員
+	prices, err := ParseModelPrices(cfg.GeminiPrices)
+	if err != nil {
+		return nil, err
+	}
+	if s.Tokens, err = NewTokenMeter(cfg.TokenUsagePath, prices, cfg.GeminiDailyBudget); err != nil {
+		return nil, err
+	}
+	s.Tokens.Metrics = s.Metrics
+	s.Tokens.Alert = s.alertTokenBudget
+	s.Cards = &GeminiCardReader{APIKey: cfg.GeminiAPIKey, Meter: s.Tokens}
+	s.Ready = &Readiness{Probes: s.readinessProbes(), TTL: ReadinessCacheTTL}
+
+	if cfg.UsageStorePath == "" {
+		s.Limiter = NewMemoryLimiter(cfg.Limits())
+	} else if s.Limiter, err = NewFileLimiter(cfg.UsageStorePath, cfg.Limits()); err != nil {
+		return nil, err
+	}
+	if cfg.PromptDir != "" {
+		if s.Prompts, err = LoadPromptRegistry(cfg.PromptDir, cfg.PromptAssignmentsPath, cfg.CardPrompt); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.EventStorePath == "" {
+		s.Idempotency = NewMemoryIdempotencyStore(cfg.EventTTL)
+	} else if s.Idempotency, err = NewFileIdempotencyStore(cfg.EventStorePath, cfg.EventTTL); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// newNotionDB 建立 uid 的名片簿，Notion 的 log 使用 ctx 中事件的 logger。
+// 每個請求經過 s.Notion 的重試與斷路器，期限為 NOTION_TIMEOUT。
+func (s *Server) newNotionDB(ctx context.Context, uid string) *NotionDB {
+	client := &http.Client{Transport: s.Notion.Transport(s.Metrics.NotionTransport(nil))}
+	if s.Notion != nil {
+		client.Timeout = s.Notion.Timeout
+	}
+	return &NotionDB{
+		DatabaseID: s.Config.NotionDatabaseID,
+		Token:      s.Config.NotionToken,
+		UID:        uid,
+		BaseURL:    s.Config.NotionBaseURL,
+		HTTPClient: client,
+		Cipher:     s.Cipher,
+		Log:        loggerFrom(ctx),
+	}
+}
+
+// Go 在背景執行 fn，關閉伺服器時會等待 fn 結束。
+func (s *Server) Go(fn func()) {
+	s.jobs.Add(1)
+	go func() {
+		defer s.jobs.Done()
+		fn()
+	}()
+}
+
+// Wait 等待所有背景工作結束，ctx 先結束時回傳 ctx 的錯誤。
+func (s *Server) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.jobs.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// newHTTPServer 建立設定好逾時的 HTTP 伺服器。
+func (s *Server) newHTTPServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: s.Config.ReadTimeout,
+		ReadTimeout:       s.Config.ReadTimeout,
+		WriteTimeout:      s.Config.WriteTimeout,
+		IdleTimeout:       s.Config.IdleTimeout,
+	}
+}
+
+// Serve 在 ln 上提供 handler，直到 ctx 結束（收到 SIGTERM）。結束時先停止接受新的請求，
+// 等待處理中的請求與背景工作完成，最多等待 ShutdownTimeout。
+func (s *Server) Serve(ctx context.Context, ln net.Listener, handler http.Handler) error {
+	srv := s.newHTTPServer(handler)
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("Shutting down, waiting for in-flight requests and jobs")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.Config.ShutdownTimeout)
+	defer cancel()
+	err := srv.Shutdown(shutdownCtx)
+	if waitErr := s.Wait(shutdownCtx); waitErr != nil {
+		err = errors.Join(err, errors.New("background jobs did not finish before the shutdown timeout"))
+	}
+	if serveErr := <-errc; serveErr != nil && !errors.Is(serveErr, http.ErrServerClosed) {
+		err = errors.Join(err, serveErr)
+	}
+	return err
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCallbackHandlerBodyLimit(t *testing.T) {
+	h := newWebhookHarness(t)
+	h.Server.Config.MaxBodyBytes = 1024
+
+	body := `{"destination":"Ubot","events":[` + strings.Repeat(" ", 2048) + `]}`
+	req := httptest.NewRequest(http.MethodPost, "/callback", strings.NewReader(body))
+	req.Header.Set("X-Line-Signature", signWebhook(h.Server.Config.ChannelSecret, []byte(body)))
+	rec := httptest.NewRecorder()
+	h.Server.callbackHandler(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want 413", rec.Code)
+	}
+
+	// 上限內的請求正常處理
+	if status := h.Post(h.Follow(userSource("U1"))); status != http.StatusOK {
+		t.Errorf("status = %d, want 200", status)
+	}
+}
+
+func TestServeGracefulShutdown(t *testing.T) {
+	s := &Server{Config: DefaultConfig()}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+		io.WriteString(w, "done")
+	})
+	jobDone := make(chan struct{})
+	s.Go(func() {
+		<-release
+		close(jobDone)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(ctx, ln, handler) }()
+
+	resp := make(chan string, 1)
+	go func() {
+		res, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			resp <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+		data, _ := io.ReadAll(res.Body)
+		resp <- string(data)
+	}()
+	<-entered
+
+	// 收到關閉訊號後，處理中的請求與背景工作完成前不會結束
+	cancel()
+	select {
+	case err := <-served:
+		t.Fatalf("Serve() returned %v before in-flight work finished", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-served; err != nil {
+		t.Errorf("Serve() = %v", err)
+	}
+	if got := <-resp; got != "done" {
+		t.Errorf("in-flight response = %q, want done", got)
+	}
+	select {
+	case <-jobDone:
+	default:
+		t.Error("background job did not finish before Serve() returned")
+	}
+}
+
+func TestServeShutdownTimeout(t *testing.T) {
+	s := &Server{Config: DefaultConfig()}
+	s.Config.ShutdownTimeout = 20 * time.Millisecond
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stuck := make(chan struct{})
+	defer close(stuck)
+	s.Go(func() { <-stuck })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.Serve(ctx, ln, http.NotFoundHandler()); err == nil || !strings.Contains(err.Error(), "shutdown timeout") {
+		t.Errorf("Serve() = %v, want a shutdown timeout error", err)
+	}
+}
+
+func TestNewHTTPServerTimeouts(t *testing.T) {
+	s := &Server{Config: DefaultConfig()}
+	srv := s.newHTTPServer(http.NotFoundHandler())
+	if srv.ReadTimeout != 10*time.Second || srv.ReadHeaderTimeout != 10*time.Second || srv.WriteTimeout != time.Minute || srv.IdleTimeout != 2*time.Minute {
+		t.Errorf("timeouts = %v %v %v %v", srv.ReadTimeout, srv.ReadHeaderTimeout, srv.WriteTimeout, srv.IdleTimeout)
+	}
+}
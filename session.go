@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/webhook"
+)
+
+// localState 是存在記憶體中的對話狀態與模糊搜尋索引，重新啟動後不保留。
+type localState struct {
+	fuzzy     *SearchIndex              // 本地模糊搜尋索引，Notion 的 Contains 查詢沒有結果時使用
+	notes     *sessionStore[string]     // 按下「新增備註」的用戶接下來要補充哪一張名片（sessionKey -> PageID）
+	emails    *sessionStore[emailDraft] // 每位用戶正在修改的追蹤信草稿（sessionKey -> emailDraft）
+	recent    *sessionStore[string]     // 每位用戶最近新增的名片（sessionKey -> PageID），在 VoiceNoteWindow 內有效
+	sent      *sessionStore[string]     // 送出的名片訊息對應哪一張名片（message ID -> PageID），讓用戶可以引用回覆
+	languages *sessionStore[string]     // 用戶在 LINE 設定的語言，避免每次掃描都查詢個人資料
+}
+
+// newLocalState 建立空的對話狀態。
+func newLocalState() localState {
+	return localState{
+		fuzzy:     NewSearchIndex(),
+		notes:     newSessionStore[string](10 * time.Minute),
+		emails:    newSessionStore[emailDraft](30 * time.Minute),
+		recent:    newSessionStore[string](VoiceNoteWindow),
+		sent:      newSessionStore[string](7 * 24 * time.Hour),
+		languages: newSessionStore[string](24 * time.Hour),
+	}
+}
+
+// sessionKey 回傳 source 的對話狀態 key：一對一聊天為用戶 ID，群組與聊天室中為「群組 ID/用戶 ID」，
+// 同一個群組的成員不會接續別人的對話，也不會把群組的名片補充到自己的名片簿。
+func sessionKey(source webhook.SourceInterface) string {
+	return sessionKeyFor(getBookID(source), getUserID(source))
+}
+
+// sessionKeyFor 回傳名片簿 bookID 中用戶 userID 的對話狀態 key。
+func sessionKeyFor(bookID, userID string) string {
+	if bookID == userID || userID == "" {
+		return bookID
+	}
+	return bookID + "/" + userID
+}
+
+// sessionKeyCtx 是 ctx 中對話狀態 key 的 key。
+type sessionKeyCtx struct{}
+
+// withSessionKey 回傳帶有傳送者對話狀態 key（見 sessionKey）的 ctx。
+func withSessionKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, sessionKeyCtx{}, key)
+}
+
+// sessionKeyFrom 回傳 ctx 中傳送者的對話狀態 key，沒有時為名片簿 bookID。
+func sessionKeyFrom(ctx context.Context, bookID string) string {
+	if key, ok := ctx.Value(sessionKeyCtx{}).(string); ok && key != "" {
+		return key
+	}
+	return bookID
+}
+
+// sessionStore 是有過期時間的記憶體 key/value 儲存，用來保存對話中的暫時狀態。
+type sessionStore[T any] struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	items map[string]sessionItem[T]
+}
+
+type sessionItem[T any] struct {
+	value   T
+	expires time.Time
+}
+
+// newSessionStore 建立一個 sessionStore，每筆資料在 ttl 之後過期。
+func newSessionStore[T any](ttl time.Duration) *sessionStore[T] {
+	return &sessionStore[T]{ttl: ttl, items: make(map[string]sessionItem[T])}
+}
+
+// Set 儲存一筆資料並重設過期時間，同時清除已過期的資料。
+func (s *sessionStore[T]) Set(key string, value T) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for k, item := range s.items {
+		if now.After(item.expires) {
+			delete(s.items, k)
+		}
+	}
+	s.items[key] = sessionItem[T]{value: value, expires: now.Add(s.ttl)}
+}
+
+// Get 取得尚未過期的資料。
+func (s *sessionStore[T]) Get(key string) (T, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.items[key]
+	if !ok || time.Now().After(item.expires) {
+		var zero T
+		return zero, false
+	}
+	return item.value, true
+}
+
+// Delete 移除一筆資料。
+func (s *sessionStore[T]) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.items, key)
+}
+
+// DeleteUser 移除 uid 在一對一聊天與所有群組中的資料（見 sessionKey）。
+func (s *sessionStore[T]) DeleteUser(uid string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.items {
+		if key == uid || strings.HasSuffix(key, "/"+uid) {
+			delete(s.items, key)
+		}
+	}
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+	"google.golang.org/api/option"
+)
+
+// TokenUsageRetentionDays 是用量紀錄保留的天數，更早的紀錄在寫入時刪除。
+const TokenUsageRetentionDays = 90
+
+// UsageMetadata 是 Gemini generateContent 回應中的 token 用量。
+type UsageMetadata struct {
+	PromptTokenCount     int `json:"promptTokenCount"`
+	CandidatesTokenCount int `json:"candidatesTokenCount"`
+	TotalTokenCount      int `json:"totalTokenCount"`
+}
+
+// ModelPrice 是模型每一百萬 token 的價格（美元）。
+type ModelPrice struct {
+	Input  float64
+	Output float64
+}
+
+// Cost 回傳一次呼叫的估計費用（美元）。
+func (p ModelPrice) Cost(u UsageMetadata) float64 {
+	return (float64(u.PromptTokenCount)*p.Input + float64(u.CandidatesTokenCount)*p.Output) / 1e6
+}
+
+// ParseModelPrices 解析 GEMINI_PRICES：以逗號分隔的「模型=輸入價格/輸出價格」，價格為每一百萬 token 的美元。
+func ParseModelPrices(spec string) (map[string]ModelPrice, error) {
+	prices := make(map[string]ModelPrice)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		model, price, ok := strings.Cut(item, "=")
+		input, output, ok2 := strings.Cut(price, "/")
+		if !ok || !ok2 || strings.TrimSpace(model) == "" {
+			return nil, fmt.Errorf("invalid price %q, want model=input/output", item)
+		}
+		in, err := strconv.ParseFloat(strings.TrimSpace(input), 64)
+		if err != nil || in < 0 {
+			return nil, fmt.Errorf("invalid input price in %q", item)
+		}
+		out, err := strconv.ParseFloat(strings.TrimSpace(output), 64)
+		if err != nil || out < 0 {
+			return nil, fmt.Errorf("invalid output price in %q", item)
+		}
+		prices[strings.TrimSpace(model)] = ModelPrice{Input: in, Output: out}
+	}
+	return prices, nil
+}
+
+// TokenUsage 是 Gemini 的呼叫次數、token 數與估計費用（美元）。
+type TokenUsage struct {
+	Calls            int     `json:"calls"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CandidatesTokens int     `json:"candidates_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	Cost             float64 `json:"cost_usd"`
+}
+
+func (u *TokenUsage) add(o TokenUsage) {
+	u.Calls += o.Calls
+	u.PromptTokens += o.PromptTokens
+	u.CandidatesTokens += o.CandidatesTokens
+	u.TotalTokens += o.TotalTokens
+	u.Cost += o.Cost
+}
+
+// tokenUsageState 是 TokenMeter 需要保存的狀態。
+type tokenUsageState struct {
+	Days    map[string]map[string]map[string]*TokenUsage `json:"days"`              // 日期 -> 用戶或群組 -> 模型 -> 用量
+	Alerted map[string]bool                              `json:"alerted,omitempty"` // 已經送出預算警示的日期
+}
+
+// TokenMeter 依照台北時間的日期、用戶與模型記錄 Gemini 的 token 用量與估計費用。
+// path 為空時只存在記憶體。
+type TokenMeter struct {
+	Prices  map[string]ModelPrice
+	Budget  float64                            // 每天的預算（美元），0 表示不警示
+	Alert   func(day string, total TokenUsage) // 當天的費用第一次超過 Budget 時呼叫
+	Metrics *Metrics
+
+	mu    sync.Mutex
+	path  string
+	state tokenUsageState
+}
+
+// NewTokenMeter 建立用量紀錄，若 path 不為空則從檔案載入過去的用量。
+func NewTokenMeter(path string, prices map[string]ModelPrice, budget float64) (*TokenMeter, error) {
+	m := &TokenMeter{Prices: prices, Budget: budget, path: path}
+	if path != "" {
+		if err := loadJSONFile(path, &m.state); err != nil {
+			return nil, fmt.Errorf("error loading token usage: %w", err)
+		}
+	}
+	if m.state.Days == nil {
+		m.state.Days = make(map[string]map[string]map[string]*TokenUsage)
+	}
+	if m.state.Alerted == nil {
+		m.state.Alerted = make(map[string]bool)
+	}
+	return m, nil
+}
+
+// Record 記錄 subject 的一次 Gemini 呼叫。沒有設定價格的模型只記錄 token 數。
+func (m *TokenMeter) Record(subject, model string, usage UsageMetadata, now time.Time) error {
+	u := TokenUsage{
+		Calls:            1,
+		PromptTokens:     usage.PromptTokenCount,
+		CandidatesTokens: usage.CandidatesTokenCount,
+		TotalTokens:      usage.TotalTokenCount,
+		Cost:             m.Prices[model].Cost(usage),
+	}
+	day := now.In(reminderLocation()).Format("2006-01-02")
+
+	m.mu.Lock()
+	if m.state.Days[day] == nil {
+		m.state.Days[day] = make(map[string]map[string]*TokenUsage)
+		m.prune(now)
+	}
+	if m.state.Days[day][subject] == nil {
+		m.state.Days[day][subject] = make(map[string]*TokenUsage)
+	}
+	if m.state.Days[day][subject][model] == nil {
+		m.state.Days[day][subject][model] = &TokenUsage{}
+	}
+	m.state.Days[day][subject][model].add(u)
+
+	total := m.total(day)
+	alert := m.Budget > 0 && total.Cost >= m.Budget && !m.state.Alerted[day]
+	if alert {
+		m.state.Alerted[day] = true
+	}
+	var err error
+	if m.path != "" {
+		err = saveJSONFile(m.path, m.state)
+	}
+	m.mu.Unlock()
+
+	m.Metrics.GeminiUsage(model, usage, u.Cost)
+	if alert && m.Alert != nil {
+		m.Alert(day, total)
+	}
+	return err
+}
+
+// Forget 將 subject 的用量併入沒有歸屬對象的用量，刪除用戶資料後不再留下用戶 ID，每日的總用量不變。
+func (m *TokenMeter) Forget(subject string) error {
+	if subject == "" {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	changed := false
+	for _, bySubject := range m.state.Days {
+		byModel, ok := bySubject[subject]
+		if !ok {
+			continue
+		}
+		if bySubject[""] == nil {
+			bySubject[""] = make(map[string]*TokenUsage)
+		}
+		for model, u := range byModel {
+			if bySubject[""][model] == nil {
+				bySubject[""][model] = &TokenUsage{}
+			}
+			bySubject[""][model].add(*u)
+		}
+		delete(bySubject, subject)
+		changed = true
+	}
+	if !changed || m.path == "" {
+		return nil
+	}
+	return saveJSONFile(m.path, m.state)
+}
+
+// Day 回傳 day（2006-01-02）依用戶與依模型加總的用量，以及當天的總用量。
+func (m *TokenMeter) Day(day string) (subjects, models map[string]TokenUsage, total TokenUsage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	subjects = make(map[string]TokenUsage)
+	models = make(map[string]TokenUsage)
+	for subject, byModel := range m.state.Days[day] {
+		for model, u := range byModel {
+			s := subjects[subject]
+			s.add(*u)
+			subjects[subject] = s
+			md := models[model]
+			md.add(*u)
+			models[model] = md
+			total.add(*u)
+		}
+	}
+	return subjects, models, total
+}
+
+// total 回傳 day 的總用量，呼叫前需持有鎖。
+func (m *TokenMeter) total(day string) TokenUsage {
+	var total TokenUsage
+	for _, byModel := range m.state.Days[day] {
+		for _, u := range byModel {
+			total.add(*u)
+		}
+	}
+	return total
+}
+
+// prune 刪除超過 TokenUsageRetentionDays 天的紀錄，呼叫前需持有鎖。
+func (m *TokenMeter) prune(now time.Time) {
+	cutoff := now.In(reminderLocation()).AddDate(0, 0, -TokenUsageRetentionDays).Format("2006-01-02")
+	for day := range m.state.Days {
+		if day < cutoff {
+			delete(m.state.Days, day)
+			delete(m.state.Alerted, day)
+		}
+	}
+}
+
+// tokenSubjectKey 是 ctx 中用量歸屬的用戶 ID 的 key。
+type tokenSubjectKey struct{}
+
+// withTokenSubject 回傳帶有用量歸屬對象的 ctx，這個 ctx 中的 Gemini 呼叫記在 subject 名下。
+func withTokenSubject(ctx context.Context, subject string) context.Context {
+	return context.WithValue(ctx, tokenSubjectKey{}, subject)
+}
+
+// tokenSubjectFrom 回傳 ctx 中的用量歸屬對象，沒有時為空字串。
+func tokenSubjectFrom(ctx context.Context) string {
+	subject, _ := ctx.Value(tokenSubjectKey{}).(string)
+	return subject
+}
+
+// newGeminiClient 建立 Gemini client，請求經過 geminiTransport 以記錄 token 用量到 meter，meter 為 nil 時不記錄。
+func newGeminiClient(ctx context.Context, apiKey string, meter *TokenMeter) (*genai.Client, error) {
+	return genai.NewClient(ctx, option.WithHTTPClient(&http.Client{
+		Transport: &geminiTransport{APIKey: apiKey, Meter: meter},
+	}))
+}
+
+// geminiTransport 為 Gemini REST API 的請求加上 API key，並從 generateContent 與 embedContent 的回應讀取 usageMetadata 記錄到 Meter。
+// genai 的回應型別沒有 token 用量，所以在 HTTP 層讀取。embedContent 的回應沒有 token 數時只記錄呼叫次數。
+type geminiTransport struct {
+	APIKey string
+	Meter  *TokenMeter
+	Next   http.RoundTripper
+}
+
+func (t *geminiTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := t.Next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("x-goog-api-key", t.APIKey)
+	resp, err := next.RoundTrip(req)
+	if err != nil || t.Meter == nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	model, method, ok := strings.Cut(path.Base(req.URL.Path), ":")
+	embedding := method == "embedContent" || method == "batchEmbedContents"
+	if !ok || (method != "generateContent" && !embedding) {
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	var data struct {
+		UsageMetadata *UsageMetadata `json:"usageMetadata"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return resp, nil
+	}
+	if data.UsageMetadata == nil {
+		if !embedding {
+			return resp, nil
+		}
+		data.UsageMetadata = &UsageMetadata{}
+	}
+	if err := t.Meter.Record(tokenSubjectFrom(req.Context()), model, *data.UsageMetadata, time.Now()); err != nil {
+		loggerFrom(req.Context()).Error("Error saving token usage", "err", err)
+	}
+	return resp, nil
+}
+
+// alertTokenBudget 推播預算警示給所有管理員。
+func (s *Server) alertTokenBudget(day string, total TokenUsage) {
+	text := fmt.Sprintf("Gemini %s 的估計費用 US$%.2f 已超過每日預算 US$%.2f（%d 次呼叫，%d tokens）",
+		day, total.Cost, s.Config.GeminiDailyBudget, total.Calls, total.TotalTokens)
+	for _, uid := range s.Config.Admins() {
+		if _, err := s.Bot.PushMessage(&messaging_api.PushMessageRequest{
+			To:       uid,
+			Messages: []messaging_api.MessageInterface{&messaging_api.TextMessage{Text: text}},
+		}, ""); err != nil {
+			slog.Error("Error pushing budget alert", "err", err)
+		}
+	}
+}
+
+// tokenUsageCommandPattern 是管理員的用量指令：「用量」查詢今天，「用量 2026-05-01」查詢指定日期。
+var tokenUsageCommandPattern = regexp.MustCompile(`^用量(?:\s+(\d{4}-\d{2}-\d{2}))?$`)
+
+// tokenUsageTopSubjects 是用量指令列出的用戶或群組數。
+const tokenUsageTopSubjects = 10
+
+// handleTokenUsageText 處理管理員的用量指令，回傳是否已處理。不是管理員時不處理，訊息會當作一般的搜尋。
+func (s *Server) handleTokenUsageText(ctx context.Context, replyToken, uid, text string) bool {
+	m := tokenUsageCommandPattern.FindStringSubmatch(strings.TrimSpace(text))
+	if m == nil || s.Tokens == nil || !s.Config.IsAdmin(uid) {
+		return false
+	}
+	day := m[1]
+	if day == "" {
+		day = time.Now().In(reminderLocation()).Format("2006-01-02")
+	}
+	subjects, models, total := s.Tokens.Day(day)
+
+	lines := []string{fmt.Sprintf("%s 的 Gemini 用量：", day), "・" + formatTokenUsage(total)}
+	if s.Config.GeminiDailyBudget > 0 {
+		lines = append(lines, fmt.Sprintf("・每日預算 US$%.2f", s.Config.GeminiDailyBudget))
+	}
+	if len(models) > 0 {
+		lines = append(lines, "模型：")
+		for _, name := range sortedByCost(models) {
+			lines = append(lines, fmt.Sprintf("・%s：%s", name, formatTokenUsage(models[name])))
+		}
+	}
+	if len(subjects) > 0 {
+		lines = append(lines, "用戶：")
+		names := sortedByCost(subjects)
+		if len(names) > tokenUsageTopSubjects {
+			names = names[:tokenUsageTopSubjects]
+		}
+		for _, name := range names {
+			label := name
+			if label == "" {
+				label = "（其他）"
+			}
+			lines = append(lines, fmt.Sprintf("・%s：%s", label, formatTokenUsage(subjects[name])))
+		}
+	}
+	if err := s.replyText(ctx, replyToken, strings.Join(lines, "\n")); err != nil {
+		loggerFrom(ctx).Error("Error replying", "err", err)
+	}
+	return true
+}
+
+// formatTokenUsage 回傳用量指令中一行的用量說明。
+func formatTokenUsage(u TokenUsage) string {
+	return fmt.Sprintf("%d 次，輸入 %d／輸出 %d tokens，約 US$%.4f", u.Calls, u.PromptTokens, u.CandidatesTokens, u.Cost)
+}
+
+// sortedByCost 回傳依費用由高到低排序的 key，費用相同時依 key 排序。
+func sortedByCost(usage map[string]TokenUsage) []string {
+	keys := make([]string, 0, len(usage))
+	for key := range usage {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if usage[keys[i]].Cost != usage[keys[j]].Cost {
+			return usage[keys[i]].Cost > usage[keys[j]].Cost
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseModelPrices(t *testing.T) {
+	prices, err := ParseModelPrices("gemini-pro=0.5/1.5, gemini-1.5-pro-latest = 3.5/10.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := prices["gemini-1.5-pro-latest"]; p.Input != 3.5 || p.Output != 10.5 {
+		t.Errorf("price = %+v", p)
+	}
+	for _, spec := range []string{"gemini-pro", "gemini-pro=0.5", "=1/2", "gemini-pro=a/1", "gemini-pro=1/-2"} {
+		if _, err := ParseModelPrices(spec); err == nil {
+			t.Errorf("ParseModelPrices(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestTokenMeter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token_usage.json")
+	prices := map[string]ModelPrice{"gemini-pro-vision": {Input: 1, Output: 2}}
+	m, err := NewTokenMeter(path, prices, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var alerts []TokenUsage
+	m.Alert = func(day string, total TokenUsage) {
+		if day != "2026-05-02" {
+			t.Errorf("alert day = %s", day)
+		}
+		alerts = append(alerts, total)
+	}
+	// 台北時間 2026-05-02 00:30
+	now := time.Date(2026, 5, 1, 16, 30, 0, 0, time.UTC)
+
+	// 每次 1M 輸入與 0.5M 輸出：US$2
+	usage := UsageMetadata{PromptTokenCount: 1000000, CandidatesTokenCount: 500000, TotalTokenCount: 1500000}
+	m.Record("U1", "gemini-pro-vision", usage, now)
+	m.Record("G1", "gemini-pro", UsageMetadata{PromptTokenCount: 10, CandidatesTokenCount: 5, TotalTokenCount: 15}, now)
+	if len(alerts) != 0 {
+		t.Fatalf("alert before reaching the budget: %+v", alerts)
+	}
+	m.Record("U1", "gemini-pro-vision", usage, now)
+	m.Record("U1", "gemini-pro-vision", usage, now)
+	if len(alerts) != 1 || alerts[0].Cost != 4 {
+		t.Fatalf("alerts = %+v, want one alert at US$4", alerts)
+	}
+
+	// 重新開啟後保留用量，同一天不再警示
+	m, err = NewTokenMeter(path, prices, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Alert = func(string, TokenUsage) { t.Error("alerted twice on the same day") }
+	m.Record("U1", "gemini-pro-vision", usage, now)
+
+	subjects, models, total := m.Day("2026-05-02")
+	if total.Calls != 5 || math.Abs(total.Cost-8) > 1e-9 {
+		t.Errorf("total = %+v", total)
+	}
+	if u := subjects["U1"]; u.Calls != 4 || u.PromptTokens != 4000000 || u.CandidatesTokens != 2000000 {
+		t.Errorf("U1 = %+v", u)
+	}
+	// 沒有價格的模型只記錄 token 數
+	if u := models["gemini-pro"]; u.Calls != 1 || u.TotalTokens != 15 || u.Cost != 0 {
+		t.Errorf("gemini-pro = %+v", u)
+	}
+	if _, _, total := m.Day("2026-05-01"); total.Calls != 0 {
+		t.Errorf("usage recorded on the UTC day: %+v", total)
+	}
+}
+
+func TestGeminiTransportRecordsUsage(t *testing.T) {
+	var apiKey string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiKey = r.Header.Get("x-goog-api-key")
+		io.WriteString(w, `{"candidates":[{"content":{"parts":[{"text":"hi"}]}}],"usageMetadata":{"promptTokenCount":120,"candidatesTokenCount":30,"totalTokenCount":150}}`)
+	}))
+	t.Cleanup(srv.Close)
+
+	meter, _ := NewTokenMeter("", nil, 0)
+	client := &http.Client{Transport: &geminiTransport{APIKey: "key", Meter: meter}}
+	ctx := withTokenSubject(context.Background(), "U1")
+	for _, method := range []string{"generateContent", "countTokens"} {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL+"/v1beta/models/gemini-pro:"+method, strings.NewReader("{}"))
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		// 讀取用量後 genai 仍然可以讀到完整的回應
+		if !strings.Contains(string(body), `"text":"hi"`) {
+			t.Errorf("body = %s", body)
+		}
+	}
+	if apiKey != "key" {
+		t.Errorf("x-goog-api-key = %q", apiKey)
+	}
+
+	subjects, models, total := meter.Day(time.Now().In(reminderLocation()).Format("2006-01-02"))
+	if total.Calls != 1 || total.PromptTokens != 120 || total.CandidatesTokens != 30 {
+		t.Errorf("total = %+v, want only the generateContent call", total)
+	}
+	if subjects["U1"].Calls != 1 || models["gemini-pro"].Calls != 1 {
+		t.Errorf("subjects = %+v, models = %+v", subjects, models)
+	}
+}
+
+func TestWebhookTokenUsage(t *testing.T) {
+	h := newWebhookHarness(t)
+	h.Server.Config.AdminUserIDs = "Uadmin, Uops"
+	h.Server.Config.GeminiDailyBudget = 1
+
+	h.Server.Tokens, _ = NewTokenMeter("", map[string]ModelPrice{"gemini-pro-vision": {Input: 1, Output: 2}}, 1)
+	h.Server.Tokens.Alert = h.Server.alertTokenBudget
+
+	h.Server.Tokens.Record("U1", "gemini-pro-vision", UsageMetadata{PromptTokenCount: 1000000, CandidatesTokenCount: 100000, TotalTokenCount: 1100000}, time.Now())
+	var pushes []string
+	for _, c := range h.Calls() {
+		if c.Path == "/v2/bot/message/push" {
+			pushes = append(pushes, string(c.Body))
+		}
+	}
+	if len(pushes) != 2 || !strings.Contains(pushes[0], `"to":"Uadmin"`) || !strings.Contains(pushes[1], `"to":"Uops"`) || !strings.Contains(pushes[0], "US$1.20") {
+		t.Fatalf("budget alerts = %v", pushes)
+	}
+
+	// 一般用戶的用量指令當作搜尋，管理員可以查詢今天的用量
+	h.Post(h.Text(userSource("U1"), "用量"))
+	h.Post(h.Text(userSource("Uadmin"), "用量"))
+	replies := h.Replies()
+	if len(replies) != 2 || strings.Contains(replies[0], "Gemini 用量") {
+		t.Fatalf("replies = %v", replies)
+	}
+	for _, want := range []string{"gemini-pro-vision：1 次", "U1：1 次，輸入 1000000／輸出 100000 tokens，約 US$1.2000", "每日預算 US$1.00"} {
+		if !strings.Contains(replies[1], want) {
+			t.Errorf("usage reply = %s, want %q", replies[1], want)
+		}
+	}
+}
+
+func TestGeminiTransportRecordsEmbeddingCalls(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"embedding":{"values":[0.1,0.2]}}`)
+	}))
+	t.Cleanup(srv.Close)
+
+	meter, _ := NewTokenMeter("", nil, 0)
+	client := &http.Client{Transport: &geminiTransport{APIKey: "key", Meter: meter}}
+	ctx := withTokenSubject(context.Background(), "U1")
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL+"/v1beta/models/embedding-001:embedContent", strings.NewReader("{}"))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// embedContent 的回應沒有 token 數，只記錄呼叫次數
+	subjects, _, _ := meter.Day(time.Now().In(reminderLocation()).Format("2006-01-02"))
+	if u := subjects["U1"]; u.Calls != 1 || u.TotalTokens != 0 {
+		t.Errorf("U1 = %+v, want 1 call", u)
+	}
+}
+
+// subjectCardReader 記錄辨識名片時 ctx 中的用量歸屬對象。
+type subjectCardReader struct {
+	CardReader
+	subjects []string
+}
+
+func (r *subjectCardReader) ReadCard(ctx context.Context, imgData []byte, prompt string) (string, error) {
+	r.subjects = append(r.subjects, tokenSubjectFrom(ctx))
+	return r.CardReader.ReadCard(ctx, imgData, prompt)
+}
+
+func TestWebhookTokenSubjectIsSender(t *testing.T) {
+	h := newWebhookHarness(t)
+	h.SetCard("m1", "```json\n{\"name\":\"王小明\"}\n```")
+	reader := &subjectCardReader{CardReader: h.Server.Cards}
+	h.Server.Cards = reader
+
+	// 群組中掃描名片時，用量記在傳送照片的成員名下，不是群組
+	h.Post(h.Image(groupSource("G1", "U1"), "m1"))
+	if len(reader.subjects) != 1 || reader.subjects[0] != "U1" {
+		t.Errorf("subjects = %v, want [U1]", reader.subjects)
+	}
+}
+
+func TestTokenMeterForget(t *testing.T) {
+	m, _ := NewTokenMeter("", nil, 0)
+	now := time.Date(2026, 5, 2, 9, 0, 0, 0, time.UTC)
+	m.Record("U1", "gemini-pro", UsageMetadata{TotalTokenCount: 10}, now)
+	m.Record("U2", "gemini-pro", UsageMetadata{TotalTokenCount: 5}, now)
+
+	if err := m.Forget("U1"); err != nil {
+		t.Fatal(err)
+	}
+	// 刪除用戶資料後不再有用戶 ID，總用量不變
+	subjects, _, total := m.Day("2026-05-02")
+	if _, ok := subjects["U1"]; ok {
+		t.Errorf("subjects = %+v, want U1 removed", subjects)
+	}
+	if subjects[""].TotalTokens != 10 || subjects["U2"].TotalTokens != 5 || total.TotalTokens != 15 {
+		t.Errorf("subjects = %+v, total = %+v", subjects, total)
+	}
+}
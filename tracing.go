@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName 是 bot 建立 span 使用的 instrumentation 名稱。
+const tracerName = "github.com/kkdai/linebot-smart-namecard"
+
+// NewTracerProvider 建立以 OTLP/HTTP 匯出 span 到 OTEL_EXPORTER_OTLP_ENDPOINT 的 TracerProvider，
+// 沒有設定 endpoint 時回傳 nil，不記錄 trace。
+func (c *Config) NewTracerProvider(ctx context.Context) (*sdktrace.TracerProvider, error) {
+	if c.OTLPEndpoint == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(c.OTLPEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("OTEL_EXPORTER_OTLP_ENDPOINT: %w", err)
+	}
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host)}
+	if u.Scheme == "http" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if path := strings.TrimSuffix(u.Path, "/"); path != "" {
+		opts = append(opts, otlptracehttp.WithURLPath(path+"/v1/traces"))
+	}
+	headers, err := parseOTLPHeaders(c.OTLPHeaders)
+	if err != nil {
+		return nil, err
+	}
+	if len(headers) > 0 {
+		opts = append(opts, otlptracehttp.WithHeaders(headers))
+	}
+
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("error creating OTLP exporter: %w", err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(c.ServiceName)))
+	if err != nil {
+		return nil, err
+	}
+	return sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res)), nil
+}
+
+// parseOTLPHeaders 解析 OTEL_EXPORTER_OTLP_HEADERS，格式為 "key1=value1,key2=value2"。
+func parseOTLPHeaders(spec string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(spec, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("OTEL_EXPORTER_OTLP_HEADERS: invalid header %q, want key=value", pair)
+		}
+		value, err := url.QueryUnescape(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("OTEL_EXPORTER_OTLP_HEADERS: %w", err)
+		}
+		headers[strings.TrimSpace(key)] = value
+	}
+	return headers, nil
+}
+
+// tracer 回傳 Server 的 tracer，沒有設定時使用全域的 TracerProvider（預設不記錄）。
+func (s *Server) tracer() trace.Tracer {
+	if s.Tracer != nil {
+		return s.Tracer
+	}
+	return otel.Tracer(tracerName)
+}
+
+// endSpan 結束 span，err 不是 nil 時標記為錯誤。
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// traceHarness 建立記錄 span 到記憶體的 webhook harness。
+func traceHarness(t *testing.T) (*webhookHarness, *tracetest.InMemoryExporter) {
+	t.Helper()
+	h := newWebhookHarness(t)
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	t.Cleanup(func() { tp.Shutdown(context.Background()) })
+	h.Server.Tracer = tp.Tracer(tracerName)
+	return h, exporter
+}
+
+// spansByName 以名稱索引 span。
+func spansByName(spans tracetest.SpanStubs) map[string]tracetest.SpanStub {
+	byName := make(map[string]tracetest.SpanStub)
+	for _, span := range spans {
+		byName[span.Name] = span
+	}
+	return byName
+}
+
+func TestImagePipelineSpans(t *testing.T) {
+	h, exporter := traceHarness(t)
+	h.SetCard("m1", "```json\n{\"name\":\"王小明\",\"email\":\"ming@bank.example\"}\n```")
+
+	h.Post(h.Image(userSource("U1"), "m1"))
+
+	spans := spansByName(exporter.GetSpans())
+	root, ok := spans["ProcessCardImage"]
+	if !ok {
+		t.Fatalf("spans = %v, want ProcessCardImage", exporter.GetSpans())
+	}
+	for _, name := range []string{"GetImageBinary", "ExtractCard", "QueryDuplicates", "AddPageToDatabase", "Reply"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("missing span %s", name)
+			continue
+		}
+		if span.Parent.SpanID() != root.SpanContext.SpanID() || span.SpanContext.TraceID() != root.SpanContext.TraceID() {
+			t.Errorf("span %s is not a child of ProcessCardImage", name)
+		}
+		if span.Status.Code == codes.Error {
+			t.Errorf("span %s status = %v", name, span.Status)
+		}
+	}
+	if attrs := root.Attributes; len(attrs) == 0 || attrs[0] != attribute.String("line.message_id", "m1") {
+		t.Errorf("root attributes = %v", attrs)
+	}
+}
+
+func TestImagePipelineSpansDuplicateAndFailure(t *testing.T) {
+	h, exporter := traceHarness(t)
+	h.AddContact("U1", Person{Name: "王小明", Email: "ming@bank.example"})
+	h.SetCard("dup", "```json\n{\"name\":\"王小明\",\"email\":\"ming@bank.example\"}\n```")
+
+	h.Post(h.Image(userSource("U1"), "dup"))
+	spans := spansByName(exporter.GetSpans())
+	if _, ok := spans["AddPageToDatabase"]; ok {
+		t.Error("duplicate card was added to the database")
+	}
+	dedupe := spans["QueryDuplicates"]
+	if len(dedupe.Attributes) != 1 || dedupe.Attributes[0] != attribute.Bool("contact.duplicate", true) {
+		t.Errorf("QueryDuplicates attributes = %v", dedupe.Attributes)
+	}
+
+	// 辨識失敗時 ExtractCard 標記為錯誤，仍會回覆用戶
+	exporter.Reset()
+	h.Post(h.Image(userSource("U1"), "unknown"))
+	spans = spansByName(exporter.GetSpans())
+	if spans["ExtractCard"].Status.Code != codes.Error {
+		t.Errorf("ExtractCard status = %v, want error", spans["ExtractCard"].Status)
+	}
+	if _, ok := spans["Reply"]; !ok {
+		t.Error("missing Reply span")
+	}
+}
+
+func TestParseOTLPHeaders(t *testing.T) {
+	headers, err := parseOTLPHeaders("x-honeycomb-team=abc, api-key=a%3Db")
+	if err != nil || len(headers) != 2 || headers["x-honeycomb-team"] != "abc" || headers["api-key"] != "a=b" {
+		t.Errorf("parseOTLPHeaders() = %v, %v", headers, err)
+	}
+	if _, err := parseOTLPHeaders("novalue"); err == nil {
+		t.Error("parseOTLPHeaders(novalue) = nil error")
+	}
+}
+
+func TestNewTracerProvider(t *testing.T) {
+	cfg := DefaultConfig()
+	if tp, err := cfg.NewTracerProvider(context.Background()); tp != nil || err != nil {
+		t.Errorf("NewTracerProvider() without endpoint = %v, %v", tp, err)
+	}
+	cfg.OTLPEndpoint = "http://localhost:4318"
+	tp, err := cfg.NewTracerProvider(context.Background())
+	if err != nil || tp == nil {
+		t.Fatalf("NewTracerProvider() = %v, %v", tp, err)
+	}
+	if err := tp.Shutdown(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
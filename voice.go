@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// TranscribePrompt 是請 Gemini 逐字轉錄語音的提示。
+const TranscribePrompt = "請將這段語音逐字轉成文字，只輸出轉錄的內容，不要加上任何說明。"
+
+// VoiceNoteWindow 是掃描名片後可以用語音補充備註的時間。
+const VoiceNoteWindow = 10 * time.Minute
+
+// Transcriber 將語音轉為文字。
+type Transcriber interface {
+	Transcribe(ctx context.Context, audio []byte, mimeType string) (string, error)
+}
+
+// GeminiTranscriber 使用 Gemini 多模態模型轉錄語音。
+type GeminiTranscriber struct {
+	APIKey string
+	Model  string
+	Meter  *TokenMeter // 記錄 token 用量，nil 時不記錄
+}
+
+// Transcribe 呼叫 Gemini 將語音轉為文字。
+func (g *GeminiTranscriber) Transcribe(ctx context.Context, audio []byte, mimeType string) (string, error) {
+	client, err := newGeminiClient(ctx, g.APIKey, g.Meter)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	model := g.Model
+	if model == "" {
+		model = "gemini-1.5-pro-latest"
+	}
+	resp, err := client.GenerativeModel(model).GenerateContent(ctx,
+		genai.Blob{MIMEType: mimeType, Data: audio},
+		genai.Text(TranscribePrompt),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	text, err := printResponse(resp)
+	if err != nil {
+		return "", err
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", fmt.Errorf("empty transcription")
+	}
+	return text, nil
+}
+
+// transcribeVoiceNote 經過 Gemini 的重試與斷路器轉錄語音。
+func (s *Server) transcribeVoiceNote(ctx context.Context, audio []byte) (string, error) {
+	var transcript string
+	err := s.Gemini.Do(ctx, func(ctx context.Context) error {
+		var err error
+		transcript, err = s.Transcriber.Transcribe(ctx, audio, "audio/mp4")
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("error transcribing audio: %w", err)
+	}
+	return transcript, nil
+}
+
+// addVoiceNote 將語音訊息 messageID 轉為文字後，加到傳送者最近新增的名片備註。
+// key 是傳送者的對話狀態 key（見 sessionKey），最近沒有新增名片時不下載語音。
+func (s *Server) addVoiceNote(ctx context.Context, replyToken string, nDB *NotionDB, key, messageID string) {
+	pageID, ok := s.state.recent.Get(key)
+	if !ok {
+		if err := s.replyText(ctx, replyToken, "請先傳送名片照片，再用語音補充備註"); err != nil {
+			loggerFrom(ctx).Error("Error replying", "err", err)
+		}
+		return
+	}
+
+	audio, err := GetImageBinary(s.Blob, messageID)
+	if err != nil {
+		loggerFrom(ctx).Error("Error getting message content", "err", err)
+		return
+	}
+
+	transcript, err := s.transcribeVoiceNote(ctx, audio)
+	if err != nil {
+		loggerFrom(ctx).Error("Error adding voice note", "err", err)
+		if err := s.replyText(ctx, replyToken, "無法辨識語音內容，請重新錄音"); err != nil {
+			loggerFrom(ctx).Error("Error replying", "err", err)
+		}
+		return
+	}
+	// 語音內容可能包含無法遮蔽的個資，只記錄長度
+	loggerFrom(ctx).Info("Got transcript", "chars", len([]rune(transcript)))
+
+	if err := s.updateContactContext(ctx, nDB, pageID, Person{Notes: transcript}, replyToken); err != nil {
+		loggerFrom(ctx).Error("Error adding voice note", "err", err)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeTranscriber 回傳固定的轉錄文字，並記錄收到的語音。
+type fakeTranscriber struct {
+	text  string
+	err   error
+	audio []byte
+}
+
+func (f *fakeTranscriber) Transcribe(_ context.Context, audio []byte, _ string) (string, error) {
+	f.audio = audio
+	return f.text, f.err
+}
+
+func TestTranscribeVoiceNote(t *testing.T) {
+	ft := &fakeTranscriber{text: "在攤位認識，對報價有興趣"}
+	s := &Server{Transcriber: ft, state: newLocalState()}
+
+	transcript, err := s.transcribeVoiceNote(context.Background(), []byte("audio"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transcript != ft.text || string(ft.audio) != "audio" {
+		t.Errorf("got %q, want %q", transcript, ft.text)
+	}
+
+	ft.err = errors.New("quota exceeded")
+	if _, err := s.transcribeVoiceNote(context.Background(), nil); err == nil {
+		t.Error("want error from transcriber")
+	}
+}
+
+func TestTranscribeVoiceNoteUnavailable(t *testing.T) {
+	ft := &fakeTranscriber{err: &StatusError{StatusCode: 503}}
+	s := &Server{
+		Transcriber: ft,
+		Gemini:      &Dependency{Name: "gemini", Retry: RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}},
+		state:       newLocalState(),
+	}
+
+	// 轉錄和名片辨識一樣經過 Gemini 的重試與斷路器
+	if _, err := s.transcribeVoiceNote(context.Background(), []byte("audio")); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("err = %v, want ErrUnavailable", err)
+	}
+}
+
+func TestWebhookVoiceNoteTargetIsSender(t *testing.T) {
+	h := newWebhookHarness(t)
+	ft := &fakeTranscriber{text: "對報價有興趣"}
+	h.Server.Transcriber = ft
+	h.SetCard("m1", "```json\n{\"name\":\"王小明\"}\n```")
+	h.Post(h.Image(groupSource("G1", "U1"), "m1"))
+
+	// 同一個群組的其他成員沒有新增名片，不下載語音也不補到 U1 的名片
+	before := len(h.Calls())
+	h.Post(h.Audio(groupSource("G1", "U2"), "a1"))
+	for _, c := range h.Calls()[before:] {
+		if strings.HasSuffix(c.Path, "/content") {
+			t.Fatalf("audio downloaded without a recent contact: %s", c.Path)
+		}
+	}
+	if got := h.Replies(); !strings.Contains(got[len(got)-1], "請先傳送名片照片") {
+		t.Errorf("reply = %s", got[len(got)-1])
+	}
+	if ft.audio != nil {
+		t.Errorf("transcribed %q for another member", ft.audio)
+	}
+
+	h.Post(h.Audio(groupSource("G1", "U1"), "a2"))
+	pageID, ok := h.Server.state.recent.Get(sessionKeyFor("G1", "U1"))
+	if !ok {
+		t.Fatal("no recent contact for the sender")
+	}
+	p, err := h.Notion.NotionDB("db", "G1").GetPage(context.Background(), pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(p.Notes, ft.text) || string(ft.audio) != "image:a2" {
+		t.Errorf("notes = %q, audio = %q", p.Notes, ft.audio)
+	}
+}